@@ -1,31 +1,120 @@
 package main
 
 import (
+	pb "breezeFS/breezeFS/proto"
 	"breezeFS/internal/client"
+	"breezeFS/internal/config"
 	"flag"
+	"fmt"
 	"log"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func main() {
 
-	operation := flag.String("op", "upload", "Operation type: upload or download")
+	operation := flag.String("op", "upload", "Operation type: upload, download, stat, list, find, get-attrs, set-attrs, versions, restore, prune-versions, copy, append, write, lease, renew-lease, release-lease, create-snapshot, list-snapshots, delete-snapshot, delete, set-retention, set-rule, list-rules, delete-rule, set-quota, delete-quota, usage, gc, rebalance, decommission, decommission-status, get-acl or set-acl")
 	filePath := flag.String("filepath", "", "Path to the file to upload or download")
-	chunkSize := flag.Int("chunksize", 1024*1024, "Size of each chunk in bytes (default 1MB)")
+	chunkSize := flag.Int("chunksize", 1024*1024, "Size of each chunk in bytes, the average size with content-defined chunking (default 1MB)")
+	threshold := flag.Float64("threshold", 0.1, "Allowed deviation from mean node utilisation when rebalancing")
+	bandwidth := flag.Int64("bandwidth", 0, "Bandwidth limit per chunk move in bytes per second when rebalancing or decommissioning (0 for unlimited)")
+	nodeID := flag.String("node", "", "ID or address of the Data Node to decommission")
+	targetPath := flag.String("path", "", "File ID, or directory ending in /, to get or set the ACL of, to stat or get or set the attributes of (defaults to the file ID of -filepath), or prefix to list or find")
+	owner := flag.String("owner", "", "New owner when setting an ACL (empty keeps the current owner), or owner to set the quota of or report the usage of")
+	group := flag.String("group", "", "New owning group when setting an ACL (empty keeps the current group)")
+	contentType := flag.String("content-type", "", "Content type to store with an upload (empty detects it from the extension and contents), or to find, such as image/*")
+	attrSpec := flag.String("attrs", "", "Attributes to set on upload or with set-attrs, or to find, e.g. project=apollo,team=data")
+	removeAttrs := flag.String("remove-attrs", "", "Comma separated attributes to remove with set-attrs")
+	fileType := flag.String("ext", "", "Original file extension to find")
+	minSize := flag.Int64("min-size", 0, "Smallest file size in bytes to find")
+	maxSize := flag.Int64("max-size", 0, "Largest file size in bytes to find (0 for no limit)")
+	createdAfter := flag.String("created-after", "", "Find files created at or after this time, RFC 3339, a date or a duration ago such as 24h")
+	createdBefore := flag.String("created-before", "", "Find files created before this time")
+	modifiedAfter := flag.String("modified-after", "", "Find files modified at or after this time")
+	modifiedBefore := flag.String("modified-before", "", "Find files modified before this time")
+	sortBy := flag.String("sort", "id", "Field to sort found files by: id, size, created or modified")
+	descending := flag.Bool("desc", false, "Sort found files in descending order")
+	pageSize := flag.Int("page-size", 0, "Files to find per page (0 for the server default)")
+	pageToken := flag.String("page-token", "", "Page of found files to continue from, as printed by the previous page")
+	version := flag.Int64("version", 0, "Version to download, restore, prune or copy (0 for the current version when downloading or copying)")
+	destination := flag.String("dest", "", "File ID to copy the file to")
+	copyClass := flag.String("copy-class", "", "Storage class of the copy: replicated or erasure (empty keeps that of the source)")
+	leaseID := flag.String("lease", "", "Write lease to append, write or set attributes under, or to renew or release (empty to take one for the write)")
+	leaseDuration := flag.Duration("lease-duration", 0, "How long a lease lasts unless renewed (0 for the server default)")
+	offset := flag.Int64("offset", 0, "Offset in the file to write -filepath at with write")
+	keepVersions := flag.Int("keep", 0, "Previous versions to keep when pruning without -version")
+	snapshotName := flag.String("snapshot", "", "Snapshot to create or delete, or to download, copy or list files from")
+	ttl := flag.Duration("ttl", 0, "How long after the upload, or from now with set-retention, the file expires, or how long after their last change files expire with set-rule")
+	expires := flag.String("expires", "", "When the uploaded file expires, or the file with set-retention, as an RFC 3339 time or a date")
+	clearExpiry := flag.Bool("clear-expiry", false, "Stop the file from expiring with set-retention")
+	retainUntil := flag.String("retain-until", "", "Time until which the file can't be deleted or overwritten, with set-retention")
+	retainFor := flag.Duration("retain", 0, "How long after their last change files can't be deleted or overwritten, with set-rule")
+	legalHold := flag.Bool("hold", false, "Place a legal hold on the file with set-retention")
+	releaseHold := flag.Bool("release-hold", false, "Release the legal hold on the file with set-retention")
+	ruleName := flag.String("rule", "", "Retention rule to set or delete")
+	hardFiles := flag.Int64("hard-files", 0, "Hard limit on the number of files with set-quota (0 for no limit)")
+	hardBytes := flag.Int64("hard-bytes", 0, "Hard limit on the size of the files in bytes with set-quota (0 for no limit)")
+	hardPhysical := flag.Int64("hard-physical", 0, "Hard limit on the bytes stored with every replica and version with set-quota (0 for no limit)")
+	softFiles := flag.Int64("soft-files", 0, "Soft limit on the number of files with set-quota (0 for no limit)")
+	softBytes := flag.Int64("soft-bytes", 0, "Soft limit on the size of the files in bytes with set-quota (0 for no limit)")
+	softPhysical := flag.Int64("soft-physical", 0, "Soft limit on the bytes stored with every replica and version with set-quota (0 for no limit)")
+	dryRun := flag.Bool("dry-run", false, "Only report the garbage chunks gc would delete")
+	aclSpec := flag.String("acl", "", "ACL entries to set, e.g. user:bob=rw,group:staff=r,group=r,other=r (group is the owning group, r read, w write, a admin)")
+
+	// Load settings from flags, environment and config file
+	cfg := config.Default()
+	config.RegisterFlags(flag.CommandLine, cfg, config.ManagerAddress, config.RequestTimeout,
+		config.TLSCert, config.TLSKey, config.TLSCA, config.APIKey, config.EncryptionKeyFile, config.Compression, config.Chunking, config.StorageClass)
 
 	// Parse the flags
 	flag.Parse()
+	if err := config.Load(flag.CommandLine, cfg); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	tlsConfig, err := cfg.ClientTLS()
+	if err != nil {
+		log.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+
+	encryptionKey, err := cfg.EncryptionKey()
+	if err != nil {
+		log.Fatalf("Failed to load encryption key: %v", err)
+	}
+
+	attributes, err := parseAttributes(*attrSpec)
+	if err != nil {
+		log.Fatalf("Failed to parse attributes: %v", err)
+	}
+
+	expiresAt, err := parseDeadline(*expires)
+	if err != nil {
+		log.Fatalf("Failed to parse expiry time: %v", err)
+	}
+
+	// Metadata stored with uploads, and what to download
+	uploadOptions := client.UploadOptions{ContentType: *contentType, Attributes: attributes, ExpiresAt: expiresAt, TTL: *ttl}
+	downloadOptions := client.DownloadOptions{Version: *version, Snapshot: *snapshotName}
 
 	// Initialize the client with the Manager Node address
-	client := client.NewClient("localhost:50051")
+	client := client.NewClient(cfg.ManagerAddress)
+	client.Timeout = cfg.RequestTimeout
+	client.TLSConfig = tlsConfig
+	client.APIKey = cfg.Auth.APIKey
+	client.EncryptionKey = encryptionKey
+	client.Compression = cfg.Compression
+	client.Chunking = cfg.Chunking
+	client.StorageClass = cfg.StorageClass
 
 	switch *operation {
 	case "upload":
 		// Upload the file
 		fileNameWithExt := filepath.Base(*filePath)
 		fileID := strings.TrimSuffix(fileNameWithExt, filepath.Ext(fileNameWithExt))
-		if err := client.UploadFile(*filePath, fileID, *chunkSize); err != nil { // Example chunk size: 1MB
+		if err := client.UploadFile(*filePath, fileID, *chunkSize, uploadOptions); err != nil { // Example chunk size: 1MB
 			log.Fatalf("Failed to upload file: %v", err)
 		}
 		log.Println("File uploaded successfully")
@@ -36,12 +125,552 @@ func main() {
 		fileID := strings.TrimSuffix(fileNameWithExt, filepath.Ext(fileNameWithExt))
 
 		// Download the file
-		if err := client.DownloadFile(fileID, *chunkSize); err != nil {
+		if err := client.DownloadFile(fileID, downloadOptions); err != nil {
 			log.Fatalf("Failed to download file: %v", err)
 		}
 		log.Println("File downloaded successfully")
 
+	case "stat":
+		info, err := client.StatFile(pathTarget(*targetPath, *filePath))
+		if err != nil {
+			log.Fatalf("Failed to stat file: %v", err)
+		}
+		printFileInfo(info)
+
+	case "list":
+		files, err := client.ListFiles(*targetPath, *snapshotName)
+		if err != nil {
+			log.Fatalf("Failed to list files: %v", err)
+		}
+		for _, info := range files {
+			printFileLine(info)
+		}
+
+	case "find":
+		req := &pb.QueryRequest{
+			Prefix:      *targetPath,
+			ContentType: *contentType,
+			FileType:    *fileType,
+			MinSize:     *minSize,
+			MaxSize:     *maxSize,
+			Attributes:  attributes,
+			SortBy:      *sortBy,
+			Descending:  *descending,
+			PageSize:    int32(*pageSize),
+			PageToken:   *pageToken,
+		}
+		for _, bound := range []struct {
+			value  string
+			target *int64
+		}{
+			{*createdAfter, &req.CreatedAfter},
+			{*createdBefore, &req.CreatedBefore},
+			{*modifiedAfter, &req.ModifiedAfter},
+			{*modifiedBefore, &req.ModifiedBefore},
+		} {
+			if *bound.target, err = parseTime(bound.value); err != nil {
+				log.Fatalf("Failed to parse time: %v", err)
+			}
+		}
+		resp, err := client.Query(req)
+		if err != nil {
+			log.Fatalf("Failed to find files: %v", err)
+		}
+		for _, info := range resp.Files {
+			printFileLine(info)
+		}
+		if resp.NextPageToken != "" {
+			fmt.Printf("More files found, continue with -page-token %s\n", resp.NextPageToken)
+		}
+
+	case "get-attrs":
+		attributes, err := client.GetAttributes(pathTarget(*targetPath, *filePath))
+		if err != nil {
+			log.Fatalf("Failed to get attributes: %v", err)
+		}
+		printAttributes(attributes)
+
+	case "set-attrs":
+		updated, err := client.SetAttributes(pathTarget(*targetPath, *filePath), attributes, splitList(*removeAttrs), *leaseID)
+		if err != nil {
+			log.Fatalf("Failed to set attributes: %v", err)
+		}
+		printAttributes(updated)
+
+	case "versions":
+		versions, err := client.ListVersions(pathTarget(*targetPath, *filePath))
+		if err != nil {
+			log.Fatalf("Failed to list versions: %v", err)
+		}
+		for i, info := range versions {
+			current := ""
+			if i == 0 {
+				current = "  (current)"
+			}
+			fmt.Printf("%6d %12d  %s  %s%s\n", info.Version, info.Size, time.Unix(info.ModifiedAt, 0).Format(time.RFC3339),
+				info.ContentType, current)
+		}
+
+	case "restore":
+		info, err := client.RestoreVersion(pathTarget(*targetPath, *filePath), *version)
+		if err != nil {
+			log.Fatalf("Failed to restore version: %v", err)
+		}
+		log.Printf("Version %d restored as version %d", *version, info.Version)
+
+	case "prune-versions":
+		pruned, err := client.PruneVersions(pathTarget(*targetPath, *filePath), *version, *keepVersions)
+		if err != nil {
+			log.Fatalf("Failed to prune versions: %v", err)
+		}
+		log.Printf("Deleted %d versions", pruned)
+
+	case "copy":
+		source := pathTarget(*targetPath, *filePath)
+		info, err := client.CopyFile(&pb.CopyFileRequest{
+			SourceId:      source,
+			DestinationId: *destination,
+			Version:       *version,
+			Snapshot:      *snapshotName,
+			StorageClass:  *copyClass,
+		})
+		if err != nil {
+			log.Fatalf("Failed to copy file: %v", err)
+		}
+		log.Printf("File %s copied to %s as version %d", source, info.FileId, info.Version)
+
+	case "append", "write":
+		fileID := pathTarget(*targetPath, *filePath)
+		lease := *leaseID
+		if lease == "" {
+			acquired, err := client.AcquireLease(fileID, *leaseDuration)
+			if err != nil {
+				log.Fatalf("Failed to acquire lease: %v", err)
+			}
+			lease = acquired.LeaseId
+		}
+		if *operation == "append" {
+			err = client.AppendFile(*filePath, fileID, lease, *chunkSize)
+		} else {
+			err = client.WriteFileAt(*filePath, fileID, lease, *offset, *chunkSize)
+		}
+		// A lease taken for this write only is given up whether or not it succeeded
+		if *leaseID == "" {
+			if releaseErr := client.ReleaseLease(fileID, lease); releaseErr != nil {
+				log.Printf("Failed to release lease: %v", releaseErr)
+			}
+		}
+		if err != nil {
+			log.Fatalf("Failed to %s file: %v", *operation, err)
+		}
+		log.Printf("File %s written", fileID)
+
+	case "lease":
+		lease, err := client.AcquireLease(pathTarget(*targetPath, *filePath), *leaseDuration)
+		if err != nil {
+			log.Fatalf("Failed to acquire lease: %v", err)
+		}
+		printLease(lease)
+
+	case "renew-lease":
+		lease, err := client.RenewLease(pathTarget(*targetPath, *filePath), *leaseID, *leaseDuration)
+		if err != nil {
+			log.Fatalf("Failed to renew lease: %v", err)
+		}
+		printLease(lease)
+
+	case "release-lease":
+		fileID := pathTarget(*targetPath, *filePath)
+		if err := client.ReleaseLease(fileID, *leaseID); err != nil {
+			log.Fatalf("Failed to release lease: %v", err)
+		}
+		log.Printf("Lease on file %s released", fileID)
+
+	case "create-snapshot":
+		snapshot, err := client.CreateSnapshot(*snapshotName, *targetPath)
+		if err != nil {
+			log.Fatalf("Failed to create snapshot: %v", err)
+		}
+		printSnapshot(snapshot)
+
+	case "list-snapshots":
+		snapshots, err := client.ListSnapshots()
+		if err != nil {
+			log.Fatalf("Failed to list snapshots: %v", err)
+		}
+		for _, snapshot := range snapshots {
+			printSnapshot(snapshot)
+		}
+
+	case "delete-snapshot":
+		if err := client.DeleteSnapshot(*snapshotName); err != nil {
+			log.Fatalf("Failed to delete snapshot: %v", err)
+		}
+		log.Printf("Snapshot %s deleted", *snapshotName)
+
+	case "delete":
+		fileID := pathTarget(*targetPath, *filePath)
+		if err := client.DeleteFile(fileID); err != nil {
+			log.Fatalf("Failed to delete file: %v", err)
+		}
+		log.Printf("File %s deleted", fileID)
+
+	case "set-retention":
+		retainTime, err := parseDeadline(*retainUntil)
+		if err != nil {
+			log.Fatalf("Failed to parse retention time: %v", err)
+		}
+		req := &pb.SetRetentionRequest{
+			FileId:      pathTarget(*targetPath, *filePath),
+			Ttl:         int64(*ttl / time.Second),
+			ClearExpiry: *clearExpiry,
+			LegalHold:   *legalHold,
+			ReleaseHold: *releaseHold,
+		}
+		if !expiresAt.IsZero() {
+			req.ExpiresAt = expiresAt.Unix()
+		}
+		if !retainTime.IsZero() {
+			req.RetainUntil = retainTime.Unix()
+		}
+		info, err := client.SetRetention(req)
+		if err != nil {
+			log.Fatalf("Failed to set retention: %v", err)
+		}
+		printFileInfo(info)
+
+	case "set-rule":
+		if len(attributes) > 1 {
+			log.Fatalf("Retention rules match at most one attribute, got %d", len(attributes))
+		}
+		rule := &pb.RetentionRule{
+			Name:        *ruleName,
+			Path:        *targetPath,
+			ExpireAfter: int64(*ttl / time.Second),
+			RetainFor:   int64(*retainFor / time.Second),
+		}
+		for key, value := range attributes {
+			rule.AttributeKey, rule.AttributeValue = key, value
+		}
+		rule, err := client.SetRetentionRule(rule)
+		if err != nil {
+			log.Fatalf("Failed to set retention rule: %v", err)
+		}
+		printRule(rule)
+
+	case "list-rules":
+		rules, err := client.ListRetentionRules()
+		if err != nil {
+			log.Fatalf("Failed to list retention rules: %v", err)
+		}
+		for _, rule := range rules {
+			printRule(rule)
+		}
+
+	case "delete-rule":
+		if err := client.DeleteRetentionRule(*ruleName); err != nil {
+			log.Fatalf("Failed to delete retention rule: %v", err)
+		}
+		log.Printf("Retention rule %s deleted", *ruleName)
+
+	case "set-quota":
+		quota, err := client.SetQuota(&pb.Quota{
+			Owner: *owner,
+			Path:  *targetPath,
+			Hard:  &pb.Usage{Files: *hardFiles, LogicalBytes: *hardBytes, PhysicalBytes: *hardPhysical},
+			Soft:  &pb.Usage{Files: *softFiles, LogicalBytes: *softBytes, PhysicalBytes: *softPhysical},
+		})
+		if err != nil {
+			log.Fatalf("Failed to set quota: %v", err)
+		}
+		printUsage(&pb.UsageEntry{Owner: quota.Owner, Path: quota.Path, Usage: &pb.Usage{}, Quota: quota})
+
+	case "delete-quota":
+		if err := client.DeleteQuota(*owner, *targetPath); err != nil {
+			log.Fatalf("Failed to delete quota: %v", err)
+		}
+		log.Println("Quota deleted")
+
+	case "usage":
+		entries, err := client.GetUsage(*owner, *targetPath)
+		if err != nil {
+			log.Fatalf("Failed to get usage: %v", err)
+		}
+		for _, entry := range entries {
+			printUsage(entry)
+		}
+
+	case "gc":
+		resp, err := client.CollectGarbage(*dryRun)
+		if err != nil {
+			log.Fatalf("Failed to collect garbage: %v", err)
+		}
+		for _, node := range resp.Nodes {
+			if !*dryRun {
+				fmt.Printf("%s: deleted %d of %d garbage chunks, %d failed\n", node.NodeId, node.Deleted, len(node.Hashes), node.Failed)
+				continue
+			}
+			fmt.Printf("%s: %d garbage chunks\n", node.NodeId, len(node.Hashes))
+			for _, hash := range node.Hashes {
+				fmt.Printf("  %s\n", hash)
+			}
+		}
+		log.Println(resp.Message)
+
+	case "rebalance":
+		resp, err := client.Rebalance(*threshold, *bandwidth)
+		if err != nil {
+			log.Fatalf("Failed to rebalance cluster: %v", err)
+		}
+		log.Println(resp.Message)
+
+	case "decommission":
+		resp, err := client.DecommissionNode(*nodeID, *bandwidth)
+		if err != nil {
+			log.Fatalf("Failed to decommission node: %v", err)
+		}
+		log.Println(resp.Message)
+
+	case "decommission-status":
+		resp, err := client.GetDecommissionStatus(*nodeID)
+		if err != nil {
+			log.Fatalf("Failed to get decommission status: %v", err)
+		}
+		log.Println(resp.Message)
+
+	case "get-acl":
+		acl, err := client.GetACL(pathTarget(*targetPath, *filePath))
+		if err != nil {
+			log.Fatalf("Failed to get ACL: %v", err)
+		}
+		printACL(acl)
+
+	case "set-acl":
+		entries, err := parseACLEntries(*aclSpec)
+		if err != nil {
+			log.Fatalf("Failed to parse ACL: %v", err)
+		}
+		acl, err := client.SetACL(pathTarget(*targetPath, *filePath), *owner, *group, entries)
+		if err != nil {
+			log.Fatalf("Failed to set ACL: %v", err)
+		}
+		printACL(acl)
+
 	default:
-		log.Fatalf("Invalid operation: %s. Use 'upload' or 'download'", *operation)
+		log.Fatalf("Invalid operation: %s. Use 'upload', 'download', 'stat', 'list', 'find', 'get-attrs', 'set-attrs', 'versions', 'restore', 'prune-versions', 'create-snapshot', 'list-snapshots', 'delete-snapshot', 'rebalance', 'decommission', 'decommission-status', 'get-acl' or 'set-acl'", *operation)
+	}
+}
+
+// pathTarget returns the path given with -path, or the file ID of -filepath
+func pathTarget(path, filePath string) string {
+	if path != "" {
+		return path
+	}
+	fileNameWithExt := filepath.Base(filePath)
+	return strings.TrimSuffix(fileNameWithExt, filepath.Ext(fileNameWithExt))
+}
+
+// printLease prints a write lease and the file it was taken on
+func printLease(lease *pb.Lease) {
+	fmt.Printf("lease:         %s\n", lease.LeaseId)
+	fmt.Printf("holder:        %s\n", lease.Holder)
+	fmt.Printf("expires:       %s\n", time.Unix(lease.ExpiresAt, 0).Format(time.RFC3339))
+	printFileInfo(lease.File)
+}
+
+// printACL prints an ACL one entry per line
+func printACL(acl *pb.ACL) {
+	if acl.Owner == "" {
+		fmt.Printf("%s: no ACL, open to everyone\n", acl.Path)
+		return
+	}
+	fmt.Printf("path:  %s\n", acl.Path)
+	if acl.InheritedFrom != "" {
+		fmt.Printf("from:  %s\n", acl.InheritedFrom)
+	}
+	fmt.Printf("owner: %s\n", acl.Owner)
+	fmt.Printf("group: %s\n", acl.Group)
+	for _, entry := range acl.Entries {
+		fmt.Printf("%s=%s\n", entry.Principal, entry.Permissions)
+	}
+}
+
+// parseACLEntries parses a comma separated list of principal=permissions entries
+func parseACLEntries(spec string) ([]*pb.ACLEntry, error) {
+	var entries []*pb.ACLEntry
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		principal, permissions, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid ACL entry %q, expected principal=permissions", item)
+		}
+		entries = append(entries, &pb.ACLEntry{Principal: principal, Permissions: permissions})
+	}
+	return entries, nil
+}
+
+// printFileInfo prints the metadata of a file one field per line
+func printFileInfo(info *pb.FileInfo) {
+	fmt.Printf("file:          %s\n", info.FileId)
+	fmt.Printf("size:          %d\n", info.Size)
+	fmt.Printf("chunks:        %d\n", info.Chunks)
+	fmt.Printf("type:          %s\n", info.FileType)
+	fmt.Printf("content type:  %s\n", info.ContentType)
+	fmt.Printf("storage class: %s\n", info.StorageClass)
+	fmt.Printf("encrypted:     %t\n", info.Encrypted)
+	fmt.Printf("created:       %s\n", time.Unix(info.CreatedAt, 0).Format(time.RFC3339))
+	fmt.Printf("modified:      %s\n", time.Unix(info.ModifiedAt, 0).Format(time.RFC3339))
+	if info.ExpiresAt != 0 {
+		fmt.Printf("expires:       %s\n", time.Unix(info.ExpiresAt, 0).Format(time.RFC3339))
+	}
+	if info.RetainUntil != 0 {
+		fmt.Printf("retain until:  %s\n", time.Unix(info.RetainUntil, 0).Format(time.RFC3339))
+	}
+	if info.LegalHold {
+		fmt.Printf("legal hold:    %t\n", info.LegalHold)
+	}
+	printAttributes(info.Attributes)
+}
+
+// printFileLine prints a file on one line for listings
+func printFileLine(info *pb.FileInfo) {
+	fmt.Printf("%-40s %12d  %s  %s%s\n", info.FileId, info.Size, time.Unix(info.ModifiedAt, 0).Format(time.RFC3339),
+		info.ContentType, formatAttributes(info.Attributes))
+}
+
+// printSnapshot prints a snapshot on one line
+func printSnapshot(snapshot *pb.Snapshot) {
+	fmt.Printf("%-24s %-24s %s  %6d files %12d bytes\n", snapshot.Name, snapshot.Path,
+		time.Unix(snapshot.CreatedAt, 0).Format(time.RFC3339), snapshot.Files, snapshot.Size)
+}
+
+// printRule prints a retention rule on one line
+func printRule(rule *pb.RetentionRule) {
+	match := "*"
+	if rule.AttributeKey != "" {
+		match = rule.AttributeKey + "=" + rule.AttributeValue
+	}
+	fmt.Printf("%-24s %-24s %-24s expire after %-10s retain for %s\n", rule.Name, rule.Path, match,
+		time.Duration(rule.ExpireAfter)*time.Second, time.Duration(rule.RetainFor)*time.Second)
+}
+
+// printUsage prints the usage of an owner or a directory, with its limits if it has a quota
+func printUsage(entry *pb.UsageEntry) {
+	name := "owner " + entry.Owner
+	if entry.Path != "" {
+		name = "directory " + entry.Path
+	}
+	state := ""
+	switch {
+	case entry.HardExceeded:
+		state = " (over hard quota)"
+	case entry.SoftExceeded:
+		state = " (over soft quota)"
+	}
+	fmt.Printf("%s%s\n", name, state)
+
+	usage, quota := entry.Usage, entry.Quota
+	if quota == nil {
+		quota = &pb.Quota{Hard: &pb.Usage{}, Soft: &pb.Usage{}}
+	}
+	fmt.Printf("  files:          %s\n", formatUsage(usage.Files, quota.Soft.Files, quota.Hard.Files))
+	fmt.Printf("  bytes:          %s\n", formatUsage(usage.LogicalBytes, quota.Soft.LogicalBytes, quota.Hard.LogicalBytes))
+	fmt.Printf("  physical bytes: %s\n", formatUsage(usage.PhysicalBytes, quota.Soft.PhysicalBytes, quota.Hard.PhysicalBytes))
+}
+
+// formatUsage formats a usage figure with its soft and hard limits, leaving out those not set
+func formatUsage(used, soft, hard int64) string {
+	formatted := strconv.FormatInt(used, 10)
+	if soft > 0 {
+		formatted += fmt.Sprintf(", soft limit %d", soft)
+	}
+	if hard > 0 {
+		formatted += fmt.Sprintf(", hard limit %d", hard)
+	}
+	return formatted
+}
+
+// printAttributes prints attributes one per line, ordered by key
+func printAttributes(attributes map[string]string) {
+	for _, key := range sortedKeys(attributes) {
+		fmt.Printf("%s=%s\n", key, attributes[key])
+	}
+}
+
+// formatAttributes formats attributes on one line for listings, ordered by key
+func formatAttributes(attributes map[string]string) string {
+	var b strings.Builder
+	for _, key := range sortedKeys(attributes) {
+		fmt.Fprintf(&b, " %s=%s", key, attributes[key])
+	}
+	return b.String()
+}
+
+// sortedKeys returns the keys of attributes in order
+func sortedKeys(attributes map[string]string) []string {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseAttributes parses a comma separated list of key=value attributes
+func parseAttributes(spec string) (map[string]string, error) {
+	attributes := make(map[string]string)
+	for _, item := range splitList(spec) {
+		key, value, found := strings.Cut(item, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid attribute %q, expected key=value", item)
+		}
+		attributes[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	return attributes, nil
+}
+
+// parseTime parses an RFC 3339 time, a date, or a duration before now into Unix seconds.
+// An empty value gives 0, no bound.
+func parseTime(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t.Unix(), nil
+	}
+	if ago, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-ago).Unix(), nil
+	}
+	return 0, fmt.Errorf("invalid time %q, expected RFC 3339, a date or a duration such as 24h", value)
+}
+
+// parseDeadline parses an RFC 3339 time or a date. An empty value gives the zero time.
+func parseDeadline(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or a date", value)
+}
+
+// splitList splits a comma separated list, dropping empty items
+func splitList(spec string) []string {
+	var items []string
+	for _, item := range strings.Split(spec, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
+	return items
 }
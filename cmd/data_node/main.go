@@ -1,16 +1,56 @@
 package main
 
 import (
+	"breezeFS/internal/config"
 	"breezeFS/internal/server"
+	"flag"
+	"log"
 )
 
 func main() {
-	// Define the addresses
-	managerAddress := "localhost:50051" // Address of the Manager Node
-	dataNodeAddress := "localhost"      // Address of this Data Node
+	// Load settings from flags, environment and config file
+	cfg := config.Default()
+	config.RegisterFlags(flag.CommandLine, cfg,
+		config.ManagerAddress, config.NodeListen, config.NodeAdvertise, config.DataDir,
+		config.RequestTimeout, config.ReportInterval, config.FullReportInterval,
+		config.TLSCert, config.TLSKey, config.TLSCA, config.TokenSecretFile,
+		config.NodeKeyFile, config.KeyRotation)
+	flag.Parse()
+	if err := config.Load(flag.CommandLine, cfg); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	serverTLS, err := cfg.ServerTLS()
+	if err != nil {
+		log.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+	clientTLS, err := cfg.ClientTLS()
+	if err != nil {
+		log.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+
+	tokens, err := cfg.TokenSigner()
+	if err != nil {
+		log.Fatalf("Failed to load token secret: %v", err)
+	}
+
+	keys, err := cfg.NodeKeys()
+	if err != nil {
+		log.Fatalf("Failed to load node keys: %v", err)
+	}
 
 	// Create a new Data Node instance
-	dataNode := server.NewDataNode(managerAddress, dataNodeAddress)
+	dataNode := server.NewDataNode(cfg.ManagerAddress, cfg.NodeListen)
+	dataNode.AdvertiseHost = cfg.NodeAdvertise
+	dataNode.DataDir = cfg.DataDir
+	dataNode.RequestTimeout = cfg.RequestTimeout
+	dataNode.ReportInterval = cfg.ReportInterval
+	dataNode.FullReportInterval = cfg.FullReportInterval
+	dataNode.ServerTLS = serverTLS
+	dataNode.ClientTLS = clientTLS
+	dataNode.Tokens = tokens
+	dataNode.Keys = keys
+	dataNode.KeyRotationInterval = cfg.Encryption.KeyRotationInterval
 
 	// Start the HTTP server for chunk operations
 	dataNode.StartHTTPServer()
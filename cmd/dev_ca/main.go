@@ -0,0 +1,22 @@
+package main
+
+import (
+	"breezeFS/internal/security"
+	"flag"
+	"log"
+	"strings"
+)
+
+func main() {
+	outDir := flag.String("out", "certs", "Directory to write the CA and certificates to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1", "Comma separated host names and IPs the node certificate is valid for")
+
+	// Parse the flags
+	flag.Parse()
+
+	// Generate a CA plus node and client certificates for a local cluster
+	if err := security.GenerateDevCA(*outDir, strings.Split(*hosts, ",")); err != nil {
+		log.Fatalf("Failed to generate dev CA: %v", err)
+	}
+	log.Printf("Dev CA and certificates written to %s", *outDir)
+}
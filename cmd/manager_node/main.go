@@ -2,26 +2,107 @@ package main
 
 import (
 	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/config"
+	"breezeFS/internal/security"
 	"breezeFS/internal/server"
+	"flag"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"log"
 	"net"
 )
 
 func main() {
-	// Set up a listener on port 50051
-	lis, err := net.Listen("tcp", ":50051")
+	// Load settings from flags, environment and config file
+	cfg := config.Default()
+	config.RegisterFlags(flag.CommandLine, cfg,
+		config.ManagerListen, config.Replication, config.Compression, config.Versions,
+		config.GCInterval, config.GCGracePeriod, config.ExpiryInterval,
+		config.RebalanceInterval, config.RebalanceThreshold, config.RebalanceBandwidth,
+		config.DataShards, config.ParityShards, config.RepairInterval,
+		config.TLSCert, config.TLSKey, config.TLSCA,
+		config.APIKeysFile, config.TokenSecretFile, config.TokenTTL, config.RootOwner, config.AdminGroup)
+	flag.Parse()
+	if err := config.Load(flag.CommandLine, cfg); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Set up a listener on the configured address
+	lis, err := net.Listen("tcp", cfg.ManagerListen)
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
 	}
 
-	// Create a new gRPC server
-	grpcServer := grpc.NewServer()
+	serverTLS, err := cfg.ServerTLS()
+	if err != nil {
+		log.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+	clientTLS, err := cfg.ClientTLS()
+	if err != nil {
+		log.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+
+	tokens, err := cfg.TokenSigner()
+	if err != nil {
+		log.Fatalf("Failed to load token secret: %v", err)
+	}
+
+	// Create the ManagerNode, authenticating clients if API keys are configured
+	manager := server.NewManagerNode()
+	manager.ReplicationFactor = cfg.Replication
+	manager.Compression = cfg.Compression
+	manager.DataShards = cfg.Erasure.DataShards
+	manager.ParityShards = cfg.Erasure.ParityShards
+	manager.VersionsKept = cfg.Versions
+	manager.GCGracePeriod = cfg.GCGracePeriod
+	manager.DataNodeTLS = clientTLS
+	manager.RequireNodeCerts = serverTLS != nil
+	manager.Tokens = tokens
+	if cfg.Auth.APIKeysFile != "" {
+		manager.APIKeys, manager.Groups, err = security.LoadAPIKeys(cfg.Auth.APIKeysFile)
+		if err != nil {
+			log.Fatalf("Failed to load API keys: %v", err)
+		}
+
+		// Without an owner of the root directory nobody may administer the cluster
+		if cfg.Auth.RootOwner != "" || cfg.Auth.AdminGroup != "" {
+			manager.SeedRootACL(cfg.Auth.RootOwner, cfg.Auth.AdminGroup)
+		} else {
+			log.Printf("No -root-owner or -admin-group given, cluster administration is disabled")
+		}
+	}
+
+	// Create a new gRPC server, with TLS if configured
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(manager.UnaryInterceptor)}
+	if serverTLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(serverTLS)))
+	}
+	grpcServer := grpc.NewServer(opts...)
 
 	// Register the ManagerNode service with the gRPC server
-	pb.RegisterManagerServiceServer(grpcServer, server.NewManagerNode())
+	pb.RegisterManagerServiceServer(grpcServer, manager)
+
+	// Periodically even out chunk distribution across Data Nodes
+	if cfg.RebalanceInterval > 0 {
+		manager.StartRebalancer(cfg.RebalanceInterval, cfg.RebalanceThreshold, cfg.RebalanceBandwidth)
+	}
+
+	// Periodically rebuild lost shards of erasure coded chunks
+	if cfg.Erasure.RepairInterval > 0 {
+		manager.StartRepair(cfg.Erasure.RepairInterval)
+	}
+
+	// Periodically delete chunks nothing refers to any more
+	if cfg.GCInterval > 0 {
+		manager.StartGarbageCollector(cfg.GCInterval)
+	}
+
+	// Periodically delete files that expired
+	if cfg.ExpiryInterval > 0 {
+		manager.StartExpirer(cfg.ExpiryInterval)
+	}
 
-	log.Println("Manager Node is running on port 50051")
+	log.Printf("Manager Node is running on %s", lis.Addr())
 	// Start serving incoming connections
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatalf("Failed to serve: %v", err)
@@ -1,13 +1,17 @@
 module breezeFS
 
-go 1.21
+go 1.22
 
 require (
+	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/reedsolomon v1.10.0
 	google.golang.org/grpc v1.66.0 // Latest gRPC version
 	google.golang.org/protobuf v1.34.1 // Latest Protocol Buffers version
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
+	github.com/klauspost/cpuid/v2 v2.2.5 // indirect
 	golang.org/x/net v0.26.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
 	golang.org/x/text v0.16.0 // indirect
@@ -0,0 +1,164 @@
+package chunking
+
+import (
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Modes files can be cut into chunks with
+const (
+	Fixed = "fixed" // Every chunk has the same size, except for the last one
+	CDC   = "cdc"   // Chunk boundaries are picked by the content, so edits only change nearby chunks
+)
+
+// MaxChunkSize is the largest chunk any mode may cut, so a chunk never takes more than this
+// much memory to upload or download. The Manager Node rejects chunks declared larger.
+const MaxChunkSize = 64 * 1024 * 1024
+
+// Params bounds the sizes of the chunks a file is cut into. Fixed size chunking is the
+// special case where Min and Max are equal.
+type Params struct {
+	Min int // No boundary is placed before this many bytes
+	Avg int // Size chunks are normalized around
+	Max int // A boundary is forced after this many bytes
+}
+
+// NewParams returns the chunk size bounds for a mode. In CDC mode size is the average
+// chunk size, and chunks are between a quarter and four times as large.
+func NewParams(mode string, size int) (Params, error) {
+	if size <= 0 {
+		return Params{}, fmt.Errorf("chunk size must be positive, got %d", size)
+	}
+	var params Params
+	switch mode {
+	case Fixed:
+		params = Params{Min: size, Avg: size, Max: size}
+	case CDC:
+		if size < 64 {
+			return Params{}, fmt.Errorf("average chunk size must be at least 64 bytes for content-defined chunking, got %d", size)
+		}
+		params = Params{Min: size / 4, Avg: size, Max: size * 4}
+	default:
+		return Params{}, fmt.Errorf("unknown chunking mode %q, expected %s or %s", mode, Fixed, CDC)
+	}
+	if params.Max > MaxChunkSize {
+		return Params{}, fmt.Errorf("chunks of up to %d bytes are too large, the limit is %d", params.Max, MaxChunkSize)
+	}
+	return params, nil
+}
+
+// Supported reports whether mode is known
+func Supported(mode string) bool {
+	return mode == Fixed || mode == CDC
+}
+
+// gear maps every byte to a random value for the rolling hash. It is generated from a fixed
+// seed and must never change, or identical content would be cut differently and stop deduplicating.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x427265657a654653) // "BreezeFS"
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker cuts a stream into chunks using FastCDC: a gear rolling hash picks boundaries where
+// its top bits are zero, with a stricter mask before the average size and a looser one after,
+// which keeps chunk sizes close to the average.
+type Chunker struct {
+	r      io.Reader
+	params Params
+	maskS  uint64 // Mask used before the average size
+	maskL  uint64 // Mask used after the average size
+
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+// NewChunker returns a Chunker reading from r
+func NewChunker(r io.Reader, params Params) *Chunker {
+	level := bits.Len(uint(params.Avg)) - 1
+	return &Chunker{
+		r:      r,
+		params: params,
+		maskS:  topBits(level + 2),
+		maskL:  topBits(level - 2),
+		buf:    make([]byte, 2*params.Max),
+	}
+}
+
+// Next returns the next chunk, or io.EOF at the end of the stream. The chunk is only
+// valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	// Keep at least a maximum sized chunk buffered so boundaries don't depend on read sizes
+	if c.end-c.start < c.params.Max && !c.eof {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+		n, err := io.ReadFull(c.r, c.buf[c.end:])
+		c.end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	n := c.cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
+
+// cut returns the length of the chunk at the start of data
+func (c *Chunker) cut(data []byte) int {
+	n := len(data)
+	if n > c.params.Max {
+		n = c.params.Max
+	}
+	if n <= c.params.Min {
+		return n
+	}
+	normal := c.params.Avg
+	if normal > n {
+		normal = n
+	}
+
+	var hash uint64
+	i := c.params.Min
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// topBits returns a mask of the n most significant bits. The gear hash shifts older
+// bytes out to the left, so the top bits depend on the last 64 bytes.
+func topBits(n int) uint64 {
+	if n <= 0 {
+		return 0
+	}
+	if n >= 64 {
+		return ^uint64(0)
+	}
+	return ^uint64(0) << (64 - n)
+}
@@ -0,0 +1,144 @@
+package chunking
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestNewParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		mode    string
+		size    int
+		want    Params
+		wantErr bool
+	}{
+		{"fixed", Fixed, 1024, Params{Min: 1024, Avg: 1024, Max: 1024}, false},
+		{"cdc", CDC, 1024, Params{Min: 256, Avg: 1024, Max: 4096}, false},
+		{"cdc too small", CDC, 32, Params{}, true},
+		{"zero size", Fixed, 0, Params{}, true},
+		{"unknown mode", "rabin", 1024, Params{}, true},
+		{"fixed at the limit", Fixed, MaxChunkSize, Params{Min: MaxChunkSize, Avg: MaxChunkSize, Max: MaxChunkSize}, false},
+		{"fixed over the limit", Fixed, MaxChunkSize + 1, Params{}, true},
+		{"cdc over the limit", CDC, MaxChunkSize / 2, Params{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewParams(tt.mode, tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewParams(%q, %d) error = %v, want error %v", tt.mode, tt.size, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NewParams(%q, %d) = %+v, want %+v", tt.mode, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+// chunks cuts data with params and returns copies of the chunks
+func chunks(t *testing.T, r io.Reader, params Params) [][]byte {
+	t.Helper()
+	chunker := NewChunker(r, params)
+	var out [][]byte
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return out
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		out = append(out, append([]byte(nil), chunk...))
+	}
+}
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestChunkerSizes(t *testing.T) {
+	data := randomData(1<<20, 1)
+	fixed, _ := NewParams(Fixed, 4096)
+	cdc, _ := NewParams(CDC, 4096)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		params Params
+	}{
+		{"fixed", data, fixed},
+		{"fixed with a short last chunk", data[:10000], fixed},
+		{"cdc", data, cdc},
+		{"cdc shorter than the minimum", data[:100], cdc},
+		{"empty", nil, cdc},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chunks(t, bytes.NewReader(tt.data), tt.params)
+			if joined := bytes.Join(got, nil); !bytes.Equal(joined, tt.data) {
+				t.Fatalf("chunks don't add up to the input")
+			}
+			for i, chunk := range got {
+				if len(chunk) > tt.params.Max {
+					t.Errorf("chunk %d has %d bytes, more than %d", i, len(chunk), tt.params.Max)
+				}
+				if i < len(got)-1 && len(chunk) < tt.params.Min {
+					t.Errorf("chunk %d has %d bytes, less than %d", i, len(chunk), tt.params.Min)
+				}
+			}
+		})
+	}
+}
+
+// oneByteReader returns a byte per read, so boundaries can't depend on read sizes
+type oneByteReader struct{ r io.Reader }
+
+func (o oneByteReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return o.r.Read(p[:1])
+}
+
+func TestChunkerIgnoresReadSizes(t *testing.T) {
+	data := randomData(256*1024, 2)
+	params, _ := NewParams(CDC, 4096)
+
+	want := chunks(t, bytes.NewReader(data), params)
+	got := chunks(t, oneByteReader{bytes.NewReader(data)}, params)
+	if len(got) != len(want) {
+		t.Fatalf("got %d chunks reading a byte at a time, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) {
+			t.Fatalf("chunk %d differs when reading a byte at a time", i)
+		}
+	}
+}
+
+func TestChunkerInsertOnlyChangesNearbyChunks(t *testing.T) {
+	data := randomData(512*1024, 3)
+	params, _ := NewParams(CDC, 4096)
+
+	// Insert a few bytes in the middle, most chunks should be cut the same way
+	edited := append(append(append([]byte(nil), data[:256*1024]...), "inserted"...), data[256*1024:]...)
+
+	before := make(map[string]bool)
+	for _, chunk := range chunks(t, bytes.NewReader(data), params) {
+		before[string(chunk)] = true
+	}
+	after := chunks(t, bytes.NewReader(edited), params)
+	changed := 0
+	for _, chunk := range after {
+		if !before[string(chunk)] {
+			changed++
+		}
+	}
+	if changed > 3 {
+		t.Errorf("%d of %d chunks changed after a small insert", changed, len(after))
+	}
+}
@@ -2,101 +2,199 @@ package client
 
 import (
 	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/chunking"
+	"breezeFS/internal/compression"
+	"breezeFS/internal/erasure"
+	"breezeFS/internal/security"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 	"io"
 	"log"
 	"net/http"
+	neturl "net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 // Client represents the structure for the client to handle file operations
 type Client struct {
 	ManagerAddress string
+	Timeout        time.Duration // Timeout for requests to the Manager Node
+	TLSConfig      *tls.Config   // TLS configuration for the Manager Node and Data Nodes, nil for plain connections
+	APIKey         string        // Key identifying the user to the Manager Node, empty for anonymous access
+	EncryptionKey  []byte        // User key for client-side encryption of uploads, nil to upload plaintext
+	Compression    string        // Preferred codec for uploads, none to upload chunks uncompressed
+	Chunking       string        // How uploads are cut into chunks, fixed or cdc
+	StorageClass   string        // How uploads are stored, replicated or erasure
+
+	dataNodeHTTP *http.Client // Created on first use from TLSConfig
 }
 
 // NewClient creates a new client with the given manager address
 func NewClient(managerAddress string) *Client {
-	return &Client{ManagerAddress: managerAddress}
+	return &Client{ManagerAddress: managerAddress, Timeout: 5 * time.Second, Compression: compression.Zstd, Chunking: chunking.Fixed, StorageClass: erasure.ClassReplicated}
+}
+
+// dial connects to the Manager Node, sending the API key with every request
+func (c *Client) dial() (*grpc.ClientConn, error) {
+	withAPIKey := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if c.APIKey != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, security.APIKeyHeader, c.APIKey)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	return grpc.Dial(c.ManagerAddress, security.DialOption(c.TLSConfig), grpc.WithUnaryInterceptor(withAPIKey))
+}
+
+// httpClient returns the HTTP client used to talk to Data Nodes
+func (c *Client) httpClient() *http.Client {
+	if c.dataNodeHTTP == nil {
+		c.dataNodeHTTP = security.HTTPClient(c.TLSConfig)
+	}
+	return c.dataNodeHTTP
 }
 
 // GetNodesForChunks requests the Manager Node for addresses of Data Nodes for chunk uploads
-func (c *Client) GetNodesForChunks(fileID string, totalChunks int, fileType string) ([]*pb.ChunkNodeInfo, error) {
-	conn, err := grpc.Dial(c.ManagerAddress, grpc.WithInsecure())
+// and the codec to compress them with
+func (c *Client) GetNodesForChunks(req *pb.GetNodesForChunksRequest) (*pb.GetNodesForChunksResponse, error) {
+	conn, err := c.dial()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
 	}
 	defer conn.Close()
 
 	client := pb.NewManagerServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
 	defer cancel()
 
-	req := &pb.GetNodesForChunksRequest{
-		FileId:      fileID,
-		TotalChunks: int32(totalChunks),
-		FileType:    fileType,
-	}
-
 	resp, err := client.GetNodesForChunks(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get nodes for chunks: %v", err)
 	}
 
-	return resp.Nodes, nil
+	return resp, nil
 }
 
-// UploadChunk uploads a single chunk to the specified Data Nodes
-// UploadChunk uploads a chunk of data to the specified nodes without using multipart/form-data.
-func (c *Client) UploadChunk(chunk []byte, fileID, chunkID string, nodeAddresses []string) error {
-	for _, nodeAddress := range nodeAddresses {
-		// Construct the URL with query parameters to identify the file and chunk
-		url := fmt.Sprintf("http://%s/upload?file_id=%s&chunk_id=%s", nodeAddress, fileID, chunkID)
+// FindChunks asks the Manager Node which of the chunks with the given hashes are already stored
+func (c *Client) FindChunks(fileID string, hashes []string) (map[string]bool, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
 
-		// Create an HTTP POST request with the raw chunk data
-		req, err := http.NewRequest("POST", url, bytes.NewReader(chunk))
-		if err != nil {
-			return fmt.Errorf("failed to create request: %v", err)
-		}
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
 
-		// Set headers to indicate raw binary data
-		req.Header.Set("Content-Type", "application/octet-stream")
+	req := &pb.FindChunksRequest{
+		FileId: fileID,
+		Hashes: hashes,
+	}
 
-		// Execute the request
-		resp, err := http.DefaultClient.Do(req)
-		if err != nil {
-			return fmt.Errorf("failed to upload chunk: %v", err)
-		}
-		defer resp.Body.Close()
+	resp, err := client.FindChunks(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find chunks: %v", err)
+	}
 
-		// Check if the server responded with a status OK
-		if resp.StatusCode != http.StatusOK {
-			responseBody, _ := io.ReadAll(resp.Body)
-			return fmt.Errorf("error response from server: %s", responseBody)
-		}
+	existing := make(map[string]bool, len(resp.Existing))
+	for _, hash := range resp.Existing {
+		existing[hash] = true
+	}
+	return existing, nil
+}
 
-		log.Printf("Chunk %s uploaded to %s successfully", chunkID, nodeAddress)
+// UploadChunk uploads a chunk of data without using multipart/form-data. The chunk is sent once,
+// to the first node, which passes it down a pipeline through the other nodes and only answers
+// once all of them stored it. The chunk is stored under its hash, the token is the upload token
+// issued by the Manager Node for this chunk. The codec is the one the chunk is compressed with, for
+// the nodes to confirm its uncompressed size, and empty when they can't decompress it because it is
+// encrypted or a shard. It returns the first node's receipt for the stored chunk, which is empty when
+// the cluster doesn't use tokens.
+func (c *Client) UploadChunk(chunk []byte, hash, codec, fileID, chunkID, token string, nodeAddresses []string) (string, error) {
+	if len(nodeAddresses) == 0 {
+		return "", fmt.Errorf("no nodes assigned to chunk %s", chunkID)
 	}
-	return nil
+
+	// Construct the URL with query parameters to identify the chunk and the rest of the pipeline
+	url := fmt.Sprintf("%s://%s/upload?hash=%s&file_id=%s&chunk_id=%s&token=%s", security.Scheme(c.TLSConfig), nodeAddresses[0], hash, fileID, chunkID, token)
+	if codec != "" {
+		url += "&codec=" + neturl.QueryEscape(codec)
+	}
+	if len(nodeAddresses) > 1 {
+		url += "&hop=0&pipeline=" + neturl.QueryEscape(strings.Join(nodeAddresses, ","))
+	}
+
+	// Create an HTTP POST request with the raw chunk data
+	req, err := http.NewRequest("POST", url, bytes.NewReader(chunk))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	// Set headers to indicate raw binary data
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	// Execute the request
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to upload chunk: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Check if the server responded with a status OK
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(resp.Body)
+		return "", fmt.Errorf("error response from server: %s", responseBody)
+	}
+
+	log.Printf("Chunk %s uploaded to %s successfully", chunkID, strings.Join(nodeAddresses, ", "))
+	return resp.Header.Get(security.ReceiptHeader), nil
+}
+
+// UploadOptions holds optional metadata stored with an uploaded file
+type UploadOptions struct {
+	ContentType string            // Content type to serve the file with, empty to pick one from its extension and contents
+	Attributes  map[string]string // User-defined attributes to add to the file
+	ExpiresAt   time.Time         // When the file expires, zero to keep the current expiry
+	TTL         time.Duration     // How long after the upload the file expires, instead of ExpiresAt
 }
 
 // UploadFile handles splitting the file and uploading them to assigned nodes
-func (c *Client) UploadFile(filePath, fileID string, chunkSize int) error {
+func (c *Client) UploadFile(filePath, fileID string, chunkSize int, opts UploadOptions) error {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to open file: %v", err)
 	}
 	defer file.Close()
 
-	fileInfo, err := file.Stat()
+	detectedType, err := detectContentType(file)
+	if err != nil {
+		return err
+	}
+
+	params, err := chunking.NewParams(c.Chunking, chunkSize)
+	if err != nil {
+		return err
+	}
+
+	// Find the chunk boundaries first, the Manager Node assigns nodes per chunk
+	lengths, err := chunkLengths(file, params)
 	if err != nil {
-		return fmt.Errorf("failed to get file info: %v", err)
+		return err
+	}
+	totalChunks := len(lengths)
+	var size int64
+	for _, n := range lengths {
+		size += int64(n)
 	}
-	totalChunks := (int(fileInfo.Size()) + chunkSize - 1) / chunkSize
 
 	// Extract the file type from the file path
 	fileType := filepath.Ext(filePath) // Extracts the file extension (e.g., ".txt")
@@ -104,84 +202,340 @@ func (c *Client) UploadFile(filePath, fileID string, chunkSize int) error {
 		fileType = fileType[1:] // Remove the leading dot (e.g., "txt")
 	}
 
+	req := &pb.GetNodesForChunksRequest{
+		FileId:       fileID,
+		TotalChunks:  int32(totalChunks),
+		Size:         size,
+		FileType:     fileType,
+		DetectedType: detectedType,
+		ContentType:  opts.ContentType,
+		Attributes:   opts.Attributes,
+		Ttl:          int64(opts.TTL / time.Second),
+		Codecs:       c.offeredCodecs(),
+		StorageClass: c.StorageClass,
+	}
+
+	if !opts.ExpiresAt.IsZero() {
+		req.ExpiresAt = opts.ExpiresAt.Unix()
+	}
+
+	// Encrypt with a fresh data key, stored wrapped by the user key
+	var dataKey []byte
+	if c.EncryptionKey != nil {
+		dataKey, err = security.NewDataKey()
+		if err != nil {
+			return err
+		}
+		wrapped, err := security.WrapKey(c.EncryptionKey, dataKey)
+		if err != nil {
+			return fmt.Errorf("failed to wrap data key: %v", err)
+		}
+		req.Encryption = &pb.FileEncryption{
+			Scheme:     security.EncryptionScheme,
+			WrappedKey: wrapped,
+			KeyId:      security.KeyID(c.EncryptionKey),
+		}
+	}
+
 	// Get nodes for chunks from the Manager Node
-	nodes, err := c.GetNodesForChunks(fileID, totalChunks, fileType)
+	assignment, err := c.GetNodesForChunks(req)
 	if err != nil {
 		return fmt.Errorf("failed to get nodes for chunks: %v", err)
 	}
+	if assignment.Warning != "" {
+		log.Printf("Warning: %s", assignment.Warning)
+	}
+
+	if err := c.uploadChunks(fileID, file, lengths, 0, assignment, dataKey); err != nil {
+		return err
+	}
+
+	log.Println("File upload completed successfully with duplication")
+	return nil
+}
+
+// uploadChunks uploads the chunks src is cut into, numbered from firstChunk, to the nodes the
+// Manager Node assigned and completes the upload. Chunks already stored aren't sent again.
+func (c *Client) uploadChunks(fileID string, src io.ReadSeeker, lengths []int, firstChunk int32, assignment *pb.GetNodesForChunksResponse, dataKey []byte) error {
+	nodes := assignment.Nodes
 
 	// Buffer for reading chunks
-	buffer := make([]byte, chunkSize)
+	longest := 0
+	for _, n := range lengths {
+		if n > longest {
+			longest = n
+		}
+	}
+	buffer := make([]byte, longest)
 
-	for i := 0; i < totalChunks; i++ {
-		n, err := file.Read(buffer)
-		if err != nil && err != io.EOF {
+	// Hash every chunk as it will be stored first, so chunks already in the cluster aren't sent again
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind file: %v", err)
+	}
+	var metadata []*pb.ChunkMetadata
+	var hashes []string
+	for i, n := range lengths {
+		if _, err := io.ReadFull(src, buffer[:n]); err != nil {
 			return fmt.Errorf("failed to read file: %v", err)
 		}
-		if n == 0 {
-			break
+
+		chunkID := firstChunk + int32(i)
+		chunk, codec, err := prepareChunk(buffer[:n], chunkID, assignment.Codec, dataKey)
+		if err != nil {
+			return err
+		}
+		hash := sha256.Sum256(chunk)
+
+		metadata = append(metadata, &pb.ChunkMetadata{
+			ChunkId:    chunkID,
+			Codec:      codec,
+			Size:       int64(n),
+			StoredSize: int64(len(chunk)),
+			Hash:       hex.EncodeToString(hash[:]),
+		})
+		hashes = append(hashes, metadata[i].Hash)
+	}
+
+	existing, err := c.FindChunks(fileID, hashes)
+	if err != nil {
+		return err
+	}
+
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind file: %v", err)
+	}
+
+	for i, chunkMetadata := range metadata {
+		n := lengths[i]
+		if _, err := io.ReadFull(src, buffer[:n]); err != nil {
+			return fmt.Errorf("failed to read file: %v", err)
+		}
+
+		// Chunks repeated within the file are only sent the first time
+		chunkID := chunkMetadata.ChunkId
+		if existing[chunkMetadata.Hash] {
+			log.Printf("Chunk %d is already stored, skipping upload", chunkID)
+			continue
+		}
+		existing[chunkMetadata.Hash] = true
+
+		chunk, _, err := prepareChunk(buffer[:n], chunkID, assignment.Codec, dataKey)
+		if err != nil {
+			return err
 		}
 
-		chunk := buffer[:n]
-		chunkIDStr := fmt.Sprintf("%d", i)
+		// Erasure coded chunks are split into shards, each uploaded to its own node
+		if assignment.DataShards > 0 {
+			if err := c.uploadShards(chunk, chunkMetadata, fileID, nodes, assignment.DataShards, assignment.ParityShards); err != nil {
+				return err
+			}
+			continue
+		}
+
+		chunkIDStr := fmt.Sprintf("%d", chunkID)
 
 		// Collect node addresses assigned to this chunk
 		nodeAddresses := []string{}
+		token := ""
 		for _, node := range nodes {
-			if node.ChunkId == int32(i) {
+			if node.ChunkId == chunkID {
 				nodeAddresses = append(nodeAddresses, node.NodeAddress)
+				token = node.Token
 			}
 		}
 
-		// Upload the chunk to all assigned nodes
-		err = c.UploadChunk(chunk, fileID, chunkIDStr, nodeAddresses)
+		// Upload the chunk to all assigned nodes, which can only decompress it when it isn't encrypted
+		codec := chunkMetadata.Codec
+		if dataKey != nil {
+			codec = ""
+		}
+		receipt, err := c.UploadChunk(chunk, chunkMetadata.Hash, codec, fileID, chunkIDStr, token, nodeAddresses)
 		if err != nil {
 			return fmt.Errorf("failed to upload chunk: %v", err)
 		}
+		chunkMetadata.Receipt = receipt
+	}
+
+	return c.CompleteUpload(fileID, metadata)
+}
+
+// uploadShards erasure codes a chunk and uploads every shard to the node assigned to it,
+// recording the shard hashes and receipts in the chunk's metadata
+func (c *Client) uploadShards(chunk []byte, chunkMetadata *pb.ChunkMetadata, fileID string, nodes []*pb.ChunkNodeInfo, dataShards, parityShards int32) error {
+	shards, err := erasure.Encode(chunk, int(dataShards), int(parityShards))
+	if err != nil {
+		return fmt.Errorf("failed to encode chunk %d: %v", chunkMetadata.ChunkId, err)
+	}
+
+	chunkIDStr := fmt.Sprintf("%d", chunkMetadata.ChunkId)
+	for i, shard := range shards {
+		sum := sha256.Sum256(shard)
+		hash := hex.EncodeToString(sum[:])
+
+		uploaded := false
+		receipt := ""
+		for _, node := range nodes {
+			if node.ChunkId != chunkMetadata.ChunkId || node.Shard != int32(i) {
+				continue
+			}
+			var err error
+			if receipt, err = c.UploadChunk(shard, hash, "", fileID, chunkIDStr, node.Token, []string{node.NodeAddress}); err != nil {
+				return fmt.Errorf("failed to upload shard %d of chunk %d: %v", i, chunkMetadata.ChunkId, err)
+			}
+			uploaded = true
+		}
+		if !uploaded {
+			return fmt.Errorf("no node assigned to shard %d of chunk %d", i, chunkMetadata.ChunkId)
+		}
+		chunkMetadata.Shards = append(chunkMetadata.Shards, hash)
+		chunkMetadata.ShardReceipts = append(chunkMetadata.ShardReceipts, receipt)
+	}
+	return nil
+}
+
+// detectContentType sniffs the MIME type of a file from its first 512 bytes and rewinds it
+func detectContentType(file io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to rewind file: %v", err)
+	}
+	if n == 0 {
+		return "", nil
+	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// chunkLengths returns the lengths of the chunks a file is cut into
+func chunkLengths(file io.Reader, params chunking.Params) ([]int, error) {
+	chunker := chunking.NewChunker(file, params)
+	var lengths []int
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return lengths, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %v", err)
+		}
+		lengths = append(lengths, len(chunk))
+	}
+}
+
+// prepareChunk turns a chunk of the file into the bytes stored on Data Nodes. It compresses
+// before encrypting, as ciphertext doesn't compress. Both steps are deterministic, so the
+// same chunk always gives the same bytes within an upload.
+func prepareChunk(data []byte, chunkID int32, codec string, dataKey []byte) ([]byte, string, error) {
+	chunk, codec, err := compression.Compress(codec, data)
+	if err != nil {
+		return nil, "", err
+	}
+	if dataKey != nil {
+		chunk, err = security.EncryptChunk(dataKey, chunkID, chunk)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encrypt chunk %d: %v", chunkID, err)
+		}
+	}
+	return chunk, codec, nil
+}
+
+// CompleteUpload reports the hash, codec and sizes of the uploaded chunks to the Manager Node
+func (c *Client) CompleteUpload(fileID string, chunks []*pb.ChunkMetadata) error {
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	req := &pb.CompleteUploadRequest{
+		FileId: fileID,
+		Chunks: chunks,
+	}
+
+	if _, err := client.CompleteUpload(ctx, req); err != nil {
+		return fmt.Errorf("failed to complete upload: %v", err)
 	}
 
-	log.Println("File upload completed successfully with duplication")
 	return nil
 }
 
+// offeredCodecs lists the codecs the client can compress with, the preferred one first
+func (c *Client) offeredCodecs() []string {
+	if c.Compression == compression.None {
+		return []string{compression.None}
+	}
+	codecs := []string{c.Compression}
+	for _, codec := range compression.Codecs {
+		if codec != c.Compression {
+			codecs = append(codecs, codec)
+		}
+	}
+	return codecs
+}
+
 // NEW CODE HERE
 
+// DownloadOptions selects what to download of a file
+type DownloadOptions struct {
+	Version  int64  // Version to download, 0 for the current one
+	Snapshot string // Snapshot to download the file from, empty for the live namespace
+}
+
 // GetChunkLocations requests the Manager Node for the locations of each chunk of the file
-func (c *Client) GetChunkLocations(fileID string) ([]*pb.ChunkLocationInfo, string, error) {
-	conn, err := grpc.Dial(c.ManagerAddress, grpc.WithInsecure())
+// along with the file's metadata
+func (c *Client) GetChunkLocations(fileID string, opts DownloadOptions) (*pb.GetChunkLocationsResponse, error) {
+	conn, err := c.dial()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to connect to Manager Node: %v", err)
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
 	}
 	defer conn.Close()
 
 	client := pb.NewManagerServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
 	defer cancel()
 
 	req := &pb.GetChunkLocationsRequest{
-		FileId: fileID,
+		FileId:   fileID,
+		Version:  opts.Version,
+		Snapshot: opts.Snapshot,
 	}
 
 	resp, err := client.GetChunkLocations(ctx, req)
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to get chunk locations: %v", err)
+		return nil, fmt.Errorf("failed to get chunk locations: %v", err)
 	}
 
-	fmt.Println("Chunks:", resp.Chunks)
-	fmt.Println("File Type:", resp.FileType)
-
-	return resp.Chunks, resp.FileType, nil
+	return resp, nil
 }
 
 // DownloadFile downloads the file by fetching each chunk from the available nodes
-func (c *Client) DownloadFile(fileID string, chunkSize int) error {
+func (c *Client) DownloadFile(fileID string, opts DownloadOptions) error {
 	// Get chunk locations from the Manager Node
-	chunkLocations, fileType, err := c.GetChunkLocations(fileID)
+	locations, err := c.GetChunkLocations(fileID, opts)
 	if err != nil {
 		return fmt.Errorf("failed to get chunk locations: %v", err)
 	}
+	chunkLocations := locations.Chunks
+
+	// Encrypted files need the data key before anything is written, their chunks are served as opaque data
+	var dataKey []byte
+	contentType := locations.ContentType
+	if locations.Encryption != nil {
+		contentType = ""
+		dataKey, err = c.unwrapDataKey(locations.Encryption)
+		if err != nil {
+			return err
+		}
+	}
 
-	outputFile := fmt.Sprintf("%s.%s", "output", fileType)
+	outputFile := fmt.Sprintf("%s.%s", "output", locations.FileType)
 
 	// Create the output file
 	outFile, err := os.Create(outputFile)
@@ -192,13 +546,13 @@ func (c *Client) DownloadFile(fileID string, chunkSize int) error {
 
 	// Download each chunk and write to the output file
 	for _, chunkInfo := range chunkLocations {
-		chunkData, err := c.downloadChunkFromAvailableNodes(fileID, chunkInfo)
+		chunkData, err := c.fetchChunk(fileID, contentType, chunkInfo, dataKey)
 		if err != nil {
-			return fmt.Errorf("failed to download chunk %d: %v", chunkInfo.ChunkId, err)
+			return err
 		}
 
-		// Calculate the offset based on the chunk ID and chunk size
-		offset := int64(chunkInfo.ChunkId) * int64(chunkSize)
+		// Chunks vary in size, the Manager Node tells where each one goes
+		offset := chunkInfo.Offset
 
 		// Seek to the correct position in the output file before writing
 		_, err = outFile.Seek(offset, io.SeekStart)
@@ -218,11 +572,110 @@ func (c *Client) DownloadFile(fileID string, chunkSize int) error {
 	return nil
 }
 
-// downloadChunkFromAvailableNodes tries to download a chunk from any of the available nodes
-func (c *Client) downloadChunkFromAvailableNodes(fileID string, chunkInfo *pb.ChunkLocationInfo) ([]byte, error) {
+// fetchChunk downloads a chunk, from its replicas or its shards, and returns its contents
+// decrypted and decompressed. Replicas are served with the content type of the file.
+func (c *Client) fetchChunk(fileID, contentType string, chunkInfo *pb.ChunkLocationInfo, dataKey []byte) ([]byte, error) {
+	var chunkData []byte
+	var encoding string
+	var err error
+	if len(chunkInfo.Shards) > 0 {
+		chunkData, err = c.downloadStripe(fileID, chunkInfo)
+		encoding = chunkInfo.Codec
+	} else {
+		chunkData, encoding, err = c.downloadChunkFromAvailableNodes(fileID, contentType, chunkInfo)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download chunk %d: %v", chunkInfo.ChunkId, err)
+	}
+	if dataKey != nil {
+		chunkData, err = security.DecryptChunk(dataKey, chunkInfo.ChunkId, chunkData)
+		if err != nil {
+			return nil, err
+		}
+	}
+	chunkData, err = compression.Decompress(encoding, chunkData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk %d: %v", chunkInfo.ChunkId, err)
+	}
+	return chunkData, nil
+}
+
+// unwrapDataKey recovers the data key of a client-side encrypted file with the user key
+func (c *Client) unwrapDataKey(encryption *pb.FileEncryption) ([]byte, error) {
+	if encryption.Scheme != security.EncryptionScheme {
+		return nil, fmt.Errorf("unsupported encryption scheme %q", encryption.Scheme)
+	}
+	if c.EncryptionKey == nil {
+		return nil, fmt.Errorf("file is encrypted, an encryption key is required")
+	}
+	if keyID := security.KeyID(c.EncryptionKey); keyID != encryption.KeyId {
+		return nil, fmt.Errorf("file is encrypted with key %s, not %s", encryption.KeyId, keyID)
+	}
+	return security.UnwrapKey(c.EncryptionKey, encryption.WrappedKey)
+}
+
+// downloadStripe fetches the shards of an erasure coded chunk and joins them. As long as
+// enough shards are available, missing ones are rebuilt from the parity shards.
+func (c *Client) downloadStripe(fileID string, chunkInfo *pb.ChunkLocationInfo) ([]byte, error) {
+	dataShards := int(chunkInfo.DataShards)
+	shards := make([][]byte, len(chunkInfo.Shards))
+	fetched, degraded := 0, false
+	for i, shard := range chunkInfo.Shards {
+		if fetched == dataShards {
+			break
+		}
+
+		// Shards are fetched as stored, the codec applies to the joined chunk
+		location := &pb.ChunkLocationInfo{ChunkId: chunkInfo.ChunkId, Nodes: shard.Nodes, Token: shard.Token, Hash: shard.Hash}
+		data, _, err := c.downloadChunkFromAvailableNodes(fileID, "", location)
+		if err == nil {
+			sum := sha256.Sum256(data)
+			if hex.EncodeToString(sum[:]) != shard.Hash {
+				err = fmt.Errorf("shard data does not match its hash")
+			}
+		}
+		if err != nil {
+			log.Printf("Shard %d of chunk %d is unavailable: %v", i, chunkInfo.ChunkId, err)
+			degraded = true
+			continue
+		}
+		shards[i] = data
+		fetched++
+	}
+	if fetched < dataShards {
+		return nil, fmt.Errorf("only %d of the %d shards needed are available", fetched, dataShards)
+	}
+	if degraded {
+		log.Printf("Rebuilding chunk %d from parity shards", chunkInfo.ChunkId)
+	}
+
+	data, err := erasure.Decode(shards, dataShards, int(chunkInfo.ParityShards), chunkInfo.StoredSize)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != chunkInfo.Hash {
+		return nil, fmt.Errorf("reassembled chunk does not match its hash")
+	}
+	return data, nil
+}
+
+// downloadChunkFromAvailableNodes tries to download a chunk from any of the available nodes.
+// Compressed chunks are fetched as stored, the codec they come in is returned with the data.
+func (c *Client) downloadChunkFromAvailableNodes(fileID, contentType string, chunkInfo *pb.ChunkLocationInfo) ([]byte, string, error) {
 	for _, nodeAddress := range chunkInfo.Nodes {
-		url := fmt.Sprintf("http://%s/download?file_id=%s&chunk_id=%d", nodeAddress, fileID, chunkInfo.ChunkId)
-		resp, err := http.Get(url)
+		url := fmt.Sprintf("%s://%s/download?hash=%s&file_id=%s&token=%s&encoding=%s", security.Scheme(c.TLSConfig), nodeAddress,
+			chunkInfo.Hash, neturl.QueryEscape(fileID), chunkInfo.Token, chunkInfo.Codec)
+		if contentType != "" {
+			url += "&content_type=" + neturl.QueryEscape(contentType)
+		}
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Accept-Encoding", strings.Join(compression.Codecs, ", "))
+
+		resp, err := c.httpClient().Do(req)
 		if err != nil {
 			log.Printf("Failed to download chunk %d from %s: %v", chunkInfo.ChunkId, nodeAddress, err)
 			continue
@@ -236,11 +689,749 @@ func (c *Client) downloadChunkFromAvailableNodes(fileID string, chunkInfo *pb.Ch
 				log.Printf("Failed to read chunk %d from %s: %v", chunkInfo.ChunkId, nodeAddress, err)
 				continue
 			}
-			return data, nil
+			return data, resp.Header.Get("Content-Encoding"), nil
 		} else {
 			log.Printf("Error response from %s: %s", nodeAddress, resp.Status)
 		}
 	}
 
-	return nil, fmt.Errorf("all nodes failed to provide chunk %d", chunkInfo.ChunkId)
+	return nil, "", fmt.Errorf("all nodes failed to provide chunk %d", chunkInfo.ChunkId)
+}
+
+// Rebalance asks the Manager Node to even out chunk distribution across Data Nodes
+func (c *Client) Rebalance(threshold float64, bandwidthLimit int64) (*pb.RebalanceResponse, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+
+	// Moving chunks can take a long time, don't apply the usual short timeout
+	req := &pb.RebalanceRequest{
+		Threshold:      threshold,
+		BandwidthLimit: bandwidthLimit,
+	}
+
+	resp, err := client.Rebalance(context.Background(), req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebalance: %v", err)
+	}
+
+	return resp, nil
+}
+
+// CollectGarbage asks the Manager Node to delete chunks nothing refers to, or with dryRun
+// only to report which it would delete
+func (c *Client) CollectGarbage(dryRun bool) (*pb.CollectGarbageResponse, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+
+	// Deleting from every Data Node can take a long time, don't apply the usual short timeout
+	resp, err := client.CollectGarbage(context.Background(), &pb.CollectGarbageRequest{DryRun: dryRun})
+	if err != nil {
+		return nil, fmt.Errorf("failed to collect garbage: %v", err)
+	}
+
+	return resp, nil
+}
+
+// DecommissionNode asks the Manager Node to drain a Data Node so it can be retired
+func (c *Client) DecommissionNode(nodeID string, bandwidthLimit int64) (*pb.DecommissionNodeResponse, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	req := &pb.DecommissionNodeRequest{
+		NodeId:         nodeID,
+		BandwidthLimit: bandwidthLimit,
+	}
+
+	resp, err := client.DecommissionNode(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decommission node: %v", err)
+	}
+
+	return resp, nil
+}
+
+// GetDecommissionStatus requests the progress of a Data Node being decommissioned
+func (c *Client) GetDecommissionStatus(nodeID string) (*pb.DecommissionNodeResponse, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	req := &pb.GetDecommissionStatusRequest{
+		NodeId: nodeID,
+	}
+
+	resp, err := client.GetDecommissionStatus(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get decommission status: %v", err)
+	}
+
+	return resp, nil
+}
+
+// GetACL returns the ACL in effect for a file or directory
+func (c *Client) GetACL(path string) (*pb.ACL, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.GetACL(ctx, &pb.GetACLRequest{Path: path})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ACL: %v", err)
+	}
+
+	return resp, nil
+}
+
+// SetACL replaces the entries of the ACL on a file or directory. An empty owner or group keeps the current one.
+func (c *Client) SetACL(path, owner, group string, entries []*pb.ACLEntry) (*pb.ACL, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	req := &pb.SetACLRequest{
+		Path:    path,
+		Owner:   owner,
+		Group:   group,
+		Entries: entries,
+	}
+
+	resp, err := client.SetACL(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set ACL: %v", err)
+	}
+
+	return resp, nil
+}
+
+// StatFile returns the metadata of a file
+func (c *Client) StatFile(fileID string) (*pb.FileInfo, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.StatFile(ctx, &pb.StatFileRequest{FileId: fileID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %v", err)
+	}
+
+	return resp, nil
+}
+
+// ListFiles returns the metadata of the files whose IDs start with prefix, in a snapshot if one is given
+func (c *Client) ListFiles(prefix, snapshot string) ([]*pb.FileInfo, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.ListFiles(ctx, &pb.ListFilesRequest{Prefix: prefix, Snapshot: snapshot})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %v", err)
+	}
+
+	return resp.Files, nil
+}
+
+// GetAttributes returns the user-defined attributes of a file
+func (c *Client) GetAttributes(fileID string) (map[string]string, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.GetAttributes(ctx, &pb.GetAttributesRequest{FileId: fileID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get attributes: %v", err)
+	}
+
+	return resp.Attributes, nil
+}
+
+// SetAttributes sets and removes user-defined attributes of a file and returns all of them.
+// The lease is only needed while the file is leased.
+func (c *Client) SetAttributes(fileID string, set map[string]string, remove []string, leaseID string) (map[string]string, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	req := &pb.SetAttributesRequest{
+		FileId:  fileID,
+		Set:     set,
+		Remove:  remove,
+		LeaseId: leaseID,
+	}
+
+	resp, err := client.SetAttributes(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set attributes: %v", err)
+	}
+
+	return resp.Attributes, nil
+}
+
+// Query returns a page of the files matching a query, and the token of the next page if there is one
+func (c *Client) Query(req *pb.QueryRequest) (*pb.QueryResponse, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.Query(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query files: %v", err)
+	}
+
+	return resp, nil
+}
+
+// ListVersions returns the versions of a file, newest first
+func (c *Client) ListVersions(fileID string) ([]*pb.FileInfo, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.ListVersions(ctx, &pb.ListVersionsRequest{FileId: fileID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions: %v", err)
+	}
+
+	return resp.Versions, nil
+}
+
+// RestoreVersion makes a previous version of a file its current version again
+func (c *Client) RestoreVersion(fileID string, version int64) (*pb.FileInfo, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.RestoreVersion(ctx, &pb.RestoreVersionRequest{FileId: fileID, Version: version})
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore version: %v", err)
+	}
+
+	return resp, nil
+}
+
+// PruneVersions deletes a previous version of a file or, if version is 0, all but the newest keep ones.
+// It returns the number of versions deleted.
+func (c *Client) PruneVersions(fileID string, version int64, keep int) (int, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	req := &pb.PruneVersionsRequest{
+		FileId:  fileID,
+		Version: version,
+		Keep:    int32(keep),
+	}
+
+	resp, err := client.PruneVersions(ctx, req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune versions: %v", err)
+	}
+
+	return int(resp.Pruned), nil
+}
+
+// CreateSnapshot takes a snapshot of a directory, or of the whole namespace if path is empty
+func (c *Client) CreateSnapshot(name, path string) (*pb.Snapshot, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: name, Path: path})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %v", err)
+	}
+
+	return resp, nil
+}
+
+// ListSnapshots returns the snapshots the user may read
+func (c *Client) ListSnapshots() ([]*pb.Snapshot, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.ListSnapshots(ctx, &pb.ListSnapshotsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %v", err)
+	}
+
+	return resp.Snapshots, nil
+}
+
+// DeleteSnapshot deletes a snapshot
+func (c *Client) DeleteSnapshot(name string) error {
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	if _, err := client.DeleteSnapshot(ctx, &pb.DeleteSnapshotRequest{Name: name}); err != nil {
+		return fmt.Errorf("failed to delete snapshot: %v", err)
+	}
+
+	return nil
+}
+
+// DeleteFile deletes a file with all of its versions
+func (c *Client) DeleteFile(fileID string) error {
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	if _, err := client.DeleteFile(ctx, &pb.DeleteFileRequest{FileId: fileID}); err != nil {
+		return fmt.Errorf("failed to delete file: %v", err)
+	}
+
+	return nil
+}
+
+// SetRetention changes when a file expires, how long it is retained and its legal hold
+func (c *Client) SetRetention(req *pb.SetRetentionRequest) (*pb.FileInfo, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	info, err := client.SetRetention(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set retention: %v", err)
+	}
+
+	return info, nil
+}
+
+// SetRetentionRule adds a retention rule, or replaces the one with the same name
+func (c *Client) SetRetentionRule(rule *pb.RetentionRule) (*pb.RetentionRule, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.SetRetentionRule(ctx, rule)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set retention rule: %v", err)
+	}
+
+	return resp, nil
+}
+
+// ListRetentionRules returns the retention rules the user may see, ordered by name
+func (c *Client) ListRetentionRules() ([]*pb.RetentionRule, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.ListRetentionRules(ctx, &pb.ListRetentionRulesRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list retention rules: %v", err)
+	}
+
+	return resp.Rules, nil
+}
+
+// DeleteRetentionRule deletes a retention rule
+func (c *Client) DeleteRetentionRule(name string) error {
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	if _, err := client.DeleteRetentionRule(ctx, &pb.DeleteRetentionRuleRequest{Name: name}); err != nil {
+		return fmt.Errorf("failed to delete retention rule: %v", err)
+	}
+
+	return nil
+}
+
+// SetQuota sets the quota of an owner or a directory
+func (c *Client) SetQuota(quota *pb.Quota) (*pb.Quota, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.SetQuota(ctx, quota)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set quota: %v", err)
+	}
+
+	return resp, nil
+}
+
+// DeleteQuota removes the quota of an owner or a directory
+func (c *Client) DeleteQuota(owner, path string) error {
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	if _, err := client.DeleteQuota(ctx, &pb.DeleteQuotaRequest{Owner: owner, Path: path}); err != nil {
+		return fmt.Errorf("failed to delete quota: %v", err)
+	}
+
+	return nil
+}
+
+// GetUsage reports the usage and quota of an owner or a directory, or with neither of every
+// owner and every directory with a quota
+func (c *Client) GetUsage(owner, path string) ([]*pb.UsageEntry, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.GetUsage(ctx, &pb.GetUsageRequest{Owner: owner, Path: path})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get usage: %v", err)
+	}
+
+	return resp.Entries, nil
+}
+
+// CopyFile copies a file, a previous version or a file in a snapshot to another file ID on the
+// Manager Node, without downloading and uploading it again
+func (c *Client) CopyFile(req *pb.CopyFileRequest) (*pb.FileInfo, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+
+	// Copies to another storage class have Data Nodes store every chunk again, don't apply the usual short timeout
+	resp, err := client.CopyFile(context.Background(), req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy file: %v", err)
+	}
+
+	return resp, nil
+}
+
+// AcquireLease takes the write lease on a file for duration, 0 for the server default.
+// Appends and writes to ranges of the file need it, and nothing else can change the file while it is held.
+func (c *Client) AcquireLease(fileID string, duration time.Duration) (*pb.Lease, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.AcquireLease(ctx, &pb.AcquireLeaseRequest{FileId: fileID, Duration: int64(duration / time.Second)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lease: %v", err)
+	}
+
+	return resp, nil
+}
+
+// RenewLease extends a write lease to duration from now, 0 for the server default
+func (c *Client) RenewLease(fileID, leaseID string, duration time.Duration) (*pb.Lease, error) {
+	conn, err := c.dial()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	resp, err := client.RenewLease(ctx, &pb.RenewLeaseRequest{FileId: fileID, LeaseId: leaseID, Duration: int64(duration / time.Second)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to renew lease: %v", err)
+	}
+
+	return resp, nil
+}
+
+// ReleaseLease gives up a write lease
+func (c *Client) ReleaseLease(fileID, leaseID string) error {
+	conn, err := c.dial()
+	if err != nil {
+		return fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	if _, err := client.ReleaseLease(ctx, &pb.ReleaseLeaseRequest{FileId: fileID, LeaseId: leaseID}); err != nil {
+		return fmt.Errorf("failed to release lease: %v", err)
+	}
+
+	return nil
+}
+
+// AppendFile appends the contents of a local file to a file under its write lease. A last chunk
+// shorter than chunkSize is filled up first, the rest is cut into new chunks.
+func (c *Client) AppendFile(filePath, fileID, leaseID string, chunkSize int) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+	return c.writeRange(fileID, leaseID, -1, data, chunkSize)
+}
+
+// WriteFileAt writes the contents of a local file over a file from offset on, under its write
+// lease. Writes may extend the file, but not start past its end.
+func (c *Client) WriteFileAt(filePath, fileID, leaseID string, offset int64, chunkSize int) error {
+	if offset < 0 {
+		return fmt.Errorf("invalid offset %d", offset)
+	}
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %v", err)
+	}
+	return c.writeRange(fileID, leaseID, offset, data, chunkSize)
+}
+
+// writeRange writes data over a file from offset on, -1 meaning its end. Only the chunks the
+// write overlaps are downloaded, patched and uploaded again. Chunks after the range keep their
+// place, so the chunks before them keep their sizes. The Manager Node switches to the patched
+// file at once when the upload completes.
+func (c *Client) writeRange(fileID, leaseID string, offset int64, data []byte, chunkSize int) error {
+	if len(data) == 0 {
+		return fmt.Errorf("nothing to write")
+	}
+	locations, err := c.GetChunkLocations(fileID, DownloadOptions{})
+	if err != nil {
+		return err
+	}
+	if locations.Encryption != nil {
+		return fmt.Errorf("file %s is encrypted, upload it again instead of writing part of it", fileID)
+	}
+	chunks := locations.Chunks
+	var size int64
+	if len(chunks) > 0 {
+		last := chunks[len(chunks)-1]
+		size = last.Offset + last.Size
+	}
+	if offset == -1 {
+		offset = size
+	}
+	if offset > size {
+		return fmt.Errorf("offset %d is past the end of file %s at %d bytes", offset, fileID, size)
+	}
+	end := offset + int64(len(data))
+
+	// The chunks the write overlaps, and a short last chunk the write starts right after
+	first := len(chunks)
+	for i, chunk := range chunks {
+		if offset < chunk.Offset+chunk.Size || i == len(chunks)-1 && offset == size && chunk.Size < int64(chunkSize) {
+			first = i
+			break
+		}
+	}
+	last := first - 1
+	for i := first; i < len(chunks) && chunks[i].Offset < end; i++ {
+		last = i
+	}
+
+	// Patch the overlapped chunks in memory
+	start := offset
+	if first < len(chunks) {
+		start = chunks[first].Offset
+	}
+	var region []byte
+	for _, chunk := range chunks[first : last+1] {
+		chunkData, err := c.fetchChunk(fileID, "", chunk, nil)
+		if err != nil {
+			return err
+		}
+		region = append(region, chunkData...)
+	}
+	if tail := int(end - start); tail > len(region) {
+		region = append(region, make([]byte, tail-len(region))...)
+	}
+	copy(region[offset-start:], data)
+
+	// Chunks kept after the range stay where they are, the end of the file is cut afresh
+	var lengths []int
+	if last+1 < len(chunks) {
+		for _, chunk := range chunks[first : last+1] {
+			lengths = append(lengths, int(chunk.Size))
+		}
+	} else {
+		params, err := chunking.NewParams(c.Chunking, chunkSize)
+		if err != nil {
+			return err
+		}
+		if lengths, err = chunkLengths(bytes.NewReader(region), params); err != nil {
+			return err
+		}
+	}
+
+	newSize := size
+	if end > newSize {
+		newSize = end
+	}
+	assignment, err := c.GetNodesForChunks(&pb.GetNodesForChunksRequest{
+		FileId:      fileID,
+		TotalChunks: int32(len(lengths)),
+		Size:        newSize,
+		Codecs:      c.offeredCodecs(),
+		LeaseId:     leaseID,
+		Patch:       true,
+		FirstChunk:  int32(first),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get nodes for chunks: %v", err)
+	}
+	if assignment.Warning != "" {
+		log.Printf("Warning: %s", assignment.Warning)
+	}
+
+	if err := c.uploadChunks(fileID, bytes.NewReader(region), lengths, int32(first), assignment, nil); err != nil {
+		return err
+	}
+	log.Printf("Wrote %d bytes at offset %d of file %s, replacing %d chunks from chunk %d", len(data), offset, fileID, last-first+1, first)
+	return nil
 }
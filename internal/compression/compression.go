@@ -0,0 +1,182 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs chunks can be stored with
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Codecs lists the supported codecs in order of preference
+var Codecs = []string{Zstd, Gzip, None}
+
+// entropyThreshold is the Shannon entropy in bits per byte above which data is
+// considered already compressed or encrypted and isn't worth compressing
+const entropyThreshold = 7.5
+
+// entropySample is how many bytes the entropy check looks at
+const entropySample = 64 * 1024
+
+// compressedTypes are file types that are already compressed
+var compressedTypes = map[string]bool{
+	"gz": true, "tgz": true, "zst": true, "bz2": true, "xz": true, "lz4": true, "zip": true, "7z": true, "rar": true,
+	"jpg": true, "jpeg": true, "png": true, "gif": true, "webp": true, "heic": true,
+	"mp3": true, "mp4": true, "m4a": true, "mkv": true, "mov": true, "avi": true, "webm": true, "ogg": true,
+	"pdf": true, "docx": true, "xlsx": true, "pptx": true, "jar": true, "apk": true,
+}
+
+// zstd encoders and decoders are safe for concurrent use with EncodeAll and DecodeAll
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Supported reports whether codec is known
+func Supported(codec string) bool {
+	return codec == None || codec == Gzip || codec == Zstd
+}
+
+// Negotiate picks the codec for a file: the preferred codec if the client offers it, otherwise
+// the best codec on offer. Files of already compressed types are stored as they are.
+func Negotiate(preferred string, offered []string, fileType string) string {
+	if preferred == None || IsCompressedType(fileType) {
+		return None
+	}
+	for _, codec := range offered {
+		if codec == preferred {
+			return codec
+		}
+	}
+	for _, codec := range Codecs {
+		for _, offer := range offered {
+			if codec == offer {
+				return codec
+			}
+		}
+	}
+	return None
+}
+
+// IsCompressedType reports whether a file type is a known compressed format
+func IsCompressedType(fileType string) bool {
+	return compressedTypes[strings.ToLower(strings.TrimPrefix(fileType, "."))]
+}
+
+// Compress compresses a chunk with codec. Data that looks incompressible, or that doesn't get
+// smaller, is returned unchanged together with the None codec.
+func Compress(codec string, data []byte) ([]byte, string, error) {
+	if codec == None || Entropy(data) > entropyThreshold {
+		return data, None, nil
+	}
+
+	var compressed []byte
+	switch codec {
+	case Zstd:
+		compressed = zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)))
+	case Gzip:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, "", fmt.Errorf("failed to compress chunk: %v", err)
+		}
+		if err := writer.Close(); err != nil {
+			return nil, "", fmt.Errorf("failed to compress chunk: %v", err)
+		}
+		compressed = buf.Bytes()
+	default:
+		return nil, "", fmt.Errorf("unsupported codec %q", codec)
+	}
+
+	if len(compressed) >= len(data) {
+		return data, None, nil
+	}
+	return compressed, codec, nil
+}
+
+// Decompress reverses Compress
+func Decompress(codec string, data []byte) ([]byte, error) {
+	if codec == "" || codec == None {
+		return data, nil
+	}
+	reader, err := NewReader(codec, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	plain, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress chunk: %v", err)
+	}
+	return plain, nil
+}
+
+// NewReader returns a reader decompressing r with codec
+func NewReader(codec string, r io.Reader) (io.ReadCloser, error) {
+	switch codec {
+	case "", None:
+		return io.NopCloser(r), nil
+	case Gzip:
+		reader, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress chunk: %v", err)
+		}
+		return reader, nil
+	case Zstd:
+		reader, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress chunk: %v", err)
+		}
+		return reader.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported codec %q", codec)
+	}
+}
+
+// Entropy estimates the Shannon entropy of data in bits per byte from a sample of its start
+func Entropy(data []byte) float64 {
+	if len(data) > entropySample {
+		data = data[:entropySample]
+	}
+	if len(data) == 0 {
+		return 0
+	}
+
+	var counts [256]int
+	for _, b := range data {
+		counts[b]++
+	}
+
+	entropy := 0.0
+	for _, count := range counts {
+		if count == 0 {
+			continue
+		}
+		p := float64(count) / float64(len(data))
+		entropy -= p * math.Log2(p)
+	}
+	return entropy
+}
+
+// Accepts reports whether an Accept-Encoding header value allows codec
+func Accepts(acceptEncoding, codec string) bool {
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(name) != codec && strings.TrimSpace(name) != "*" {
+			continue
+		}
+		return strings.ReplaceAll(params, " ", "") != "q=0"
+	}
+	return false
+}
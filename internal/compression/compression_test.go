@@ -0,0 +1,135 @@
+package compression
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name      string
+		preferred string
+		offered   []string
+		fileType  string
+		want      string
+	}{
+		{"preferred offered", Gzip, []string{Zstd, Gzip, None}, "txt", Gzip},
+		{"preferred not offered", Zstd, []string{Gzip, None}, "txt", Gzip},
+		{"best on offer", "", []string{None, Gzip, Zstd}, "log", Zstd},
+		{"nothing offered", Zstd, nil, "txt", None},
+		{"preferred none", None, []string{Zstd}, "txt", None},
+		{"compressed type", Zstd, []string{Zstd}, "jpg", None},
+		{"compressed type with dot and case", Zstd, []string{Zstd}, ".ZIP", None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Negotiate(tt.preferred, tt.offered, tt.fileType); got != tt.want {
+				t.Errorf("Negotiate(%q, %v, %q) = %q, want %q", tt.preferred, tt.offered, tt.fileType, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompressRoundTrip(t *testing.T) {
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 1000)
+	random := make([]byte, 64*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		codec     string
+		data      []byte
+		wantCodec string
+	}{
+		{"zstd text", Zstd, text, Zstd},
+		{"gzip text", Gzip, text, Gzip},
+		{"none text", None, text, None},
+		{"random data stays as is", Zstd, random, None},
+		{"too small to shrink", Gzip, []byte("a"), None},
+		{"empty", Zstd, nil, None},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed, codec, err := Compress(tt.codec, tt.data)
+			if err != nil {
+				t.Fatalf("Compress: %v", err)
+			}
+			if codec != tt.wantCodec {
+				t.Fatalf("Compress codec = %q, want %q", codec, tt.wantCodec)
+			}
+			if codec != None && len(compressed) >= len(tt.data) {
+				t.Errorf("compressed %d bytes to %d", len(tt.data), len(compressed))
+			}
+
+			plain, err := Decompress(codec, compressed)
+			if err != nil {
+				t.Fatalf("Decompress: %v", err)
+			}
+			if !bytes.Equal(plain, tt.data) {
+				t.Errorf("round trip changed the data")
+			}
+		})
+	}
+}
+
+func TestCompressUnsupported(t *testing.T) {
+	text := bytes.Repeat([]byte("abc"), 100)
+	if _, _, err := Compress("lz77", text); err == nil {
+		t.Error("Compress with an unknown codec succeeded")
+	}
+	if _, err := Decompress("lz77", text); err == nil {
+		t.Error("Decompress with an unknown codec succeeded")
+	}
+	if _, err := Decompress(Gzip, text); err == nil {
+		t.Error("Decompress of invalid gzip data succeeded")
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		min, max float64
+	}{
+		{"empty", nil, 0, 0},
+		{"one symbol", bytes.Repeat([]byte{'a'}, 100), 0, 0},
+		{"two symbols", bytes.Repeat([]byte("ab"), 100), 1, 1},
+		{"every byte", func() []byte {
+			data := make([]byte, 256)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			return data
+		}(), 8, 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Entropy(tt.data); got < tt.min-1e-9 || got > tt.max+1e-9 {
+				t.Errorf("Entropy = %v, want between %v and %v", got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestAccepts(t *testing.T) {
+	tests := []struct {
+		header string
+		codec  string
+		want   bool
+	}{
+		{"gzip, deflate", Gzip, true},
+		{"gzip, deflate", Zstd, false},
+		{"zstd;q=0.5", Zstd, true},
+		{"zstd; q=0", Zstd, false},
+		{"*", Zstd, true},
+		{"", Gzip, false},
+	}
+	for _, tt := range tests {
+		if got := Accepts(tt.header, tt.codec); got != tt.want {
+			t.Errorf("Accepts(%q, %q) = %v, want %v", tt.header, tt.codec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package config
+
+import (
+	"breezeFS/internal/chunking"
+	"breezeFS/internal/compression"
+	"breezeFS/internal/erasure"
+	"breezeFS/internal/security"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds the settings shared by the Manager Node, Data Node and client binaries
+type Config struct {
+	ManagerListen      string           `yaml:"manager_listen"`       // Address the Manager Node listens on
+	ManagerAddress     string           `yaml:"manager_address"`      // Address used to reach the Manager Node
+	NodeListen         string           `yaml:"node_listen"`          // Address the Data Node listens on, port 0 picks a free port
+	NodeAdvertise      string           `yaml:"node_advertise"`       // Host the Data Node registers with, if different from the listen host
+	DataDir            string           `yaml:"data_dir"`             // Directory where the Data Node stores chunks
+	Replication        int              `yaml:"replication"`          // Number of copies kept of every chunk
+	RequestTimeout     time.Duration    `yaml:"request_timeout"`      // Timeout for requests to the Manager Node
+	ReportInterval     time.Duration    `yaml:"report_interval"`      // How often Data Nodes send incremental block reports
+	FullReportInterval time.Duration    `yaml:"full_report_interval"` // How often Data Nodes send full block reports
+	RebalanceInterval  time.Duration    `yaml:"rebalance_interval"`   // How often the Manager Node rebalances in the background, 0 to disable
+	RebalanceThreshold float64          `yaml:"rebalance_threshold"`  // Allowed deviation from the mean node utilisation
+	RebalanceBandwidth int64            `yaml:"rebalance_bandwidth"`  // Bandwidth limit per chunk move in bytes per second
+	Compression        string           `yaml:"compression"`          // Preferred chunk codec: zstd, gzip or none
+	Chunking           string           `yaml:"chunking"`             // How the client cuts uploads into chunks: fixed or cdc
+	StorageClass       string           `yaml:"storage_class"`        // How the client's uploads are stored: replicated or erasure
+	Versions           int              `yaml:"versions"`             // Previous versions the Manager Node keeps of every file
+	GCInterval         time.Duration    `yaml:"gc_interval"`          // How often the Manager Node deletes unreferenced chunks, 0 to disable
+	GCGracePeriod      time.Duration    `yaml:"gc_grace_period"`      // How long newly stored chunks are protected from garbage collection
+	ExpiryInterval     time.Duration    `yaml:"expiry_interval"`      // How often the Manager Node deletes expired files, 0 to disable
+	TLS                TLSConfig        `yaml:"tls"`
+	Auth               AuthConfig       `yaml:"auth"`
+	Encryption         EncryptionConfig `yaml:"encryption"`
+	Erasure            ErasureConfig    `yaml:"erasure"`
+}
+
+// TLSConfig holds the certificate settings. TLS is enabled when a CA file is set.
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"` // Certificate presented to peers, required for servers and Data Nodes
+	KeyFile  string `yaml:"key_file"`  // Private key of the certificate
+	CAFile   string `yaml:"ca_file"`   // CA used to verify peers
+}
+
+// AuthConfig holds the client authentication and chunk access token settings
+type AuthConfig struct {
+	APIKey          string        `yaml:"api_key"`           // Key the client authenticates to the Manager Node with
+	APIKeysFile     string        `yaml:"api_keys_file"`     // File of user:key[:groups] lines, enables client authentication and ACLs on the Manager Node
+	TokenSecretFile string        `yaml:"token_secret_file"` // Secret shared by the Manager Node and Data Nodes, enables chunk access tokens
+	TokenTTL        time.Duration `yaml:"token_ttl"`         // Lifetime of chunk access tokens
+	RootOwner       string        `yaml:"root_owner"`        // User owning the root directory, who administers the cluster
+	AdminGroup      string        `yaml:"admin_group"`       // Group whose members administer the root directory and the cluster
+}
+
+// EncryptionConfig holds the encryption key settings
+type EncryptionConfig struct {
+	KeyFile             string        `yaml:"key_file"`              // User key the client encrypts uploads with, enables client-side encryption
+	NodeKeyFile         string        `yaml:"node_key_file"`         // Keys the Data Node encrypts chunk files with, enables encryption at rest
+	KeyRotationInterval time.Duration `yaml:"key_rotation_interval"` // How often the Data Node reloads its keys and re-encrypts chunks
+}
+
+// ErasureConfig holds the settings of the erasure coded storage class
+type ErasureConfig struct {
+	DataShards     int           `yaml:"data_shards"`     // Data shards every chunk is split into
+	ParityShards   int           `yaml:"parity_shards"`   // Parity shards added to every chunk, as many nodes can be lost
+	RepairInterval time.Duration `yaml:"repair_interval"` // How often the Manager Node rebuilds lost shards, 0 to disable
+}
+
+// Default returns the configuration used when nothing else is specified
+func Default() *Config {
+	return &Config{
+		ManagerListen:      ":50051",
+		ManagerAddress:     "localhost:50051",
+		NodeListen:         "localhost:0",
+		DataDir:            "data",
+		Replication:        2,
+		RequestTimeout:     5 * time.Second,
+		ReportInterval:     30 * time.Second,
+		FullReportInterval: time.Hour,
+		RebalanceInterval:  10 * time.Minute,
+		RebalanceThreshold: 0.1,
+		RebalanceBandwidth: 10 * 1024 * 1024,
+		Compression:        compression.Zstd,
+		Chunking:           chunking.Fixed,
+		StorageClass:       erasure.ClassReplicated,
+		Versions:           5,
+		GCInterval:         time.Hour,
+		GCGracePeriod:      10 * time.Minute,
+		ExpiryInterval:     time.Minute,
+		Auth: AuthConfig{
+			TokenTTL: time.Hour,
+		},
+		Encryption: EncryptionConfig{
+			KeyRotationInterval: time.Hour,
+		},
+		Erasure: ErasureConfig{
+			DataShards:     4,
+			ParityShards:   2,
+			RepairInterval: time.Minute,
+		},
+	}
+}
+
+// Setting names accepted by RegisterFlags. Each one is also read from the
+// environment as BREEZEFS_<NAME>, e.g. BREEZEFS_MANAGER_ADDRESS.
+const (
+	ManagerListen      = "manager-listen"
+	ManagerAddress     = "manager-address"
+	NodeListen         = "listen"
+	NodeAdvertise      = "advertise"
+	DataDir            = "data-dir"
+	Replication        = "replication"
+	RequestTimeout     = "timeout"
+	ReportInterval     = "report-interval"
+	FullReportInterval = "full-report-interval"
+	RebalanceInterval  = "rebalance-interval"
+	RebalanceThreshold = "rebalance-threshold"
+	RebalanceBandwidth = "rebalance-bandwidth"
+	Compression        = "compression"
+	Chunking           = "chunking"
+	StorageClass       = "storage-class"
+	Versions           = "versions"
+	GCInterval         = "gc-interval"
+	GCGracePeriod      = "gc-grace-period"
+	ExpiryInterval     = "expiry-interval"
+	DataShards         = "data-shards"
+	ParityShards       = "parity-shards"
+	RepairInterval     = "repair-interval"
+	TLSCert            = "tls-cert"
+	TLSKey             = "tls-key"
+	TLSCA              = "tls-ca"
+	APIKey             = "api-key"
+	APIKeysFile        = "api-keys-file"
+	TokenSecretFile    = "token-secret-file"
+	TokenTTL           = "token-ttl"
+	RootOwner          = "root-owner"
+	AdminGroup         = "admin-group"
+	EncryptionKeyFile  = "encryption-key-file"
+	NodeKeyFile        = "node-key-file"
+	KeyRotation        = "key-rotation-interval"
+)
+
+// settings lists every setting name, only these are read from the environment
+var settings = map[string]bool{
+	ManagerListen: true, ManagerAddress: true, NodeListen: true, NodeAdvertise: true,
+	DataDir: true, Replication: true, RequestTimeout: true, ReportInterval: true,
+	FullReportInterval: true, RebalanceInterval: true, RebalanceThreshold: true, RebalanceBandwidth: true,
+	Compression: true, Chunking: true, StorageClass: true, Versions: true,
+	GCInterval: true, GCGracePeriod: true, ExpiryInterval: true,
+	DataShards: true, ParityShards: true, RepairInterval: true,
+	TLSCert: true, TLSKey: true, TLSCA: true,
+	APIKey: true, APIKeysFile: true, TokenSecretFile: true, TokenTTL: true, RootOwner: true, AdminGroup: true,
+	EncryptionKeyFile: true, NodeKeyFile: true, KeyRotation: true,
+}
+
+// configFlag is the flag holding the path of the configuration file
+const configFlag = "config"
+
+// envPrefix is prepended to setting names to form environment variable names
+const envPrefix = "BREEZEFS_"
+
+// RegisterFlags adds a -config flag and a flag for each named setting to fs.
+// The flags write straight into cfg.
+func RegisterFlags(fs *flag.FlagSet, cfg *Config, names ...string) {
+	fs.String(configFlag, os.Getenv(envPrefix+"CONFIG"), "Path to a YAML configuration file")
+
+	for _, name := range names {
+		switch name {
+		case ManagerListen:
+			fs.StringVar(&cfg.ManagerListen, name, cfg.ManagerListen, "Address the Manager Node listens on")
+		case ManagerAddress:
+			fs.StringVar(&cfg.ManagerAddress, name, cfg.ManagerAddress, "Address of the Manager Node")
+		case NodeListen:
+			fs.StringVar(&cfg.NodeListen, name, cfg.NodeListen, "Address the Data Node listens on, port 0 picks a free port")
+		case NodeAdvertise:
+			fs.StringVar(&cfg.NodeAdvertise, name, cfg.NodeAdvertise, "Host the Data Node registers with the Manager Node")
+		case DataDir:
+			fs.StringVar(&cfg.DataDir, name, cfg.DataDir, "Directory where chunks are stored")
+		case Replication:
+			fs.IntVar(&cfg.Replication, name, cfg.Replication, "Number of copies kept of every chunk")
+		case RequestTimeout:
+			fs.DurationVar(&cfg.RequestTimeout, name, cfg.RequestTimeout, "Timeout for requests to the Manager Node")
+		case ReportInterval:
+			fs.DurationVar(&cfg.ReportInterval, name, cfg.ReportInterval, "Interval between incremental block reports")
+		case FullReportInterval:
+			fs.DurationVar(&cfg.FullReportInterval, name, cfg.FullReportInterval, "Interval between full block reports")
+		case RebalanceInterval:
+			fs.DurationVar(&cfg.RebalanceInterval, name, cfg.RebalanceInterval, "Interval between background rebalances, 0 to disable")
+		case RebalanceThreshold:
+			fs.Float64Var(&cfg.RebalanceThreshold, name, cfg.RebalanceThreshold, "Allowed deviation from mean node utilisation")
+		case RebalanceBandwidth:
+			fs.Int64Var(&cfg.RebalanceBandwidth, name, cfg.RebalanceBandwidth, "Bandwidth limit per chunk move in bytes per second")
+		case Compression:
+			fs.StringVar(&cfg.Compression, name, cfg.Compression, "Preferred chunk compression: zstd, gzip or none")
+		case Chunking:
+			fs.StringVar(&cfg.Chunking, name, cfg.Chunking, "How uploads are cut into chunks: fixed, or cdc for content-defined boundaries around -chunksize")
+		case StorageClass:
+			fs.StringVar(&cfg.StorageClass, name, cfg.StorageClass, "How uploads are stored: replicated, or erasure for data and parity shards on distinct nodes")
+		case Versions:
+			fs.IntVar(&cfg.Versions, name, cfg.Versions, "Previous versions kept of every file, 0 to keep none")
+		case GCInterval:
+			fs.DurationVar(&cfg.GCInterval, name, cfg.GCInterval, "Interval between garbage collections, 0 to disable")
+		case GCGracePeriod:
+			fs.DurationVar(&cfg.GCGracePeriod, name, cfg.GCGracePeriod, "How long newly stored chunks are protected from garbage collection")
+		case ExpiryInterval:
+			fs.DurationVar(&cfg.ExpiryInterval, name, cfg.ExpiryInterval, "Interval between deleting expired files, 0 to disable")
+		case DataShards:
+			fs.IntVar(&cfg.Erasure.DataShards, name, cfg.Erasure.DataShards, "Data shards every erasure coded chunk is split into")
+		case ParityShards:
+			fs.IntVar(&cfg.Erasure.ParityShards, name, cfg.Erasure.ParityShards, "Parity shards added to every erasure coded chunk")
+		case RepairInterval:
+			fs.DurationVar(&cfg.Erasure.RepairInterval, name, cfg.Erasure.RepairInterval, "Interval between rebuilding lost shards, 0 to disable")
+		case TLSCert:
+			fs.StringVar(&cfg.TLS.CertFile, name, cfg.TLS.CertFile, "Path to the TLS certificate")
+		case TLSKey:
+			fs.StringVar(&cfg.TLS.KeyFile, name, cfg.TLS.KeyFile, "Path to the TLS private key")
+		case TLSCA:
+			fs.StringVar(&cfg.TLS.CAFile, name, cfg.TLS.CAFile, "Path to the CA certificate, enables TLS")
+		case APIKey:
+			fs.StringVar(&cfg.Auth.APIKey, name, cfg.Auth.APIKey, "API key to authenticate with")
+		case APIKeysFile:
+			fs.StringVar(&cfg.Auth.APIKeysFile, name, cfg.Auth.APIKeysFile, "File of user:key or user:key:groups lines, enables client authentication and ACLs")
+		case TokenSecretFile:
+			fs.StringVar(&cfg.Auth.TokenSecretFile, name, cfg.Auth.TokenSecretFile, "File holding the secret for chunk access tokens")
+		case TokenTTL:
+			fs.DurationVar(&cfg.Auth.TokenTTL, name, cfg.Auth.TokenTTL, "Lifetime of chunk access tokens")
+		case RootOwner:
+			fs.StringVar(&cfg.Auth.RootOwner, name, cfg.Auth.RootOwner, "User owning the root directory, who administers the cluster")
+		case AdminGroup:
+			fs.StringVar(&cfg.Auth.AdminGroup, name, cfg.Auth.AdminGroup, "Group whose members administer the root directory and the cluster")
+		case EncryptionKeyFile:
+			fs.StringVar(&cfg.Encryption.KeyFile, name, cfg.Encryption.KeyFile, "File holding the base64 encoded key to encrypt uploads with")
+		case NodeKeyFile:
+			fs.StringVar(&cfg.Encryption.NodeKeyFile, name, cfg.Encryption.NodeKeyFile, "File of base64 encoded keys to encrypt chunk files with, the last one is current")
+		case KeyRotation:
+			fs.DurationVar(&cfg.Encryption.KeyRotationInterval, name, cfg.Encryption.KeyRotationInterval, "Interval between reloading node keys and re-encrypting chunks")
+		default:
+			panic(fmt.Sprintf("config: unknown setting %q", name))
+		}
+	}
+}
+
+// Load applies the configuration file and environment variables to cfg once fs has been parsed.
+// Precedence from lowest to highest is defaults, configuration file, environment, flags.
+func Load(fs *flag.FlagSet, cfg *Config) error {
+	if !fs.Parsed() {
+		return fmt.Errorf("flags must be parsed before loading configuration")
+	}
+
+	// Remember the flags given on the command line, the file and environment must not override them
+	explicit := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	if path := fs.Lookup(configFlag).Value.String(); path != "" {
+		if err := loadFile(path, cfg); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || !settings[f.Name] {
+			return
+		}
+		if value, ok := os.LookupEnv(envName(f.Name)); ok {
+			if setErr := f.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", value, envName(f.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for -%s: %v", value, name, err)
+		}
+	}
+
+	return cfg.validate()
+}
+
+// loadFile decodes a YAML configuration file over cfg
+func loadFile(path string, cfg *Config) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open config file: %v", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// validate rejects settings that can't work
+func (c *Config) validate() error {
+	if c.Replication < 1 {
+		return fmt.Errorf("replication must be at least 1, got %d", c.Replication)
+	}
+	if c.RequestTimeout <= 0 {
+		return fmt.Errorf("request timeout must be positive, got %s", c.RequestTimeout)
+	}
+	if c.ReportInterval <= 0 || c.FullReportInterval <= 0 {
+		return fmt.Errorf("block report intervals must be positive")
+	}
+	if c.Auth.TokenTTL <= 0 {
+		return fmt.Errorf("token TTL must be positive, got %s", c.Auth.TokenTTL)
+	}
+	if !compression.Supported(c.Compression) {
+		return fmt.Errorf("unsupported compression %q, use zstd, gzip or none", c.Compression)
+	}
+	if !chunking.Supported(c.Chunking) {
+		return fmt.Errorf("unsupported chunking %q, use fixed or cdc", c.Chunking)
+	}
+	if !erasure.SupportedClass(c.StorageClass) {
+		return fmt.Errorf("unsupported storage class %q, use replicated or erasure", c.StorageClass)
+	}
+	if c.Versions < 0 {
+		return fmt.Errorf("versions must not be negative, got %d", c.Versions)
+	}
+	if c.GCInterval < 0 {
+		return fmt.Errorf("garbage collection interval must not be negative, got %s", c.GCInterval)
+	}
+	if c.GCGracePeriod < 0 {
+		return fmt.Errorf("garbage collection grace period must not be negative, got %s", c.GCGracePeriod)
+	}
+	if c.ExpiryInterval < 0 {
+		return fmt.Errorf("expiry interval must not be negative, got %s", c.ExpiryInterval)
+	}
+	if c.Erasure.DataShards < 1 || c.Erasure.ParityShards < 1 || c.Erasure.DataShards+c.Erasure.ParityShards > 256 {
+		return fmt.Errorf("erasure coding needs at least 1 data and 1 parity shard and at most 256 in total")
+	}
+	if c.Erasure.RepairInterval < 0 {
+		return fmt.Errorf("repair interval must not be negative, got %s", c.Erasure.RepairInterval)
+	}
+	if c.Encryption.KeyRotationInterval <= 0 {
+		return fmt.Errorf("key rotation interval must be positive, got %s", c.Encryption.KeyRotationInterval)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("TLS certificate and key must be given together")
+	}
+	return nil
+}
+
+// ServerTLS returns the TLS configuration for serving, or nil if TLS is disabled
+func (c *Config) ServerTLS() (*tls.Config, error) {
+	if c.TLS.CAFile == "" {
+		return nil, nil
+	}
+	if c.TLS.CertFile == "" {
+		return nil, fmt.Errorf("TLS is enabled but no certificate is configured")
+	}
+	return security.ServerTLSConfig(c.TLS.CertFile, c.TLS.KeyFile, c.TLS.CAFile)
+}
+
+// TokenSigner returns the chunk access token signer, or nil if tokens are disabled
+func (c *Config) TokenSigner() (*security.TokenSigner, error) {
+	if c.Auth.TokenSecretFile == "" {
+		return nil, nil
+	}
+	return security.LoadTokenSigner(c.Auth.TokenSecretFile, c.Auth.TokenTTL)
+}
+
+// EncryptionKey returns the user key for client-side encryption, or nil if encryption is disabled
+func (c *Config) EncryptionKey() ([]byte, error) {
+	if c.Encryption.KeyFile == "" {
+		return nil, nil
+	}
+	return security.LoadEncryptionKey(c.Encryption.KeyFile)
+}
+
+// NodeKeys returns the keys for encrypting chunk files at rest, or nil if encryption at rest is disabled
+func (c *Config) NodeKeys() (*security.Keyring, error) {
+	if c.Encryption.NodeKeyFile == "" {
+		return nil, nil
+	}
+	return security.LoadKeyring(c.Encryption.NodeKeyFile)
+}
+
+// ClientTLS returns the TLS configuration for connecting to other nodes, or nil if TLS is disabled
+func (c *Config) ClientTLS() (*tls.Config, error) {
+	if c.TLS.CAFile == "" {
+		return nil, nil
+	}
+	return security.ClientTLSConfig(c.TLS.CertFile, c.TLS.KeyFile, c.TLS.CAFile)
+}
+
+// envName returns the environment variable for a setting, e.g. data-dir -> BREEZEFS_DATA_DIR
+func envName(setting string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(setting, "-", "_"))
+}
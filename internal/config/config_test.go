@@ -0,0 +1,152 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name            string
+		file            string            // Contents of the configuration file, empty for none
+		env             map[string]string // Environment variables
+		args            []string          // Command line
+		wantReplication int
+		wantDataDir     string
+		wantTimeout     time.Duration
+	}{
+		{name: "defaults", wantReplication: 2, wantDataDir: "data", wantTimeout: 5 * time.Second},
+		{
+			name:            "file",
+			file:            "replication: 3\ndata_dir: /srv/file\nrequest_timeout: 1m\n",
+			wantReplication: 3,
+			wantDataDir:     "/srv/file",
+			wantTimeout:     time.Minute,
+		},
+		{
+			name:            "environment over file",
+			file:            "replication: 3\ndata_dir: /srv/file\n",
+			env:             map[string]string{"BREEZEFS_DATA_DIR": "/srv/env", "BREEZEFS_TIMEOUT": "10s"},
+			wantReplication: 3,
+			wantDataDir:     "/srv/env",
+			wantTimeout:     10 * time.Second,
+		},
+		{
+			name:            "flags over environment",
+			file:            "replication: 3\n",
+			env:             map[string]string{"BREEZEFS_REPLICATION": "4", "BREEZEFS_DATA_DIR": "/srv/env"},
+			args:            []string{"-replication", "5"},
+			wantReplication: 5,
+			wantDataDir:     "/srv/env",
+			wantTimeout:     5 * time.Second,
+		},
+		{
+			name:            "flag set to its default",
+			env:             map[string]string{"BREEZEFS_REPLICATION": "4"},
+			args:            []string{"-replication", "2"},
+			wantReplication: 2,
+			wantDataDir:     "data",
+			wantTimeout:     5 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+
+			cfg, err := parse(t, args)
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if cfg.Replication != tt.wantReplication || cfg.DataDir != tt.wantDataDir || cfg.RequestTimeout != tt.wantTimeout {
+				t.Errorf("Load() replication %d, data dir %s, timeout %s, want %d, %s, %s",
+					cfg.Replication, cfg.DataDir, cfg.RequestTimeout, tt.wantReplication, tt.wantDataDir, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+	}{
+		{name: "unknown field", file: "replicas: 3\n"},
+		{name: "malformed file", file: "replication: [\n"},
+		{name: "invalid environment value", env: map[string]string{"BREEZEFS_REPLICATION": "many"}},
+		{name: "invalid setting", args: []string{"-replication", "0"}},
+		{name: "unsupported compression", env: map[string]string{"BREEZEFS_COMPRESSION": "lz4"}},
+		{name: "certificate without key", file: "tls:\n  cert_file: node.crt\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+
+			if _, err := parse(t, args); err == nil {
+				t.Error("Load() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	if _, err := parse(t, []string{"-config", filepath.Join(t.TempDir(), "missing.yaml")}); err == nil {
+		t.Error("Load() of a missing file succeeded")
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		setting string
+		want    string
+	}{
+		{DataDir, "BREEZEFS_DATA_DIR"},
+		{ManagerAddress, "BREEZEFS_MANAGER_ADDRESS"},
+		{Replication, "BREEZEFS_REPLICATION"},
+	}
+	for _, tt := range tests {
+		if got := envName(tt.setting); got != tt.want {
+			t.Errorf("envName(%q) = %q, want %q", tt.setting, got, tt.want)
+		}
+	}
+}
+
+// parse registers a few settings, parses args and loads the configuration
+func parse(t *testing.T, args []string) (*Config, error) {
+	t.Helper()
+	cfg := Default()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	RegisterFlags(fs, cfg, Replication, DataDir, RequestTimeout, Compression, TLSCert)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	return cfg, Load(fs, cfg)
+}
+
+// writeConfig writes a configuration file and returns its path
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "breezefs.yaml")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
@@ -0,0 +1,73 @@
+package erasure
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// Storage classes a file can be stored with
+const (
+	ClassReplicated = "replicated" // Every chunk is copied in full to several nodes
+	ClassErasure    = "erasure"    // Every chunk is split into data and parity shards on distinct nodes
+)
+
+// SupportedClass reports whether class is known
+func SupportedClass(class string) bool {
+	return class == ClassReplicated || class == ClassErasure
+}
+
+// Encode splits data into dataShards equally sized shards, padding the last one with zeros,
+// and appends parityShards Reed-Solomon parity shards. Any dataShards of the shards are
+// enough to get the data back.
+func Encode(data []byte, dataShards, parityShards int) ([][]byte, error) {
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create encoder: %v", err)
+	}
+
+	shards, err := enc.Split(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split chunk: %v", err)
+	}
+	if err := enc.Encode(shards); err != nil {
+		return nil, fmt.Errorf("failed to encode chunk: %v", err)
+	}
+	return shards, nil
+}
+
+// ShardSize returns the size of each shard Encode splits size bytes of data into
+func ShardSize(size int64, dataShards int) int64 {
+	return (size + int64(dataShards) - 1) / int64(dataShards)
+}
+
+// Reconstruct rebuilds the missing shards, those that are nil, in place
+func Reconstruct(shards [][]byte, dataShards, parityShards int) error {
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return fmt.Errorf("failed to create encoder: %v", err)
+	}
+	if err := enc.Reconstruct(shards); err != nil {
+		return fmt.Errorf("failed to reconstruct shards: %v", err)
+	}
+	return nil
+}
+
+// Decode joins the data shards back into size bytes of data. Missing shards, those that
+// are nil, are reconstructed from the others first.
+func Decode(shards [][]byte, dataShards, parityShards int, size int64) ([]byte, error) {
+	enc, err := reedsolomon.New(dataShards, parityShards)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create encoder: %v", err)
+	}
+	if err := enc.ReconstructData(shards); err != nil {
+		return nil, fmt.Errorf("failed to reconstruct shards: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := enc.Join(&buf, shards, int(size)); err != nil {
+		return nil, fmt.Errorf("failed to join shards: %v", err)
+	}
+	return buf.Bytes(), nil
+}
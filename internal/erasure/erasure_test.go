@@ -0,0 +1,125 @@
+package erasure
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	data := make([]byte, 100003)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	tests := []struct {
+		name         string
+		dataShards   int
+		parityShards int
+		size         int
+		lost         []int // Shards missing when decoding
+		wantErr      bool
+	}{
+		{"all shards", 4, 2, len(data), nil, false},
+		{"data shard lost", 4, 2, len(data), []int{1}, false},
+		{"as many lost as parity", 4, 2, len(data), []int{0, 5}, false},
+		{"parity shards lost", 4, 2, len(data), []int{4, 5}, false},
+		{"too many lost", 4, 2, len(data), []int{0, 1, 2}, true},
+		{"shorter than the shards", 6, 3, 5, []int{2}, false},
+		{"one parity shard", 3, 1, 1000, []int{3}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := data[:tt.size]
+			shards, err := Encode(input, tt.dataShards, tt.parityShards)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if len(shards) != tt.dataShards+tt.parityShards {
+				t.Fatalf("got %d shards, want %d", len(shards), tt.dataShards+tt.parityShards)
+			}
+			for i, shard := range shards {
+				if int64(len(shard)) != ShardSize(int64(tt.size), tt.dataShards) {
+					t.Errorf("shard %d has %d bytes, ShardSize says %d", i, len(shard), ShardSize(int64(tt.size), tt.dataShards))
+				}
+			}
+
+			for _, i := range tt.lost {
+				shards[i] = nil
+			}
+			decoded, err := Decode(shards, tt.dataShards, tt.parityShards, int64(tt.size))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Decode error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(decoded, input) {
+				t.Errorf("decoded data differs from the input")
+			}
+		})
+	}
+}
+
+func TestReconstruct(t *testing.T) {
+	data := bytes.Repeat([]byte("reconstruct me "), 500)
+	shards, err := Encode(data, 4, 2)
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	want := make([][]byte, len(shards))
+	for i, shard := range shards {
+		want[i] = append([]byte(nil), shard...)
+	}
+
+	shards[2], shards[4] = nil, nil
+	if err := Reconstruct(shards, 4, 2); err != nil {
+		t.Fatalf("Reconstruct: %v", err)
+	}
+	for i := range want {
+		if !bytes.Equal(shards[i], want[i]) {
+			t.Errorf("shard %d differs after reconstruction", i)
+		}
+	}
+}
+
+func TestEncodeInvalid(t *testing.T) {
+	tests := []struct {
+		name                     string
+		data                     []byte
+		dataShards, parityShards int
+	}{
+		{"no data", nil, 4, 2},
+		{"no data shards", []byte("data"), 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Encode(tt.data, tt.dataShards, tt.parityShards); err == nil {
+				t.Error("Encode succeeded")
+			}
+		})
+	}
+}
+
+func TestShardSize(t *testing.T) {
+	tests := []struct {
+		size       int64
+		dataShards int
+		want       int64
+	}{
+		{0, 4, 0},
+		{1, 4, 1},
+		{4, 4, 1},
+		{5, 4, 2},
+		{1 << 20, 4, 1 << 18},
+		{1<<20 + 1, 4, 1<<18 + 1},
+	}
+	for _, tt := range tests {
+		if got := ShardSize(tt.size, tt.dataShards); got != tt.want {
+			t.Errorf("ShardSize(%d, %d) = %d, want %d", tt.size, tt.dataShards, got, tt.want)
+		}
+	}
+}
+
+func TestSupportedClass(t *testing.T) {
+	for class, want := range map[string]bool{ClassReplicated: true, ClassErasure: true, "": false, "cold": false} {
+		if got := SupportedClass(class); got != want {
+			t.Errorf("SupportedClass(%q) = %v, want %v", class, got, want)
+		}
+	}
+}
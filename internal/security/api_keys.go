@@ -0,0 +1,63 @@
+package security
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// APIKeyHeader is the gRPC metadata key clients send their API key in
+const APIKeyHeader = "x-api-key"
+
+// LoadAPIKeys reads a file of "user:key" or "user:key:group,group" lines and returns a map
+// from key to user and a map from user to the groups they belong to.
+// Blank lines and lines starting with # are ignored.
+func LoadAPIKeys(path string) (map[string]string, map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open API keys file: %v", err)
+	}
+	defer file.Close()
+
+	keys := make(map[string]string)
+	groups := make(map[string][]string)
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		user, rest, found := strings.Cut(line, ":")
+		key, groupList, _ := strings.Cut(rest, ":")
+		user, key = strings.TrimSpace(user), strings.TrimSpace(key)
+		if !found || user == "" || key == "" {
+			return nil, nil, fmt.Errorf("%s:%d: expected user:key or user:key:groups", path, lineNumber)
+		}
+		if _, exists := keys[key]; exists {
+			return nil, nil, fmt.Errorf("%s:%d: duplicate API key", path, lineNumber)
+		}
+		keys[key] = user
+
+		for _, group := range strings.Split(groupList, ",") {
+			if group = strings.TrimSpace(group); group != "" && !contains(groups[user], group) {
+				groups[user] = append(groups[user], group)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read API keys file: %v", err)
+	}
+	return keys, groups, nil
+}
+
+// contains reports whether values includes value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
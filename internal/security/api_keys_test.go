@@ -0,0 +1,55 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadAPIKeys(t *testing.T) {
+	tests := []struct {
+		name       string
+		contents   string
+		wantKeys   map[string]string
+		wantGroups map[string][]string
+		wantErr    bool
+	}{
+		{
+			name:       "users",
+			contents:   "# comment\nalice:ka\n\nbob : kb\n",
+			wantKeys:   map[string]string{"ka": "alice", "kb": "bob"},
+			wantGroups: map[string][]string{},
+		},
+		{
+			name:       "users and groups",
+			contents:   "alice:ka:staff,ops\nbob : kb : staff, staff\ncarol:kc\n",
+			wantKeys:   map[string]string{"ka": "alice", "kb": "bob", "kc": "carol"},
+			wantGroups: map[string][]string{"alice": {"staff", "ops"}, "bob": {"staff"}},
+		},
+		{name: "missing key", contents: "alice\n", wantErr: true},
+		{name: "empty key", contents: "alice:\n", wantErr: true},
+		{name: "duplicate key", contents: "alice:k\nbob:k\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+			if err := os.WriteFile(path, []byte(tt.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+			keys, groups, err := LoadAPIKeys(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadAPIKeys error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("keys = %v, want %v", keys, tt.wantKeys)
+			}
+			if !reflect.DeepEqual(groups, tt.wantGroups) {
+				t.Errorf("groups = %v, want %v", groups, tt.wantGroups)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package security
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// devValidity is how long certificates generated for local clusters stay valid
+const devValidity = 365 * 24 * time.Hour
+
+// GenerateDevCA writes a self-signed CA and certificates for a local cluster to dir:
+//
+//	ca.crt, ca.key         the certificate authority
+//	node.crt, node.key     for the Manager Node and Data Nodes, valid as server and client certificate
+//	client.crt, client.key for clients that want to authenticate with a certificate
+//
+// hosts are the DNS names and IP addresses the node certificate is valid for.
+// These certificates are meant for development only.
+func GenerateDevCA(dir string, hosts []string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %v", err)
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          newSerial(),
+		Subject:               pkix.Name{CommonName: "BreezeFS Dev CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(devValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("failed to create CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return fmt.Errorf("failed to parse CA certificate: %v", err)
+	}
+	if err := writeKeyPair(dir, "ca", caDER, caKey); err != nil {
+		return err
+	}
+
+	// Nodes act as servers and as clients of each other
+	node := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "breezefs-node"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			node.IPAddresses = append(node.IPAddresses, ip)
+		} else {
+			node.DNSNames = append(node.DNSNames, host)
+		}
+	}
+	if err := issueCertificate(dir, "node", node, caCert, caKey); err != nil {
+		return err
+	}
+
+	client := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "breezefs-client"},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	return issueCertificate(dir, "client", client, caCert, caKey)
+}
+
+// issueCertificate signs template with the CA and writes it with a fresh key
+func issueCertificate(dir, name string, template, caCert *x509.Certificate, caKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s key: %v", name, err)
+	}
+
+	template.SerialNumber = newSerial()
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(devValidity)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("failed to create %s certificate: %v", name, err)
+	}
+	return writeKeyPair(dir, name, der, key)
+}
+
+// writeKeyPair writes name.crt and name.key in PEM format
+func writeKeyPair(dir, name string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s key: %v", name, err)
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name+".crt"), certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write %s certificate: %v", name, err)
+	}
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		return fmt.Errorf("failed to write %s key: %v", name, err)
+	}
+	return nil
+}
+
+// newSerial returns a random certificate serial number
+func newSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(fmt.Sprintf("failed to generate serial number: %v", err))
+	}
+	return serial
+}
@@ -0,0 +1,129 @@
+package security
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// EncryptionScheme names how encrypted files are laid out: every chunk is sealed with AES-256-GCM
+// under the file's data key, using the chunk index as nonce. The data key is sealed with
+// AES-256-GCM under the user key and stored as nonce followed by ciphertext.
+const EncryptionScheme = "aes-256-gcm-chunk-index"
+
+// KeySize is the size of user keys and data keys in bytes
+const KeySize = 32
+
+// LoadEncryptionKey reads a base64 encoded 32 byte user key from a file
+func LoadEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encryption key: %v", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encryption key in %s: %v", path, err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("encryption key in %s must be %d bytes, got %d", path, KeySize, len(key))
+	}
+	return key, nil
+}
+
+// KeyID returns a short fingerprint of a user key, so the wrong key can be reported as such
+func KeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewDataKey generates a random key for encrypting the chunks of one file
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	return key, nil
+}
+
+// WrapKey encrypts a data key with the user key
+func WrapKey(userKey, dataKey []byte) ([]byte, error) {
+	aead, err := newGCM(userKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return aead.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey
+func UnwrapKey(userKey, wrapped []byte) ([]byte, error) {
+	aead, err := newGCM(userKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+
+	dataKey, err := aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	return dataKey, nil
+}
+
+// EncryptChunk seals a chunk with the file's data key. Every file has its own data key,
+// so the chunk index is a unique nonce and chunks can't be swapped without detection.
+func EncryptChunk(dataKey []byte, chunkID int32, plaintext []byte) ([]byte, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, chunkNonce(aead, chunkID), plaintext, nil), nil
+}
+
+// DecryptChunk opens a chunk sealed by EncryptChunk
+func DecryptChunk(dataKey []byte, chunkID int32, ciphertext []byte) ([]byte, error) {
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, chunkNonce(aead, chunkID), ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt chunk %d: %v", chunkID, err)
+	}
+	return plaintext, nil
+}
+
+// chunkNonce encodes the chunk index in the last bytes of the nonce
+func chunkNonce(aead cipher.AEAD, chunkID int32) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[len(nonce)-8:], uint64(chunkID))
+	return nonce
+}
+
+// newGCM creates an AES-GCM cipher for a 32 byte key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,87 @@
+package security
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkEncryption(t *testing.T) {
+	dataKey, err := NewDataKey()
+	if err != nil {
+		t.Fatalf("NewDataKey: %v", err)
+	}
+	otherKey, _ := NewDataKey()
+	plaintext := []byte("secret chunk")
+	ciphertext, err := EncryptChunk(dataKey, 7, plaintext)
+	if err != nil {
+		t.Fatalf("EncryptChunk: %v", err)
+	}
+	if bytes.Contains(ciphertext, plaintext) {
+		t.Fatal("ciphertext contains the plaintext")
+	}
+
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[0] ^= 1
+
+	tests := []struct {
+		name       string
+		key        []byte
+		chunkID    int32
+		ciphertext []byte
+		wantErr    bool
+	}{
+		{"same key and chunk", dataKey, 7, ciphertext, false},
+		{"moved to another chunk", dataKey, 8, ciphertext, true},
+		{"other key", otherKey, 7, ciphertext, true},
+		{"tampered", dataKey, 7, tampered, true},
+		{"short key", dataKey[:10], 7, ciphertext, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecryptChunk(tt.key, tt.chunkID, tt.ciphertext)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("DecryptChunk error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, plaintext) {
+				t.Errorf("DecryptChunk = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestWrapKey(t *testing.T) {
+	userKey, _ := NewDataKey()
+	otherUser, _ := NewDataKey()
+	dataKey, _ := NewDataKey()
+
+	wrapped, err := WrapKey(userKey, dataKey)
+	if err != nil {
+		t.Fatalf("WrapKey: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		key     []byte
+		wrapped []byte
+		wantErr bool
+	}{
+		{"user key", userKey, wrapped, false},
+		{"other user key", otherUser, wrapped, true},
+		{"truncated", userKey, wrapped[:4], true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := UnwrapKey(tt.key, tt.wrapped)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("UnwrapKey error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, dataKey) {
+				t.Error("UnwrapKey returned another key")
+			}
+		})
+	}
+
+	if KeyID(userKey) == KeyID(otherUser) || KeyID(userKey) != KeyID(append([]byte(nil), userKey...)) {
+		t.Error("KeyID doesn't tell keys apart")
+	}
+}
@@ -0,0 +1,158 @@
+package security
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// sealedMagic starts every file sealed by a Keyring, telling it apart from plaintext
+var sealedMagic = []byte("BZFSENC1")
+
+// keyIDSize is the size of the key fingerprint stored in sealed files
+const keyIDSize = 8
+
+// SealedHeaderSize is the size of the magic and key ID starting sealed data
+var SealedHeaderSize = len(sealedMagic) + keyIDSize
+
+// ErrNotSealed is returned when opening data that wasn't sealed by a Keyring, or whose header is damaged
+var ErrNotSealed = errors.New("data is not sealed")
+
+// Keyring holds the keys a Data Node encrypts chunk files at rest with. The key file lists one
+// base64 encoded 32 byte key per line: the last one seals new data and the ones before it are
+// kept to open data sealed before a rotation. Sealed data has the form
+// magic | key ID | nonce | AES-256-GCM ciphertext.
+type Keyring struct {
+	path string
+
+	mu      sync.RWMutex
+	keys    map[string][]byte // Key ID -> key
+	current string            // ID of the key new data is sealed with
+}
+
+// LoadKeyring reads the keys from a key file
+func LoadKeyring(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload reads the key file again, picking up keys added for a rotation
+func (k *Keyring) Reload() error {
+	file, err := os.Open(k.path)
+	if err != nil {
+		return fmt.Errorf("failed to open key file: %v", err)
+	}
+	defer file.Close()
+
+	keys := make(map[string][]byte)
+	current := ""
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, err := base64.StdEncoding.DecodeString(line)
+		if err != nil || len(key) != KeySize {
+			return fmt.Errorf("%s:%d: expected a base64 encoded %d byte key", k.path, lineNumber, KeySize)
+		}
+		current = KeyID(key)
+		keys[current] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read key file: %v", err)
+	}
+	if current == "" {
+		return fmt.Errorf("no keys found in %s", k.path)
+	}
+
+	k.mu.Lock()
+	k.keys, k.current = keys, current
+	k.mu.Unlock()
+	return nil
+}
+
+// Seal encrypts data with the current key
+func (k *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	k.mu.RLock()
+	keyID, key := k.current, k.keys[k.current]
+	k.mu.RUnlock()
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	id, _ := hex.DecodeString(keyID)
+
+	header := make([]byte, 0, len(sealedMagic)+keyIDSize+aead.NonceSize())
+	header = append(header, sealedMagic...)
+	header = append(header, id...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	header = append(header, nonce...)
+
+	return aead.Seal(header, nonce, plaintext, nil), nil
+}
+
+// Open decrypts data sealed with any key in the key file. Data that isn't sealed fails with
+// ErrNotSealed, callers decide whether it may be plaintext stored before encryption was enabled.
+func (k *Keyring) Open(data []byte) ([]byte, error) {
+	if !IsSealed(data) {
+		return nil, ErrNotSealed
+	}
+
+	keyID := sealedKeyID(data)
+	k.mu.RLock()
+	key, exists := k.keys[keyID]
+	k.mu.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("data is sealed with unknown key %s", keyID)
+	}
+
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	offset := len(sealedMagic) + keyIDSize
+	if len(data) < offset+aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data is truncated")
+	}
+
+	nonce := data[offset : offset+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[offset+aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data: %v", err)
+	}
+	return plaintext, nil
+}
+
+// NeedsRotation reports whether data isn't sealed with the current key.
+// Only the first SealedHeaderSize bytes of the data are needed.
+func (k *Keyring) NeedsRotation(data []byte) bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return !IsSealed(data) || sealedKeyID(data) != k.current
+}
+
+// IsSealed reports whether data was sealed by a Keyring
+func IsSealed(data []byte) bool {
+	return len(data) >= len(sealedMagic)+keyIDSize && bytes.Equal(data[:len(sealedMagic)], sealedMagic)
+}
+
+// sealedKeyID returns the ID of the key sealed data was encrypted with
+func sealedKeyID(data []byte) string {
+	return hex.EncodeToString(data[len(sealedMagic) : len(sealedMagic)+keyIDSize])
+}
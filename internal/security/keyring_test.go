@@ -0,0 +1,125 @@
+package security
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeKeyFile writes a key file holding the given keys, the last one being current
+func writeKeyFile(t *testing.T, path string, keys ...[]byte) {
+	t.Helper()
+	lines := []string{"# keys, newest last"}
+	for _, key := range keys {
+		lines = append(lines, base64.StdEncoding.EncodeToString(key))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	oldKey, newKey := randomKey(t), randomKey(t)
+	writeKeyFile(t, path, oldKey)
+	keyring, err := LoadKeyring(path)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+
+	plaintext := []byte("chunk contents")
+	sealedOld, err := keyring.Seal(plaintext)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+
+	// Rotate: the new key seals, the old one still opens
+	writeKeyFile(t, path, oldKey, newKey)
+	if err := keyring.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	sealedNew, err := keyring.Seal(plaintext)
+	if err != nil {
+		t.Fatalf("Seal: %v", err)
+	}
+	if !keyring.NeedsRotation(sealedOld) || keyring.NeedsRotation(sealedNew) {
+		t.Errorf("NeedsRotation = %v for the old key and %v for the new one", keyring.NeedsRotation(sealedOld), keyring.NeedsRotation(sealedNew))
+	}
+
+	tampered := append([]byte(nil), sealedNew...)
+	tampered[len(tampered)-1] ^= 1
+	forgotten := filepath.Join(t.TempDir(), "keys")
+	writeKeyFile(t, forgotten, newKey)
+	withoutOld, err := LoadKeyring(forgotten)
+	if err != nil {
+		t.Fatalf("LoadKeyring: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		keyring   *Keyring
+		data      []byte
+		want      []byte
+		wantErr   bool
+		notSealed bool // Whether the error is ErrNotSealed
+	}{
+		{"old key", keyring, sealedOld, plaintext, false, false},
+		{"new key", keyring, sealedNew, plaintext, false, false},
+		{"plaintext", keyring, plaintext, nil, true, true},
+		{"empty", keyring, nil, nil, true, true},
+		{"damaged header", keyring, append([]byte("XZFSENC1"), sealedNew[len(sealedMagic):]...), nil, true, true},
+		{"tampered ciphertext", keyring, tampered, nil, true, false},
+		{"truncated", keyring, sealedNew[:len(sealedMagic)+keyIDSize+4], nil, true, false},
+		{"key no longer in the key file", withoutOld, sealedOld, nil, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.keyring.Open(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open error = %v, want error %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrNotSealed) != tt.notSealed {
+				t.Fatalf("Open error = %v, want ErrNotSealed %v", err, tt.notSealed)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("Open = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadKeyringInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"no keys", "# nothing yet\n\n"},
+		{"not base64", "not a key!\n"},
+		{"short key", base64.StdEncoding.EncodeToString([]byte("short")) + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "keys")
+			if err := os.WriteFile(path, []byte(tt.contents), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadKeyring(path); err == nil {
+				t.Error("LoadKeyring succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package security
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"net/http"
+	"os"
+)
+
+// ServerTLSConfig builds the TLS configuration for a server. Client certificates
+// signed by the CA are verified when presented, so handlers can require them.
+func ServerTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %v", err)
+	}
+
+	pool, err := loadCAPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ClientTLSConfig builds the TLS configuration for connecting to servers signed by the CA.
+// The certificate is optional and only needed where the server requires mutual TLS.
+func ClientTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	pool, err := loadCAPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// IsNodeCertificate reports whether the peer presented a certificate signed by the CA that
+// belongs to a cluster node. Node certificates are valid for serving as well, which tells them
+// apart from certificates issued to clients.
+func IsNodeCertificate(state *tls.ConnectionState) bool {
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return false
+	}
+	for _, usage := range state.VerifiedChains[0][0].ExtKeyUsage {
+		if usage == x509.ExtKeyUsageServerAuth {
+			return true
+		}
+	}
+	return false
+}
+
+// DialOption returns the gRPC transport credentials for the TLS configuration, or an insecure
+// connection without one
+func DialOption(config *tls.Config) grpc.DialOption {
+	if config == nil {
+		return grpc.WithInsecure()
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(config))
+}
+
+// Scheme returns the URL scheme for HTTP requests made with the TLS configuration
+func Scheme(config *tls.Config) string {
+	if config == nil {
+		return "http"
+	}
+	return "https"
+}
+
+// HTTPClient returns an HTTP client using the TLS configuration, or the default client without one
+func HTTPClient(config *tls.Config) *http.Client {
+	if config == nil {
+		return http.DefaultClient
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config
+	return &http.Client{Transport: transport}
+}
+
+// loadCAPool reads the PEM encoded CA certificates used to verify peers
+func loadCAPool(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %v", err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", caFile)
+	}
+	return pool, nil
+}
@@ -0,0 +1,131 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenVerify(t *testing.T) {
+	signer := NewTokenSigner([]byte("0123456789abcdef"), time.Hour)
+	token := signer.Sign(OpDownload, "file/hash")
+
+	expired := NewTokenSigner([]byte("0123456789abcdef"), -time.Minute).Sign(OpDownload, "file/hash")
+	other := NewTokenSigner([]byte("fedcba9876543210"), time.Hour).Sign(OpDownload, "file/hash")
+	_, mac, _ := strings.Cut(token, ".")
+	extended := strconv.FormatInt(time.Now().Add(24*time.Hour).Unix(), 10) + "." + mac
+
+	tests := []struct {
+		name     string
+		token    string
+		op       string
+		resource string
+		wantErr  string
+	}{
+		{"valid", token, OpDownload, "file/hash", ""},
+		{"other operation", token, OpDelete, "file/hash", "invalid token"},
+		{"other resource", token, OpDownload, "other/hash", "invalid token"},
+		{"other secret", other, OpDownload, "file/hash", "invalid token"},
+		{"expiry changed", extended, OpDownload, "file/hash", "invalid token"},
+		{"expired", expired, OpDownload, "file/hash", "token expired"},
+		{"malformed", "nodot", OpDownload, "file/hash", "malformed token"},
+		{"empty", "", OpDownload, "file/hash", "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := signer.Verify(tt.token, tt.op, tt.resource)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Verify: %v", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Verify error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTokenResources(t *testing.T) {
+	tests := []struct {
+		name string
+		got  string
+		want string
+	}{
+		{"download by a node", DownloadResource("", "abc"), "abc"},
+		{"download of a file", DownloadResource("dir/file", "abc"), "dir/file/abc"},
+		{"upload to one node", UploadResource("file", "3", nil), "file/3/"},
+		{"upload pipeline", UploadResource("file", "3", []string{"a:1", "b:2"}), "file/3/a:1,b:2"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+	}
+
+	// A pipeline can't be changed without invalidating the upload token
+	signer := NewTokenSigner([]byte("0123456789abcdef"), time.Hour)
+	token := signer.Sign(OpUpload, UploadResource("file", "0", []string{"a:1", "b:2"}))
+	if err := signer.Verify(token, OpUpload, UploadResource("file", "0", []string{"a:1", "evil:80"})); err == nil {
+		t.Error("upload token accepted for another pipeline")
+	}
+}
+
+func TestReceipts(t *testing.T) {
+	signer := NewTokenSigner([]byte("0123456789abcdef"), time.Hour)
+	receipt := signer.SignReceipt("hash", 1000, 4000)
+
+	tests := []struct {
+		name       string
+		receipt    string
+		hash       string
+		storedSize int64
+		size       int64
+		wantErr    bool
+	}{
+		{"valid", receipt, "hash", 1000, 4000, false},
+		{"other stored size", receipt, "hash", 1001, 4000, true},
+		{"other size", receipt, "hash", 1000, 0, true},
+		{"sizes swapped", receipt, "hash", 4000, 1000, true},
+		{"other hash", receipt, "other", 1000, 4000, true},
+		{"missing", "", "hash", 1000, 4000, true},
+		{"access token", signer.Sign(OpUpload, "hash"), "hash", 1000, 4000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := signer.VerifyReceipt(tt.receipt, tt.hash, tt.storedSize, tt.size); (err != nil) != tt.wantErr {
+				t.Errorf("VerifyReceipt error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadTokenSigner(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		secret  string
+		wantErr bool
+	}{
+		{"long enough", "0123456789abcdef\n", false},
+		{"too short", "short\n", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, strings.ReplaceAll(tt.name, " ", "_"))
+			if err := os.WriteFile(path, []byte(tt.secret), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadTokenSigner(path, time.Hour); (err != nil) != tt.wantErr {
+				t.Errorf("LoadTokenSigner error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := LoadTokenSigner(filepath.Join(dir, "missing"), time.Hour); err == nil {
+		t.Error("LoadTokenSigner of a missing file succeeded")
+	}
+}
@@ -0,0 +1,302 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strings"
+)
+
+// Permissions granted by an ACL entry
+const (
+	permRead  = 1 << iota // Download the file or list the directory
+	permWrite             // Upload, overwrite or delete files
+	permAdmin             // Change the ACL, implies read and write
+)
+
+// Principals an ACL entry can grant permissions to
+const (
+	userPrincipal        = "user:"
+	groupPrincipal       = "group:"
+	owningGroupPrincipal = "group" // Members of the group owning the file or directory
+	otherPrincipal       = "other"
+)
+
+// rootDirectory is the ACL path of the top-level directory
+const rootDirectory = "/"
+
+// accessControl is the ACL of a file or directory. The owner always has every permission.
+// Files without an ACL of their own, and new files, take the ACL of the nearest directory.
+type accessControl struct {
+	owner   string
+	group   string
+	entries map[string]int // Principal -> granted permissions
+}
+
+// GetACL returns the ACL in effect for a file or directory
+func (m *ManagerNode) GetACL(ctx context.Context, req *pb.GetACLRequest) (*pb.ACL, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.Path, permRead); err != nil {
+		return nil, err
+	}
+
+	acl, from := m.effectiveACL(req.Path)
+	if acl == nil {
+		return &pb.ACL{Path: req.Path}, nil
+	}
+
+	response := acl.toProto(req.Path)
+	if from != req.Path {
+		response.InheritedFrom = from
+	}
+	return response, nil
+}
+
+// SetACL replaces the ACL of a file or directory. Changing an existing ACL requires admin
+// permission, and so does taking ownership of a path inside a directory that has an ACL.
+func (m *ManagerNode) SetACL(ctx context.Context, req *pb.SetACLRequest) (*pb.ACL, error) {
+	entries := make(map[string]int, len(req.Entries))
+	for _, entry := range req.Entries {
+		if err := validPrincipal(entry.Principal); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		perms, err := parsePermissions(entry.Permissions)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		entries[entry.Principal] = perms
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.Path, permAdmin); err != nil {
+		return nil, err
+	}
+
+	user := userFromContext(ctx)
+	acl := &accessControl{owner: user, group: m.primaryGroup(user), entries: entries}
+	if existing, exists := m.acls[req.Path]; exists {
+		acl.owner, acl.group = existing.owner, existing.group
+	}
+	if req.Owner != "" {
+		acl.owner = req.Owner
+	}
+	if req.Group != "" {
+		acl.group = req.Group
+	}
+	m.acls[req.Path] = acl
+
+	// A file changing owner counts towards the new owner's usage
+	m.recharge(req.Path)
+
+	return acl.toProto(req.Path), nil
+}
+
+// checkAccess fails with PermissionDenied unless the user in ctx has perm on path.
+// Access is unrestricted while clients are not authenticated, and paths without an ACL
+// anywhere up the directory tree are open to everyone. Nobody administers the root
+// directory until it has an ACL though, as that would make every user a cluster admin.
+// The caller must hold m.mu.
+func (m *ManagerNode) checkAccess(ctx context.Context, path string, perm int) error {
+	if len(m.APIKeys) == 0 {
+		return nil
+	}
+
+	user := userFromContext(ctx)
+	acl, _ := m.effectiveACL(path)
+	if acl == nil && path == rootDirectory && perm&permAdmin != 0 {
+		return status.Errorf(codes.PermissionDenied, "the root directory has no owner, start the Manager Node with -root-owner or -admin-group")
+	}
+	if acl == nil || acl.permissions(user, m.Groups[user])&perm == perm {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "user %s may not %s %s", user, permissionName(perm), path)
+}
+
+// checkAdmin fails with PermissionDenied unless the user in ctx administers the root directory,
+// which operations affecting the whole cluster require
+func (m *ManagerNode) checkAdmin(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.checkAccess(ctx, rootDirectory, permAdmin)
+}
+
+// SeedRootACL gives the root directory an ACL owned by owner and administered by the members of group,
+// unless it has one already. Other users get no access until an admin grants it with SetACL.
+func (m *ManagerNode) SeedRootACL(owner, group string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.acls[rootDirectory]; exists {
+		return
+	}
+	entries := make(map[string]int)
+	if group != "" {
+		entries[owningGroupPrincipal] = permAdmin
+	}
+	m.acls[rootDirectory] = &accessControl{owner: owner, group: group, entries: entries}
+}
+
+// writerOwner returns the owner a file will have once the user in ctx writes it, who claims it
+// if it has no ACL of its own yet. The caller must hold m.mu.
+func (m *ManagerNode) writerOwner(ctx context.Context, fileID string) string {
+	if _, exists := m.acls[fileID]; !exists && len(m.APIKeys) > 0 {
+		return userFromContext(ctx)
+	}
+	return m.fileOwner(fileID)
+}
+
+// claimFile gives the uploader of a new file ownership of it, inheriting the entries of its directory.
+// The caller must hold m.mu.
+func (m *ManagerNode) claimFile(ctx context.Context, fileID string) {
+	if _, exists := m.acls[fileID]; exists || len(m.APIKeys) == 0 {
+		return
+	}
+
+	user := userFromContext(ctx)
+	acl := &accessControl{owner: user, group: m.primaryGroup(user), entries: make(map[string]int)}
+	if parent, _ := m.effectiveACL(fileID); parent != nil {
+		acl.group = parent.group
+		for principal, perms := range parent.entries {
+			acl.entries[principal] = perms
+		}
+	}
+	m.acls[fileID] = acl
+}
+
+// effectiveACL returns the ACL set on path or, failing that, on its nearest parent directory,
+// together with the path the ACL was set on.
+// The caller must hold m.mu.
+func (m *ManagerNode) effectiveACL(path string) (*accessControl, string) {
+	for _, candidate := range aclLookupPaths(path) {
+		if acl, exists := m.acls[candidate]; exists {
+			return acl, candidate
+		}
+	}
+	return nil, ""
+}
+
+// primaryGroup returns the first group the user belongs to, or an empty group.
+// The caller must hold m.mu.
+func (m *ManagerNode) primaryGroup(user string) string {
+	if groups := m.Groups[user]; len(groups) > 0 {
+		return groups[0]
+	}
+	return ""
+}
+
+// permissions returns everything the ACL grants to a user belonging to groups
+func (acl *accessControl) permissions(user string, groups []string) int {
+	if user == acl.owner {
+		return permRead | permWrite | permAdmin
+	}
+
+	perms := acl.entries[otherPrincipal] | acl.entries[userPrincipal+user]
+	for _, group := range groups {
+		perms |= acl.entries[groupPrincipal+group]
+		if group == acl.group {
+			perms |= acl.entries[owningGroupPrincipal]
+		}
+	}
+	if perms&permAdmin != 0 {
+		perms |= permRead | permWrite
+	}
+	return perms
+}
+
+// toProto converts the ACL to its protobuf message, with entries in a stable order
+func (acl *accessControl) toProto(path string) *pb.ACL {
+	response := &pb.ACL{Path: path, Owner: acl.owner, Group: acl.group}
+	for principal, perms := range acl.entries {
+		response.Entries = append(response.Entries, &pb.ACLEntry{
+			Principal:   principal,
+			Permissions: formatPermissions(perms),
+		})
+	}
+	sort.Slice(response.Entries, func(i, j int) bool {
+		return response.Entries[i].Principal < response.Entries[j].Principal
+	})
+	return response
+}
+
+// aclLookupPaths lists path followed by its parent directories up to the root.
+// File IDs may contain slashes, which divide them into directories ending in "/".
+func aclLookupPaths(path string) []string {
+	paths := []string{path}
+	dir := strings.TrimSuffix(path, "/")
+	for {
+		i := strings.LastIndex(dir, "/")
+		if i <= 0 {
+			break
+		}
+		dir = dir[:i]
+		paths = append(paths, dir+"/")
+	}
+	if path != rootDirectory {
+		paths = append(paths, rootDirectory)
+	}
+	return paths
+}
+
+// validPrincipal checks an ACL entry names a user, a group, the owning group or everyone else
+func validPrincipal(principal string) error {
+	if principal == otherPrincipal || principal == owningGroupPrincipal {
+		return nil
+	}
+	for _, prefix := range []string{userPrincipal, groupPrincipal} {
+		if strings.HasPrefix(principal, prefix) && len(principal) > len(prefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid principal %q, expected user:<name>, group:<name>, group or other", principal)
+}
+
+// parsePermissions parses a combination of r, w and a
+func parsePermissions(value string) (int, error) {
+	perms := 0
+	for _, c := range value {
+		switch c {
+		case 'r':
+			perms |= permRead
+		case 'w':
+			perms |= permWrite
+		case 'a':
+			perms |= permAdmin
+		default:
+			return 0, fmt.Errorf("invalid permission %q, expected a combination of r, w and a", c)
+		}
+	}
+	return perms, nil
+}
+
+// formatPermissions is the inverse of parsePermissions
+func formatPermissions(perms int) string {
+	var b strings.Builder
+	for _, p := range []struct {
+		perm int
+		flag byte
+	}{{permRead, 'r'}, {permWrite, 'w'}, {permAdmin, 'a'}} {
+		if perms&p.perm != 0 {
+			b.WriteByte(p.flag)
+		}
+	}
+	return b.String()
+}
+
+// permissionName describes a permission in error messages
+func permissionName(perm int) string {
+	switch perm {
+	case permRead:
+		return "read"
+	case permWrite:
+		return "write"
+	default:
+		return "administer"
+	}
+}
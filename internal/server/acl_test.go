@@ -0,0 +1,196 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestACLPermissions(t *testing.T) {
+	acl := &accessControl{
+		owner: "alice",
+		group: "staff",
+		entries: map[string]int{
+			otherPrincipal:       permRead,
+			owningGroupPrincipal: permRead | permWrite,
+			"group:ops":          permAdmin,
+			"user:mallory":       permWrite,
+			"group:contract":     permRead,
+		},
+	}
+	tests := []struct {
+		name   string
+		user   string
+		groups []string
+		want   int
+	}{
+		{"owner", "alice", nil, permRead | permWrite | permAdmin},
+		{"everyone else", "carol", nil, permRead},
+		{"owning group", "bob", []string{"staff"}, permRead | permWrite},
+		{"named group with admin", "dave", []string{"ops"}, permRead | permWrite | permAdmin},
+		{"named user", "mallory", nil, permRead | permWrite},
+		{"several groups", "erin", []string{"contract", "staff"}, permRead | permWrite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := acl.permissions(tt.user, tt.groups); got != tt.want {
+				t.Errorf("permissions(%q, %v) = %s, want %s", tt.user, tt.groups, formatPermissions(got), formatPermissions(tt.want))
+			}
+		})
+	}
+}
+
+func TestCheckAccess(t *testing.T) {
+	m := newTestManager(t, map[string][]string{"alice": {"staff"}, "bob": {"staff"}, "carol": nil, "root": {"admins"}})
+	m.acls[rootDirectory] = &accessControl{owner: "root", group: "admins", entries: map[string]int{otherPrincipal: permRead}}
+	m.acls["team/"] = &accessControl{owner: "alice", group: "staff", entries: map[string]int{owningGroupPrincipal: permRead | permWrite}}
+	m.acls["team/private"] = &accessControl{owner: "alice", group: "staff", entries: map[string]int{}}
+
+	tests := []struct {
+		name string
+		user string
+		path string
+		perm int
+		want bool
+	}{
+		{"root admin anywhere up the tree", "root", "docs/a", permAdmin, true},
+		{"read from the root ACL", "carol", "docs/a", permRead, true},
+		{"no write from the root ACL", "carol", "docs/a", permWrite, false},
+		{"group write inherited by files", "bob", "team/sub/file", permWrite, true},
+		{"outsider in a group directory", "carol", "team/file", permRead, false},
+		{"file ACL overrides its directory", "bob", "team/private", permRead, false},
+		{"owner of the file", "alice", "team/private", permAdmin, true},
+		{"root admin of a file with its own ACL", "root", "team/private", permRead, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := m.checkAccess(asUser(tt.user), tt.path, tt.perm)
+			if (err == nil) != tt.want {
+				t.Fatalf("checkAccess(%s, %s, %s) = %v, want allowed %v", tt.user, tt.path, permissionName(tt.perm), err, tt.want)
+			}
+			if err != nil && status.Code(err) != codes.PermissionDenied {
+				t.Errorf("checkAccess code = %v, want PermissionDenied", status.Code(err))
+			}
+		})
+	}
+
+	// Without API keys everyone may do anything
+	open := newTestManager(t, nil)
+	open.acls["team/private"] = &accessControl{owner: "alice", entries: map[string]int{}}
+	if err := open.checkAccess(asUser(anonymousUser), "team/private", permAdmin); err != nil {
+		t.Errorf("checkAccess without API keys: %v", err)
+	}
+}
+
+func TestClaimFile(t *testing.T) {
+	m := newTestManager(t, map[string][]string{"alice": {"staff"}, "bob": {"contractors", "staff"}})
+	m.acls["team/"] = &accessControl{owner: "alice", group: "staff", entries: map[string]int{owningGroupPrincipal: permRead}}
+
+	m.claimFile(asUser("bob"), "team/report")
+	m.claimFile(asUser("bob"), "notes")
+	m.claimFile(asUser("alice"), "team/report") // Already claimed
+
+	tests := []struct {
+		path string
+		want *accessControl
+	}{
+		// Files in a directory with an ACL inherit its group and entries
+		{"team/report", &accessControl{owner: "bob", group: "staff", entries: map[string]int{owningGroupPrincipal: permRead}}},
+		{"notes", &accessControl{owner: "bob", group: "contractors", entries: map[string]int{}}},
+	}
+	for _, tt := range tests {
+		if got := m.acls[tt.path]; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ACL of %s = %+v, want %+v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSetACL(t *testing.T) {
+	m := newTestManager(t, map[string][]string{"alice": {"staff"}, "bob": {"staff"}})
+	alice, bob := asUser("alice"), asUser("bob")
+
+	if _, err := m.SetACL(alice, &pb.SetACLRequest{Path: "team/", Entries: []*pb.ACLEntry{{Principal: "group", Permissions: "rw"}}}); err != nil {
+		t.Fatalf("SetACL: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		ctx      string
+		req      *pb.SetACLRequest
+		wantCode codes.Code
+	}{
+		{"member without admin", "bob", &pb.SetACLRequest{Path: "team/", Entries: []*pb.ACLEntry{{Principal: "other", Permissions: "r"}}}, codes.PermissionDenied},
+		{"invalid principal", "alice", &pb.SetACLRequest{Path: "team/", Entries: []*pb.ACLEntry{{Principal: "everyone", Permissions: "r"}}}, codes.InvalidArgument},
+		{"invalid permission", "alice", &pb.SetACLRequest{Path: "team/", Entries: []*pb.ACLEntry{{Principal: "other", Permissions: "x"}}}, codes.InvalidArgument},
+		{"owner changes entries", "alice", &pb.SetACLRequest{Path: "team/", Entries: []*pb.ACLEntry{{Principal: "user:bob", Permissions: "a"}}}, codes.OK},
+		{"new admin takes the directory over", "bob", &pb.SetACLRequest{Path: "team/", Owner: "bob"}, codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.SetACL(asUser(tt.ctx), tt.req); status.Code(err) != tt.wantCode {
+				t.Errorf("SetACL error = %v, want code %v", err, tt.wantCode)
+			}
+		})
+	}
+
+	// Files without an ACL of their own inherit the new owner, and the group kept from before
+	acl, err := m.GetACL(bob, &pb.GetACLRequest{Path: "team/file"})
+	if err != nil {
+		t.Fatalf("GetACL: %v", err)
+	}
+	if acl.Owner != "bob" || acl.Group != "staff" || acl.InheritedFrom != "team/" {
+		t.Errorf("GetACL = owner %q, group %q, inherited from %q", acl.Owner, acl.Group, acl.InheritedFrom)
+	}
+	if _, err := m.GetACL(alice, &pb.GetACLRequest{Path: "team/file"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetACL by the previous owner without entries = %v, want PermissionDenied", err)
+	}
+}
+
+func TestRootACL(t *testing.T) {
+	m := newTestManager(t, map[string][]string{"alice": {"staff"}, "root": nil, "dave": {"admins"}})
+	alice := asUser("alice")
+	setRoot := &pb.SetACLRequest{Path: rootDirectory, Entries: []*pb.ACLEntry{{Principal: "other", Permissions: "rwa"}}}
+
+	// Nobody administers the cluster before the root directory has an owner
+	if _, err := m.SetACL(alice, setRoot); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("SetACL on the root directory without a root ACL error = %v, want PermissionDenied", err)
+	}
+	if err := m.checkAdmin(asUser("root")); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("checkAdmin without a root ACL error = %v, want PermissionDenied", err)
+	}
+	if err := m.checkAccess(alice, "docs/a", permWrite); err != nil {
+		t.Errorf("checkAccess below a root directory without an ACL: %v", err)
+	}
+
+	m.SeedRootACL("root", "admins")
+	m.SeedRootACL("alice", "staff") // Keeps the existing ACL
+
+	tests := []struct {
+		name string
+		user string
+		want codes.Code
+	}{
+		{"root owner", "root", codes.OK},
+		{"admin group member", "dave", codes.OK},
+		{"other user", "alice", codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := m.checkAdmin(asUser(tt.user)); status.Code(err) != tt.want {
+				t.Errorf("checkAdmin(%s) error = %v, want code %v", tt.user, err, tt.want)
+			}
+			req := &pb.SetACLRequest{Path: rootDirectory, Entries: []*pb.ACLEntry{{Principal: "group", Permissions: "a"}}}
+			if _, err := m.SetACL(asUser(tt.user), req); status.Code(err) != tt.want {
+				t.Errorf("SetACL on the root directory by %s error = %v, want code %v", tt.user, err, tt.want)
+			}
+		})
+	}
+
+	// Without API keys everyone administers the cluster
+	if err := newTestManager(t, nil).checkAdmin(asUser(anonymousUser)); err != nil {
+		t.Errorf("checkAdmin without API keys: %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"time"
+)
+
+// Limits on the user-defined attributes of a file, they are all kept in memory
+const (
+	maxAttributes         = 64
+	maxAttributeKeyLength = 128
+	maxAttributeValueSize = 1024
+)
+
+// GetAttributes returns the user-defined attributes of a file
+func (m *ManagerNode) GetAttributes(ctx context.Context, req *pb.GetAttributesRequest) (*pb.FileAttributes, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permRead); err != nil {
+		return nil, err
+	}
+	metadata, exists := m.files[req.FileId]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+	return &pb.FileAttributes{FileId: req.FileId, Attributes: copyAttributes(metadata.attributes)}, nil
+}
+
+// SetAttributes adds, changes and removes user-defined attributes of a file, leaving the others as they are.
+// Retention rules select files by attribute, so the attributes of a retained file can't change.
+func (m *ManagerNode) SetAttributes(ctx context.Context, req *pb.SetAttributesRequest) (*pb.FileAttributes, error) {
+	if err := validateAttributes(req.Set); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+	metadata, exists := m.files[req.FileId]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+	now := time.Now()
+	if err := m.checkRetention(req.FileId, now); err != nil {
+		return nil, err
+	}
+	if err := m.checkLease(req.FileId, req.LeaseId, now); err != nil {
+		return nil, err
+	}
+
+	attributes := copyAttributes(metadata.attributes)
+	for _, key := range req.Remove {
+		delete(attributes, key)
+	}
+	for key, value := range req.Set {
+		attributes[key] = value
+	}
+	if len(attributes) > maxAttributes {
+		return nil, status.Errorf(codes.InvalidArgument, "files can have at most %d attributes", maxAttributes)
+	}
+	updated := *metadata
+	updated.attributes = attributes
+	m.putFile(req.FileId, &updated)
+
+	return &pb.FileAttributes{FileId: req.FileId, Attributes: copyAttributes(attributes)}, nil
+}
+
+// validateAttributes checks attribute keys are short identifiers and values fit the limits
+func validateAttributes(attributes map[string]string) error {
+	if len(attributes) > maxAttributes {
+		return fmt.Errorf("files can have at most %d attributes", maxAttributes)
+	}
+	for key, value := range attributes {
+		if key == "" || len(key) > maxAttributeKeyLength {
+			return fmt.Errorf("attribute keys must be between 1 and %d bytes long, got %q", maxAttributeKeyLength, key)
+		}
+		for _, c := range key {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '/') {
+				return fmt.Errorf("invalid attribute key %q, expected letters, digits and any of - _ . /", key)
+			}
+		}
+		if len(value) > maxAttributeValueSize {
+			return fmt.Errorf("value of attribute %s is longer than %d bytes", key, maxAttributeValueSize)
+		}
+	}
+	return nil
+}
+
+// copyAttributes returns a copy of attributes, so responses don't share the stored map
+func copyAttributes(attributes map[string]string) map[string]string {
+	copied := make(map[string]string, len(attributes))
+	for key, value := range attributes {
+		copied[key] = value
+	}
+	return copied
+}
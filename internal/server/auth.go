@@ -0,0 +1,72 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/security"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// anonymousUser is the identity of callers when API keys are not configured
+const anonymousUser = "anonymous"
+
+// nodeMethods are called by Data Nodes, which authenticate with certificates instead of API keys
+var nodeMethods = map[string]bool{
+	pb.ManagerService_RegisterNode_FullMethodName: true,
+	pb.ManagerService_BlockReport_FullMethodName:  true,
+}
+
+// userKey is the context key holding the authenticated user
+type userKey struct{}
+
+// UnaryInterceptor authenticates clients by API key before passing requests on to the
+// ManagerService handlers. Without configured API keys every caller is anonymous.
+func (m *ManagerNode) UnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if nodeMethods[info.FullMethod] {
+		return handler(ctx, req)
+	}
+
+	user, err := m.authenticateClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(context.WithValue(ctx, userKey{}, user), req)
+}
+
+// authenticateClient looks up the user owning the API key sent with the request
+func (m *ManagerNode) authenticateClient(ctx context.Context) (string, error) {
+	if len(m.APIKeys) == 0 {
+		return anonymousUser, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	keys := md.Get(security.APIKeyHeader)
+	if len(keys) == 0 {
+		return "", status.Error(codes.Unauthenticated, "an API key is required")
+	}
+
+	user, exists := m.APIKeys[keys[0]]
+	if !exists {
+		return "", status.Error(codes.Unauthenticated, "invalid API key")
+	}
+	return user, nil
+}
+
+// userFromContext returns the user authenticated by UnaryInterceptor
+func userFromContext(ctx context.Context) string {
+	if user, ok := ctx.Value(userKey{}).(string); ok {
+		return user
+	}
+	return anonymousUser
+}
+
+// signToken issues a chunk access token, or an empty token when tokens are disabled
+func (m *ManagerNode) signToken(op, chunk string) string {
+	if m.Tokens == nil {
+		return ""
+	}
+	return m.Tokens.Sign(op, chunk)
+}
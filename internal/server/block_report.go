@@ -0,0 +1,94 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// uploadGracePeriod protects chunks of recently assigned files whose upload may still be in progress,
+// and is the default time unreferenced replicas are kept before they count as garbage
+const uploadGracePeriod = 10 * time.Minute
+
+// BlockReport reconciles the chunks a Data Node actually holds with the chunk mapping.
+// Replicas the node lost are dropped from the mapping and replicas of under-replicated chunks
+// are adopted. The reported chunks are recorded for the garbage collector, which deletes
+// those nothing refers to.
+func (m *ManagerNode) BlockReport(ctx context.Context, req *pb.BlockReportRequest) (*pb.BlockReportResponse, error) {
+	if err := m.authenticateNode(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, exists := m.nodes[req.NodeId]; !exists {
+		return nil, fmt.Errorf("node %s is not registered", req.NodeId)
+	}
+
+	now := time.Now()
+	previous := m.reported[req.NodeId]
+
+	if req.Full {
+		stored := make(map[string]time.Time, len(req.Added))
+		for _, chunk := range req.Added {
+			stored[chunk.Hash] = now
+			if firstSeen, seen := previous[chunk.Hash]; seen {
+				stored[chunk.Hash] = firstSeen
+			}
+		}
+		m.reported[req.NodeId] = stored
+
+		// Anything recorded on the node that it didn't report is missing
+		for hash, chunk := range m.chunks {
+			if now.Sub(chunk.storedAt) < uploadGracePeriod {
+				continue
+			}
+			if _, reported := stored[hash]; containsNode(chunk.nodes, req.NodeId) && !reported && !m.transfers[replicaKey(req.NodeId, hash)] {
+				m.markMissing(hash, req.NodeId)
+			}
+		}
+	} else {
+		if previous == nil {
+			previous = make(map[string]time.Time)
+			m.reported[req.NodeId] = previous
+		}
+		// Chunks just written count as new, even if the node held them before
+		for _, chunk := range req.Added {
+			previous[chunk.Hash] = now
+		}
+		for _, chunk := range req.Removed {
+			delete(previous, chunk.Hash)
+			if record, exists := m.chunks[chunk.Hash]; exists && containsNode(record.nodes, req.NodeId) {
+				m.markMissing(chunk.Hash, req.NodeId)
+			}
+		}
+	}
+
+	for _, chunk := range req.Added {
+		m.adoptReplica(req.NodeId, chunk.Hash)
+	}
+	return &pb.BlockReportResponse{}, nil
+}
+
+// adoptReplica adds a replica a node reported holding to its chunk if the chunk is
+// under-replicated. The caller must hold m.mu.
+func (m *ManagerNode) adoptReplica(nodeID, hash string) {
+	if m.transfers[replicaKey(nodeID, hash)] {
+		return
+	}
+	chunk, exists := m.chunks[hash]
+	if !exists || containsNode(chunk.nodes, nodeID) || len(chunk.nodes) >= m.wantedReplicas(chunk) {
+		return
+	}
+	chunk.nodes = append(append([]string(nil), chunk.nodes...), nodeID)
+	log.Printf("Recovered replica of chunk %s on %s", hash, nodeID)
+}
+
+// markMissing drops a replica the node no longer holds. The caller must hold m.mu.
+func (m *ManagerNode) markMissing(hash, nodeID string) {
+	m.removeReplica(hash, nodeID)
+	log.Printf("Replica of chunk %s is missing on %s, %d replicas left", hash, nodeID, len(m.chunks[hash].nodes))
+}
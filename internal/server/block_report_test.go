@@ -0,0 +1,125 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBlockReport(t *testing.T) {
+	stored := func(hashes ...string) []*pb.StoredChunk {
+		chunks := make([]*pb.StoredChunk, len(hashes))
+		for i, hash := range hashes {
+			chunks[i] = &pb.StoredChunk{Hash: hash}
+		}
+		return chunks
+	}
+
+	tests := []struct {
+		name      string
+		setup     func(m *ManagerNode)
+		req       *pb.BlockReportRequest
+		wantNodes []string // Replicas of h1 afterwards
+	}{
+		{
+			name:      "complete inventory",
+			req:       &pb.BlockReportRequest{NodeId: "n1", Full: true, Added: stored("h1", "h2")},
+			wantNodes: []string{"n1", "n2"},
+		},
+		{
+			name:      "lost replica",
+			req:       &pb.BlockReportRequest{NodeId: "n1", Full: true, Added: stored("h2")},
+			wantNodes: []string{"n2"},
+		},
+		{
+			name:      "recent upload",
+			setup:     func(m *ManagerNode) { m.chunks["h1"].storedAt = time.Now() },
+			req:       &pb.BlockReportRequest{NodeId: "n1", Full: true, Added: stored("h2")},
+			wantNodes: []string{"n1", "n2"},
+		},
+		{
+			name:      "replica being transferred",
+			setup:     func(m *ManagerNode) { m.transfers[replicaKey("n1", "h1")] = true },
+			req:       &pb.BlockReportRequest{NodeId: "n1", Full: true, Added: stored("h2")},
+			wantNodes: []string{"n1", "n2"},
+		},
+		{
+			name:      "removed replica",
+			req:       &pb.BlockReportRequest{NodeId: "n1", Removed: stored("h1")},
+			wantNodes: []string{"n2"},
+		},
+		{
+			name:      "removed replica of another node",
+			req:       &pb.BlockReportRequest{NodeId: "n3", Removed: stored("h1")},
+			wantNodes: []string{"n1", "n2"},
+		},
+		{
+			name:      "adopted by under-replicated chunk",
+			setup:     func(m *ManagerNode) { m.chunks["h1"].nodes = []string{"n2"} },
+			req:       &pb.BlockReportRequest{NodeId: "n3", Added: stored("h1")},
+			wantNodes: []string{"n2", "n3"},
+		},
+		{
+			name:      "extra replica",
+			req:       &pb.BlockReportRequest{NodeId: "n3", Added: stored("h1")},
+			wantNodes: []string{"n1", "n2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil,
+				testFile{id: "a", chunks: []string{"h1"}, chunkSize: 10},
+				testFile{id: "b", chunks: []string{"h2"}, chunkSize: 10})
+			m.nodes["n3"] = "127.0.0.1:3"
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+
+			if _, err := m.BlockReport(context.Background(), tt.req); err != nil {
+				t.Fatalf("BlockReport() error = %v", err)
+			}
+			if got := m.chunks["h1"].nodes; !reflect.DeepEqual(got, tt.wantNodes) {
+				t.Errorf("nodes of h1 = %v, want %v", got, tt.wantNodes)
+			}
+			for _, chunk := range tt.req.Added {
+				if _, reported := m.reported[tt.req.NodeId][chunk.Hash]; !reported {
+					t.Errorf("chunk %s reported by %s was not recorded", chunk.Hash, tt.req.NodeId)
+				}
+			}
+			for _, chunk := range tt.req.Removed {
+				if _, reported := m.reported[tt.req.NodeId][chunk.Hash]; reported {
+					t.Errorf("chunk %s removed from %s is still recorded", chunk.Hash, tt.req.NodeId)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockReportKeepsFirstSeen(t *testing.T) {
+	m := newTestManager(t, nil)
+	firstSeen := time.Now().Add(-time.Hour)
+	m.reported["n1"] = map[string]time.Time{"h1": firstSeen, "h2": firstSeen}
+
+	req := &pb.BlockReportRequest{NodeId: "n1", Full: true, Added: []*pb.StoredChunk{{Hash: "h1"}, {Hash: "h3"}}}
+	if _, err := m.BlockReport(context.Background(), req); err != nil {
+		t.Fatalf("BlockReport() error = %v", err)
+	}
+
+	reported := m.reported["n1"]
+	if !reported["h1"].Equal(firstSeen) {
+		t.Errorf("h1 first seen at %v, want %v", reported["h1"], firstSeen)
+	}
+	if _, exists := reported["h2"]; exists {
+		t.Error("h2 missing from the full report is still recorded")
+	}
+	if !reported["h3"].After(firstSeen) {
+		t.Errorf("h3 first seen at %v, want the time of the report", reported["h3"])
+	}
+
+	if _, err := m.BlockReport(context.Background(), &pb.BlockReportRequest{NodeId: "n9"}); err == nil {
+		t.Error("BlockReport() from an unregistered node succeeded")
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"breezeFS/internal/security"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// dataNodeTimeout bounds commands sent by the Manager Node to Data Nodes, which may copy whole chunks
+const dataNodeTimeout = 10 * time.Minute
+
+// replicateChunk asks the target Data Node to copy a chunk from the source Data Node.
+// It returns the checksum of the copy as computed by the target.
+func (m *ManagerNode) replicateChunk(target, source, hash string, rate int64) (string, error) {
+	url := fmt.Sprintf("%s://%s/replicate?hash=%s&source=%s&rate=%d&token=%s&source_token=%s",
+		security.Scheme(m.DataNodeTLS), target, hash, source, rate,
+		m.signToken(security.OpReplicate, hash), m.signToken(security.OpDownload, hash))
+	return m.callDataNode(http.MethodPost, url, nil)
+}
+
+// chunkChecksum fetches the checksum of a chunk stored on a Data Node
+func (m *ManagerNode) chunkChecksum(nodeAddress, hash string) (string, error) {
+	url := fmt.Sprintf("%s://%s/checksum?hash=%s&token=%s", security.Scheme(m.DataNodeTLS), nodeAddress, hash,
+		m.signToken(security.OpChecksum, hash))
+	return m.callDataNode(http.MethodGet, url, nil)
+}
+
+// deleteChunk removes a chunk from a Data Node
+func (m *ManagerNode) deleteChunk(nodeAddress, hash string) error {
+	url := fmt.Sprintf("%s://%s/delete?hash=%s&token=%s", security.Scheme(m.DataNodeTLS), nodeAddress, hash,
+		m.signToken(security.OpDelete, hash))
+	_, err := m.callDataNode(http.MethodPost, url, nil)
+	return err
+}
+
+// copyChunk copies a chunk from source to target and verifies the copy against the source checksum
+func (m *ManagerNode) copyChunk(source, target, hash string, rate int64) error {
+	copySum, err := m.replicateChunk(target, source, hash, rate)
+	if err != nil {
+		return fmt.Errorf("failed to replicate chunk %s to %s: %v", hash, target, err)
+	}
+
+	sourceSum, err := m.chunkChecksum(source, hash)
+	if err != nil {
+		return fmt.Errorf("failed to get checksum of chunk %s from %s: %v", hash, source, err)
+	}
+
+	if copySum != sourceSum {
+		m.deleteChunk(target, hash)
+		return fmt.Errorf("checksum mismatch for chunk %s on %s", hash, target)
+	}
+	return nil
+}
+
+// callDataNode performs an HTTP request against a Data Node and returns the response body.
+// With TLS enabled the Manager Node authenticates with its certificate.
+func (m *ManagerNode) callDataNode(method, url string, body io.Reader) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dataNodeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+
+	m.dataNodeHTTPOnce.Do(func() {
+		m.dataNodeHTTP = security.HTTPClient(m.DataNodeTLS)
+	})
+
+	resp, err := m.dataNodeHTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error response from server: %s", strings.TrimSpace(string(respBody)))
+	}
+	return strings.TrimSpace(string(respBody)), nil
+}
@@ -0,0 +1,421 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/chunking"
+	"breezeFS/internal/compression"
+	"breezeFS/internal/erasure"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"time"
+)
+
+// chunkRecord is a chunk stored in the cluster, addressed by the SHA-256 of its stored bytes.
+// Identical chunks are stored once per replica set and shared by every file containing them.
+type chunkRecord struct {
+	nodes      []string  // Node IDs holding a replica
+	refs       int       // Number of file chunks referring to it
+	codec      string    // Compression codec the chunk is stored with
+	size       int64     // Uncompressed size in bytes
+	storedSize int64     // Size in bytes as stored on Data Nodes
+	storedAt   time.Time // When the upload completed, replicas may not have been reported yet
+
+	stripe  *stripe         // Shards of an erasure coded chunk, only copied files also keep replicas of it
+	shardOf map[string]bool // Erasure coded chunks this chunk is a shard of
+}
+
+// pendingUpload is a file whose chunks are being uploaded. The file keeps its previous
+// contents until the upload is completed.
+type pendingUpload struct {
+	metadata     *fileMetadata
+	encryption   *pb.FileEncryption
+	storageClass string
+	owner        string // User the file counts towards
+	reserved     usage  // Usage the upload is expected to add, counted against quotas until it completes
+	dataShards   int
+	parityShards int
+	leaseID      string             // Write lease the upload was started under, if any
+	base         *fileVersion       // Version a patch applies to, nil when the whole file is uploaded
+	firstChunk   int32              // First chunk a patch replaces
+	placement    map[int32][]string // ChunkID -> nodes the chunk, or each of its shards, is uploaded to
+	hashes       map[string]bool    // Chunks the client asked about before uploading them
+	pinned       map[string]bool    // Stored chunks the client was told to skip, referenced until the upload ends
+	startedAt    time.Time
+}
+
+// FindChunks tells a client which of the chunks it is about to upload are already stored,
+// so only new data is sent
+func (m *ManagerNode) FindChunks(ctx context.Context, req *pb.FindChunksRequest) (*pb.FindChunksResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+
+	// Only chunks stored the way the upload asks for count, cold data stays erasure coded.
+	// The chunks about to be sent are protected from garbage collection until the upload ends.
+	erasureCoded := false
+	upload, uploading := m.uploads[req.FileId]
+	if uploading {
+		erasureCoded = upload.storageClass == erasure.ClassErasure
+		for _, hash := range req.Hashes {
+			upload.hashes[hash] = true
+		}
+	}
+
+	var existing []string
+	for _, hash := range req.Hashes {
+		chunk, exists := m.chunks[hash]
+		if !exists || !storedAs(chunk, erasureCoded) {
+			continue
+		}
+		existing = append(existing, hash)
+
+		// The client won't send the chunk, so it must outlive the files referring to it now
+		if uploading && !upload.pinned[hash] {
+			upload.pinned[hash] = true
+			chunk.refs++
+			m.pins[hash]++
+		}
+	}
+	return &pb.FindChunksResponse{Existing: existing}, nil
+}
+
+// CompleteUpload points the file at its uploaded chunks as a new version, keeping its previous contents
+// as a previous version. Patches keep the chunks of the file they didn't replace, so readers only
+// ever see the file before or after the whole write.
+// Chunks that were already stored gain a reference, the others are recorded on the nodes
+// they were uploaded to.
+func (m *ManagerNode) CompleteUpload(ctx context.Context, req *pb.CompleteUploadRequest) (*pb.CompleteUploadResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	upload, exists := m.uploads[req.FileId]
+	if !exists {
+		return nil, fmt.Errorf("no upload in progress for file %s", req.FileId)
+	}
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := m.checkRetention(req.FileId, now); err != nil {
+		return nil, err
+	}
+	if err := m.checkLease(req.FileId, upload.leaseID, now); err != nil {
+		return nil, err
+	}
+
+	// Check everything before changing anything
+	erasureCoded := upload.storageClass == erasure.ClassErasure
+	mapping := make(map[int32]string, len(req.Chunks))
+	encoded := make(map[string]*pb.ChunkMetadata) // Chunk hash -> the chunk whose shards were uploaded
+	for _, chunk := range req.Chunks {
+		if _, exists := upload.placement[chunk.ChunkId]; !exists {
+			return nil, fmt.Errorf("chunk %d of file %s was never assigned", chunk.ChunkId, req.FileId)
+		}
+		if !validHash(chunk.Hash) {
+			return nil, fmt.Errorf("invalid hash %q for chunk %d", chunk.Hash, chunk.ChunkId)
+		}
+		if !compression.Supported(chunk.Codec) {
+			return nil, fmt.Errorf("unsupported codec %q for chunk %d", chunk.Codec, chunk.ChunkId)
+		}
+		if len(chunk.Shards) > 0 {
+			if !erasureCoded || len(chunk.Shards) != upload.dataShards+upload.parityShards {
+				return nil, fmt.Errorf("unexpected shards for chunk %d", chunk.ChunkId)
+			}
+			for _, shard := range chunk.Shards {
+				if !validHash(shard) {
+					return nil, fmt.Errorf("invalid shard hash %q for chunk %d", shard, chunk.ChunkId)
+				}
+			}
+			if _, exists := encoded[chunk.Hash]; !exists {
+				encoded[chunk.Hash] = chunk
+			}
+		}
+		mapping[chunk.ChunkId] = chunk.Hash
+	}
+	if len(mapping) != len(upload.placement) {
+		return nil, fmt.Errorf("expected %d chunks for file %s, got %d", len(upload.placement), req.FileId, len(mapping))
+	}
+
+	// A patch replaces some chunks of the version it was started on and keeps the others
+	var kept []string
+	if upload.base != nil {
+		patched, patchKept, err := m.patchChunks(req.FileId, upload, mapping, req.Chunks)
+		if err != nil {
+			return nil, err
+		}
+		mapping, kept = patched, patchKept
+	}
+	if erasureCoded {
+		for _, chunk := range req.Chunks {
+			if record, exists := m.chunks[chunk.Hash]; (!exists || !storedAs(record, true)) && encoded[chunk.Hash] == nil {
+				return nil, fmt.Errorf("chunk %d is missing its shards", chunk.ChunkId)
+			}
+		}
+	}
+	if err := m.checkChunkSizes(upload, req.Chunks, encoded); err != nil {
+		return nil, err
+	}
+
+	// Attributes and retention outlive the contents, those given with the upload are added to the existing ones
+	createdAt := now
+	attributes := upload.metadata.attributes
+	expiresAt := upload.metadata.expiresAt
+	var retainUntil time.Time
+	if previous, exists := m.files[req.FileId]; exists {
+		createdAt = previous.createdAt
+		attributes = copyAttributes(previous.attributes)
+		for key, value := range upload.metadata.attributes {
+			attributes[key] = value
+		}
+		if expiresAt.IsZero() {
+			expiresAt = previous.expiresAt
+		}
+		retainUntil = previous.retainUntil
+	}
+	if len(attributes) > maxAttributes {
+		return nil, status.Errorf(codes.InvalidArgument, "files can have at most %d attributes", maxAttributes)
+	}
+
+	// Check quotas again with the actual sizes, the size given when the upload started may have been wrong
+	var size, physical int64
+	for _, chunk := range req.Chunks {
+		size += chunk.Size
+		if erasureCoded {
+			physical += storedWithParity(chunk.StoredSize, upload.dataShards, upload.parityShards)
+		} else {
+			physical += chunk.StoredSize * int64(len(upload.placement[chunk.ChunkId]))
+		}
+	}
+	for _, hash := range kept {
+		size += m.chunks[hash].size
+		physical += m.physicalSize(hash, upload.storageClass)
+	}
+	if _, err := m.checkQuotas(upload.owner, req.FileId, m.uploadDelta(req.FileId, size, physical)); err != nil {
+		return nil, err
+	}
+
+	// Only a completed upload claims a new file
+	m.claimFile(ctx, req.FileId)
+
+	// Chunks already stored in the upload's storage class are shared. Those only stored in the
+	// other class are kept both ways, like the chunks of a copy, so the file stays in its class.
+	deduplicated := 0
+	var orphans []*pb.ChunkMetadata // Uploaded stripes of chunks that already had one
+	for _, chunk := range req.Chunks {
+		record, exists := m.chunks[chunk.Hash]
+		if exists {
+			record.refs++
+			if storedAs(record, erasureCoded) {
+				deduplicated++
+				if uploaded := encoded[chunk.Hash]; uploaded != nil {
+					orphans = append(orphans, uploaded)
+					delete(encoded, chunk.Hash)
+				}
+				continue
+			}
+		} else {
+			record = &chunkRecord{
+				refs:       1,
+				codec:      chunk.Codec,
+				size:       chunk.Size,
+				storedSize: chunk.StoredSize,
+				storedAt:   now,
+			}
+			m.chunks[chunk.Hash] = record
+		}
+
+		if !erasureCoded {
+			record.nodes = upload.placement[chunk.ChunkId]
+			continue
+		}
+
+		// Every shard is stored on its own node
+		uploaded := encoded[chunk.Hash]
+		record.stripe = &stripe{
+			dataShards:   upload.dataShards,
+			parityShards: upload.parityShards,
+			shards:       uploaded.Shards,
+		}
+		for i, shard := range uploaded.Shards {
+			m.addShard(shard, chunk.Hash, upload.placement[uploaded.ChunkId][i], now)
+		}
+		// Repeated chunks of the upload share the stripe recorded first
+		delete(encoded, chunk.Hash)
+	}
+
+	for _, hash := range kept {
+		m.chunks[hash].refs++
+	}
+
+	// The previous contents become a previous version, and release their chunks once pruned
+	metadata := upload.metadata
+	metadata.attributes = attributes
+	metadata.expiresAt = expiresAt
+	metadata.retainUntil = retainUntil
+	metadata.size = size
+	metadata.storageClass = upload.storageClass
+	metadata.createdAt = createdAt
+	m.replaceVersion(req.FileId, &fileVersion{chunks: mapping, metadata: metadata, encryption: upload.encryption}, now)
+	m.endUpload(req.FileId)
+	m.deleteOrphanShards(orphans, upload.placement, now)
+
+	log.Printf("Upload of file %s completed as version %d, %d of %d chunks were already stored",
+		req.FileId, metadata.version, deduplicated, len(req.Chunks))
+	return &pb.CompleteUploadResponse{Message: "Upload completed"}, nil
+}
+
+// checkChunkSizes makes sure the sizes an upload declares for its chunks are those stored, as
+// quotas and file sizes are counted from them. Chunks already stored must match their record,
+// and new ones need the receipts of the Data Nodes that stored them when tokens are used.
+// Receipts confirm the uncompressed size of replicated chunks too. Data Nodes can't decompress
+// encrypted chunks or shards, whose uncompressed size is only bounded by the largest chunk a
+// client may cut. The caller must hold m.mu.
+func (m *ManagerNode) checkChunkSizes(upload *pendingUpload, chunks []*pb.ChunkMetadata, encoded map[string]*pb.ChunkMetadata) error {
+	// Repeated chunks are only uploaded once, so only one of them carries the receipt
+	declared := make(map[string]*pb.ChunkMetadata, len(chunks))
+	receipts := make(map[string]string)
+	for _, chunk := range chunks {
+		if chunk.Size < 0 || chunk.StoredSize < 0 {
+			return status.Errorf(codes.InvalidArgument, "invalid size for chunk %d", chunk.ChunkId)
+		}
+		if chunk.Size > chunking.MaxChunkSize {
+			return status.Errorf(codes.InvalidArgument, "chunk %d is larger than %d bytes", chunk.ChunkId, chunking.MaxChunkSize)
+		}
+		if chunk.Codec == compression.None && upload.encryption == nil && chunk.Size != chunk.StoredSize {
+			return status.Errorf(codes.InvalidArgument, "chunk %d is stored as is but its sizes differ", chunk.ChunkId)
+		}
+		if first, exists := declared[chunk.Hash]; exists {
+			if chunk.Size != first.Size || chunk.StoredSize != first.StoredSize {
+				return status.Errorf(codes.InvalidArgument, "chunks %d and %d are identical but their sizes differ", first.ChunkId, chunk.ChunkId)
+			}
+		} else {
+			declared[chunk.Hash] = chunk
+		}
+		if chunk.Receipt != "" {
+			receipts[chunk.Hash] = chunk.Receipt
+		}
+	}
+
+	checked := make(map[string]bool, len(declared))
+	for _, chunk := range chunks {
+		if checked[chunk.Hash] {
+			continue
+		}
+		checked[chunk.Hash] = true
+
+		if record, exists := m.chunks[chunk.Hash]; exists {
+			if chunk.Size != record.size || chunk.StoredSize != record.storedSize {
+				return status.Errorf(codes.InvalidArgument, "size of chunk %d doesn't match the stored chunk", chunk.ChunkId)
+			}
+			continue
+		}
+		if m.Tokens == nil {
+			continue
+		}
+
+		// Erasure coded chunks are only stored as their shards, each with its own receipt
+		if upload.storageClass != erasure.ClassErasure {
+			size := chunk.Size
+			if upload.encryption != nil {
+				size = chunk.StoredSize
+			}
+			if err := m.Tokens.VerifyReceipt(receipts[chunk.Hash], chunk.Hash, chunk.StoredSize, size); err != nil {
+				return status.Errorf(codes.InvalidArgument, "size of chunk %d wasn't confirmed by its Data Node: %v", chunk.ChunkId, err)
+			}
+			continue
+		}
+		uploaded := encoded[chunk.Hash]
+		if len(uploaded.ShardReceipts) != len(uploaded.Shards) {
+			return status.Errorf(codes.InvalidArgument, "chunk %d is missing its shard receipts", chunk.ChunkId)
+		}
+		shardSize := erasure.ShardSize(chunk.StoredSize, upload.dataShards)
+		for i, shard := range uploaded.Shards {
+			if err := m.Tokens.VerifyReceipt(uploaded.ShardReceipts[i], shard, shardSize, shardSize); err != nil {
+				return status.Errorf(codes.InvalidArgument, "size of shard %d of chunk %d wasn't confirmed by its Data Node: %v", i, chunk.ChunkId, err)
+			}
+		}
+	}
+	return nil
+}
+
+// endUpload forgets an upload, completed or abandoned, and releases the chunks it pinned.
+// The caller must hold m.mu.
+func (m *ManagerNode) endUpload(fileID string) {
+	upload, exists := m.uploads[fileID]
+	if !exists {
+		return
+	}
+	delete(m.uploads, fileID)
+
+	for hash := range upload.pinned {
+		if m.pins[hash]--; m.pins[hash] == 0 {
+			delete(m.pins, hash)
+		}
+		m.releaseChunk(hash)
+	}
+}
+
+// releaseChunk drops a file's reference to a chunk. Once nothing refers to the chunk its record
+// is dropped, and the garbage collector deletes its replicas.
+// The caller must hold m.mu.
+func (m *ManagerNode) releaseChunk(hash string) {
+	chunk, exists := m.chunks[hash]
+	if !exists {
+		return
+	}
+	chunk.refs--
+	if chunk.refs > 0 {
+		return
+	}
+	delete(m.chunks, hash)
+
+	if chunk.stripe != nil {
+		for _, shard := range chunk.stripe.shards {
+			if record, exists := m.chunks[shard]; exists {
+				delete(record.shardOf, hash)
+			}
+			m.releaseChunk(shard)
+		}
+	}
+}
+
+// wantedReplicas returns how many nodes should hold a chunk: shards are stored once and
+// erasure coded chunks only exist as their shards, unless a copy also stored them replicated
+func (m *ManagerNode) wantedReplicas(chunk *chunkRecord) int {
+	switch {
+	case chunk.stripe != nil && len(chunk.nodes) == 0:
+		return 0
+	case len(chunk.shardOf) > 0:
+		return 1
+	default:
+		return m.ReplicationFactor
+	}
+}
+
+// storedAs reports whether a chunk is stored the way a storage class keeps it: erasure coded
+// chunks need their shards, replicated ones replicas of their own. Chunks shared by copies in
+// both classes are stored both ways.
+func storedAs(chunk *chunkRecord, erasureCoded bool) bool {
+	if erasureCoded {
+		return chunk.stripe != nil
+	}
+	return len(chunk.nodes) > 0
+}
+
+// validHash reports whether hash is a hex encoded SHA-256
+func validHash(hash string) bool {
+	decoded, err := hex.DecodeString(hash)
+	return err == nil && len(decoded) == 32
+}
+
+// replicaKey identifies a replica of a chunk on a node
+func replicaKey(nodeID, hash string) string {
+	return nodeID + "/" + hash
+}
@@ -0,0 +1,299 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/chunking"
+	"breezeFS/internal/erasure"
+	"breezeFS/internal/security"
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestFindChunks(t *testing.T) {
+	stored, missing := hashOf("stored"), hashOf("missing")
+
+	tests := []struct {
+		name         string
+		storageClass string
+		want         []string
+		wantPinned   bool
+	}{
+		{name: "replicated upload", storageClass: erasure.ClassReplicated, want: []string{stored}, wantPinned: true},
+		{name: "erasure coded upload", storageClass: erasure.ClassErasure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, testFile{id: "a", chunks: []string{stored}, chunkSize: 10})
+			m.DataShards, m.ParityShards = 1, 1
+			ctx := context.Background()
+			if _, err := m.GetNodesForChunks(ctx, &pb.GetNodesForChunksRequest{FileId: "b", TotalChunks: 2, StorageClass: tt.storageClass}); err != nil {
+				t.Fatalf("GetNodesForChunks() error = %v", err)
+			}
+
+			// Asking twice pins the chunk once
+			for i := 0; i < 2; i++ {
+				resp, err := m.FindChunks(ctx, &pb.FindChunksRequest{FileId: "b", Hashes: []string{stored, missing}})
+				if err != nil {
+					t.Fatalf("FindChunks() error = %v", err)
+				}
+				if !reflect.DeepEqual(resp.Existing, tt.want) {
+					t.Errorf("FindChunks() = %v, want %v", resp.Existing, tt.want)
+				}
+			}
+
+			wantRefs := 1
+			if tt.wantPinned {
+				wantRefs = 2
+			}
+			if refs := m.chunks[stored].refs; refs != wantRefs {
+				t.Errorf("refs = %d, want %d", refs, wantRefs)
+			}
+			if pinned := m.pins[stored] == 1; pinned != tt.wantPinned {
+				t.Errorf("pinned = %v, want %v", pinned, tt.wantPinned)
+			}
+			if !m.uploads["b"].hashes[missing] {
+				t.Error("chunk about to be uploaded is not protected from garbage collection")
+			}
+		})
+	}
+}
+
+func TestCompleteUploadDeduplicates(t *testing.T) {
+	shared, first, second := hashOf("shared"), hashOf("first"), hashOf("second")
+	m := newTestManager(t, nil)
+
+	if err := upload(t, m, "a", 10, shared, first); err != nil {
+		t.Fatalf("upload of a: %v", err)
+	}
+	record := m.chunks[shared]
+	if err := upload(t, m, "b", 10, shared, second, second); err != nil {
+		t.Fatalf("upload of b: %v", err)
+	}
+
+	tests := []struct {
+		hash     string
+		wantRefs int
+	}{
+		{shared, 2},
+		{first, 1},
+		{second, 2},
+	}
+	for _, tt := range tests {
+		if refs := m.chunks[tt.hash].refs; refs != tt.wantRefs {
+			t.Errorf("refs of %s = %d, want %d", tt.hash, refs, tt.wantRefs)
+		}
+	}
+	if m.chunks[shared] != record {
+		t.Error("the shared chunk was recorded again")
+	}
+	if len(m.pins) != 0 || len(m.uploads) != 0 {
+		t.Errorf("pins %v and uploads %v left after the uploads completed", m.pins, m.uploads)
+	}
+}
+
+// TestFoundChunkOutlivesItsFiles deletes the only file referring to a chunk between the client
+// learning the chunk is stored, so it isn't sent, and the upload completing
+func TestFoundChunkOutlivesItsFiles(t *testing.T) {
+	stored := hashOf("stored")
+	m := newTestManager(t, nil, testFile{id: "a", chunks: []string{stored}, chunkSize: 10})
+	record := m.chunks[stored]
+	ctx := context.Background()
+
+	if _, err := m.GetNodesForChunks(ctx, &pb.GetNodesForChunksRequest{FileId: "b", TotalChunks: 1}); err != nil {
+		t.Fatalf("GetNodesForChunks() error = %v", err)
+	}
+	if _, err := m.FindChunks(ctx, &pb.FindChunksRequest{FileId: "b", Hashes: []string{stored}}); err != nil {
+		t.Fatalf("FindChunks() error = %v", err)
+	}
+	m.deleteFile("a")
+
+	if m.chunks[stored] != record {
+		t.Fatal("the chunk record was dropped while an upload relies on it")
+	}
+	if !m.markLive()[stored] {
+		t.Error("the chunk is garbage while an upload relies on it")
+	}
+
+	if _, err := m.CompleteUpload(ctx, &pb.CompleteUploadRequest{FileId: "b", Chunks: uploadedChunks(10, stored)}); err != nil {
+		t.Fatalf("CompleteUpload() error = %v", err)
+	}
+	if m.chunks[stored] != record || record.refs != 1 || !reflect.DeepEqual(record.nodes, []string{"n1", "n2"}) {
+		t.Errorf("chunk recorded with %d refs on %v, want the existing record with 1 ref on [n1 n2]", record.refs, record.nodes)
+	}
+	if len(m.pins) != 0 {
+		t.Errorf("pins = %v after the upload completed", m.pins)
+	}
+}
+
+func TestAbandonedUploadReleasesFoundChunks(t *testing.T) {
+	tests := []struct {
+		name    string
+		abandon func(m *ManagerNode) error
+	}{
+		{
+			name: "replaced by another upload",
+			abandon: func(m *ManagerNode) error {
+				_, err := m.GetNodesForChunks(context.Background(), &pb.GetNodesForChunksRequest{FileId: "b", TotalChunks: 1})
+				return err
+			},
+		},
+		{
+			name: "expired",
+			abandon: func(m *ManagerNode) error {
+				m.expireUploads(time.Now().Add(pendingUploadExpiry))
+				return nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stored := hashOf("stored")
+			m := newTestManager(t, nil, testFile{id: "a", chunks: []string{stored}, chunkSize: 10})
+			ctx := context.Background()
+			if _, err := m.GetNodesForChunks(ctx, &pb.GetNodesForChunksRequest{FileId: "b", TotalChunks: 1}); err != nil {
+				t.Fatalf("GetNodesForChunks() error = %v", err)
+			}
+			if _, err := m.FindChunks(ctx, &pb.FindChunksRequest{FileId: "b", Hashes: []string{stored}}); err != nil {
+				t.Fatalf("FindChunks() error = %v", err)
+			}
+			m.deleteFile("a")
+
+			if err := tt.abandon(m); err != nil {
+				t.Fatal(err)
+			}
+			if _, exists := m.chunks[stored]; exists {
+				t.Error("chunk nothing refers to is still recorded")
+			}
+			if len(m.pins) != 0 {
+				t.Errorf("pins = %v after the upload was abandoned", m.pins)
+			}
+		})
+	}
+}
+
+func TestCheckChunkSizes(t *testing.T) {
+	tokens := security.NewTokenSigner([]byte("0123456789abcdef"), time.Hour)
+	shardSize := erasure.ShardSize(1000, 2)
+	replicated := &pendingUpload{storageClass: erasure.ClassReplicated}
+	striped := &pendingUpload{storageClass: erasure.ClassErasure, dataShards: 2, parityShards: 1}
+	encrypted := &pendingUpload{storageClass: erasure.ClassReplicated, encryption: &pb.FileEncryption{}}
+
+	tests := []struct {
+		name     string
+		upload   *pendingUpload
+		tokens   *security.TokenSigner
+		chunks   []*pb.ChunkMetadata
+		wantCode codes.Code
+	}{
+		{
+			name: "new chunk with its receipt", upload: replicated, tokens: tokens,
+			chunks: []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "new", Size: 1000, StoredSize: 1000, Receipt: tokens.SignReceipt("new", 1000, 1000)}},
+		},
+		{
+			name: "new chunk without a receipt", upload: replicated, tokens: tokens,
+			chunks:   []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "new", Size: 1000, StoredSize: 1000}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "receipt for fewer bytes", upload: replicated, tokens: tokens,
+			chunks:   []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "new", Size: 10, StoredSize: 10, Receipt: tokens.SignReceipt("new", 1000, 1000)}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "new chunk without tokens", upload: replicated,
+			chunks: []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "new", Size: 1000, StoredSize: 1000}},
+		},
+		{
+			name: "repeated chunk shares the receipt", upload: replicated, tokens: tokens,
+			chunks: []*pb.ChunkMetadata{
+				{ChunkId: 0, Codec: "zstd", Hash: "new", Size: 4000, StoredSize: 1000, Receipt: tokens.SignReceipt("new", 1000, 4000)},
+				{ChunkId: 1, Codec: "zstd", Hash: "new", Size: 4000, StoredSize: 1000},
+			},
+		},
+		{
+			name: "compressed chunk smaller than its receipt", upload: replicated, tokens: tokens,
+			chunks:   []*pb.ChunkMetadata{{ChunkId: 0, Codec: "zstd", Hash: "new", Size: 0, StoredSize: 1000, Receipt: tokens.SignReceipt("new", 1000, 4000)}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "encrypted chunk with its receipt", upload: encrypted, tokens: tokens,
+			chunks: []*pb.ChunkMetadata{{ChunkId: 0, Codec: "zstd", Hash: "new", Size: 4000, StoredSize: 1000, Receipt: tokens.SignReceipt("new", 1000, 1000)}},
+		},
+		{
+			name: "larger than the largest chunk", upload: encrypted,
+			chunks:   []*pb.ChunkMetadata{{ChunkId: 0, Codec: "zstd", Hash: "new", Size: chunking.MaxChunkSize + 1, StoredSize: 1000}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "repeated chunk with another size", upload: replicated,
+			chunks: []*pb.ChunkMetadata{
+				{ChunkId: 0, Codec: "zstd", Hash: "new", Size: 4000, StoredSize: 1000},
+				{ChunkId: 1, Codec: "zstd", Hash: "new", Size: 1, StoredSize: 1000},
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "stored chunk needs no receipt", upload: replicated, tokens: tokens,
+			chunks: []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "stored", Size: 500, StoredSize: 500}},
+		},
+		{
+			name: "stored chunk with another size", upload: replicated,
+			chunks:   []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "stored", Size: 5, StoredSize: 5}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "uncompressed sizes differ", upload: replicated,
+			chunks:   []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "new", Size: 5000, StoredSize: 1000}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "encrypted chunks grow", upload: encrypted,
+			chunks: []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "new", Size: 984, StoredSize: 1000}},
+		},
+		{
+			name: "negative size", upload: replicated,
+			chunks:   []*pb.ChunkMetadata{{ChunkId: 0, Codec: "zstd", Hash: "new", Size: -1, StoredSize: 1000}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "shards with their receipts", upload: striped, tokens: tokens,
+			chunks: []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "new", Size: 1000, StoredSize: 1000,
+				Shards:        []string{"s0", "s1", "s2"},
+				ShardReceipts: []string{tokens.SignReceipt("s0", shardSize, shardSize), tokens.SignReceipt("s1", shardSize, shardSize), tokens.SignReceipt("s2", shardSize, shardSize)}}},
+		},
+		{
+			name: "shard receipt for another size", upload: striped, tokens: tokens,
+			chunks: []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "new", Size: 2000, StoredSize: 2000,
+				Shards:        []string{"s0", "s1", "s2"},
+				ShardReceipts: []string{tokens.SignReceipt("s0", shardSize, shardSize), tokens.SignReceipt("s1", shardSize, shardSize), tokens.SignReceipt("s2", shardSize, shardSize)}}},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "shard receipts missing", upload: striped, tokens: tokens,
+			chunks: []*pb.ChunkMetadata{{ChunkId: 0, Codec: "none", Hash: "new", Size: 1000, StoredSize: 1000,
+				Shards: []string{"s0", "s1", "s2"}}},
+			wantCode: codes.InvalidArgument,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, testFile{id: "existing", chunks: []string{"stored"}, chunkSize: 500})
+			m.Tokens = tt.tokens
+
+			encoded := make(map[string]*pb.ChunkMetadata)
+			for _, chunk := range tt.chunks {
+				if len(chunk.Shards) > 0 {
+					encoded[chunk.Hash] = chunk
+				}
+			}
+			if err := m.checkChunkSizes(tt.upload, tt.chunks, encoded); status.Code(err) != tt.wantCode {
+				t.Errorf("checkChunkSizes error = %v, want code %v", err, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,393 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/erasure"
+	"breezeFS/internal/security"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// encodeRequest asks a Data Node to erasure code a chunk held by another node and keep one of its shards
+type encodeRequest struct {
+	DataShards   int    `json:"data_shards"`
+	ParityShards int    `json:"parity_shards"`
+	Index        int    `json:"index"`
+	Source       string `json:"source"`
+	SourceToken  string `json:"source_token,omitempty"`
+}
+
+// decodeRequest asks a Data Node to rebuild an erasure coded chunk from its shards and keep it whole
+type decodeRequest struct {
+	DataShards   int           `json:"data_shards"`
+	ParityShards int           `json:"parity_shards"`
+	Size         int64         `json:"size"`
+	Shards       []shardSource `json:"shards"`
+}
+
+// CopyFile copies a file, one of its previous versions or the file as captured by a snapshot
+// to another file ID without any data passing through the client. The copy refers to the chunks
+// of the source. A copy in another storage class has Data Nodes store the chunks in that class
+// as well, after which they are kept both ways.
+func (m *ManagerNode) CopyFile(ctx context.Context, req *pb.CopyFileRequest) (*pb.FileInfo, error) {
+	if req.DestinationId == "" || strings.HasSuffix(req.DestinationId, "/") {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid destination %q", req.DestinationId)
+	}
+	if req.Snapshot != "" && req.Version != 0 {
+		return nil, status.Error(codes.InvalidArgument, "give either a version or a snapshot, not both")
+	}
+	if req.StorageClass != "" && !erasure.SupportedClass(req.StorageClass) {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown storage class %q", req.StorageClass)
+	}
+
+	m.mu.Lock()
+	source, err := m.copySource(ctx, req)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	storageClass := req.StorageClass
+	if storageClass == "" {
+		storageClass = source.metadata.storageClass
+	}
+	if storageClass == "" {
+		storageClass = erasure.ClassReplicated
+	}
+	if _, err := m.copyMetadata(ctx, req.DestinationId, source, storageClass, time.Now()); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+
+	// The source may be pruned or deleted while chunks are converted
+	if err := m.checkChunks(req.SourceId, source.chunks); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	m.pinChunks(source.chunks)
+	convert := m.unconverted(source.chunks, storageClass)
+	m.mu.Unlock()
+
+	// Convert without holding the lock, every chunk is fetched and stored by Data Nodes
+	for _, hash := range convert {
+		if err := m.convertChunk(hash, storageClass); err != nil {
+			m.mu.Lock()
+			m.unpinChunks(source.chunks, true)
+			m.mu.Unlock()
+			return nil, status.Errorf(codes.Unavailable, "failed to store chunk %s as %s: %v", hash, storageClass, err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// The destination may have been written to meanwhile
+	now := time.Now()
+	metadata, err := m.copyMetadata(ctx, req.DestinationId, source, storageClass, now)
+	m.unpinChunks(source.chunks, err != nil)
+	if err != nil {
+		return nil, err
+	}
+	chunks := make(map[int32]string, len(source.chunks))
+	for chunkID, hash := range source.chunks {
+		chunks[chunkID] = hash
+	}
+	m.claimFile(ctx, req.DestinationId)
+	m.replaceVersion(req.DestinationId, &fileVersion{chunks: chunks, metadata: metadata, encryption: source.encryption}, now)
+
+	log.Printf("User %s copied file %s version %d to %s as version %d, %d of %d chunks converted to %s",
+		userFromContext(ctx), req.SourceId, source.metadata.version, req.DestinationId, metadata.version,
+		len(convert), len(chunks), storageClass)
+	return m.fileInfo(req.DestinationId), nil
+}
+
+// copySource returns the version of a file a copy is made from. The caller must hold m.mu.
+func (m *ManagerNode) copySource(ctx context.Context, req *pb.CopyFileRequest) (*fileVersion, error) {
+	if err := m.checkAccess(ctx, req.SourceId, permRead); err != nil {
+		return nil, err
+	}
+	if req.Snapshot != "" {
+		return m.snapshotVersion(req.Snapshot, req.SourceId)
+	}
+	if _, exists := m.files[req.SourceId]; !exists {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.SourceId)
+	}
+	version := m.findVersion(req.SourceId, req.Version)
+	if version == nil {
+		return nil, status.Errorf(codes.NotFound, "file %s has no version %d", req.SourceId, req.Version)
+	}
+	return version, nil
+}
+
+// pinChunks references the chunks of a copy in progress, so they outlive the source and garbage
+// collection. Once the copy is made the references are its own. The caller must hold m.mu.
+func (m *ManagerNode) pinChunks(chunks map[int32]string) {
+	for _, hash := range chunks {
+		m.chunks[hash].refs++
+		m.pins[hash]++
+	}
+}
+
+// unpinChunks ends a copy in progress, releasing its references to the chunks unless the copy
+// was made. The caller must hold m.mu.
+func (m *ManagerNode) unpinChunks(chunks map[int32]string, release bool) {
+	for _, hash := range chunks {
+		if m.pins[hash]--; m.pins[hash] == 0 {
+			delete(m.pins, hash)
+		}
+		if release {
+			m.releaseChunk(hash)
+		}
+	}
+}
+
+// copyMetadata checks that a copy of source may be written to a file and returns the metadata of
+// the copy. Like an upload, the copy keeps the creation time, retention and attributes of the file
+// it replaces, with those of the source added. The caller must hold m.mu.
+func (m *ManagerNode) copyMetadata(ctx context.Context, fileID string, source *fileVersion, storageClass string, now time.Time) (*fileMetadata, error) {
+	if err := m.checkAccess(ctx, fileID, permWrite); err != nil {
+		return nil, err
+	}
+	if err := m.checkRetention(fileID, now); err != nil {
+		return nil, err
+	}
+	if err := m.checkLease(fileID, "", now); err != nil {
+		return nil, err
+	}
+	metadata := *source.metadata
+	metadata.attributes = copyAttributes(source.metadata.attributes)
+	metadata.storageClass = storageClass
+	metadata.createdAt = now
+	metadata.expiresAt = time.Time{}
+	metadata.retainUntil = time.Time{}
+	metadata.legalHold = false
+	if previous, exists := m.files[fileID]; exists {
+		metadata.attributes = copyAttributes(previous.attributes)
+		for key, value := range source.metadata.attributes {
+			metadata.attributes[key] = value
+		}
+		metadata.createdAt = previous.createdAt
+		metadata.expiresAt = previous.expiresAt
+		metadata.retainUntil = previous.retainUntil
+	}
+	if len(metadata.attributes) > maxAttributes {
+		return nil, status.Errorf(codes.InvalidArgument, "files can have at most %d attributes", maxAttributes)
+	}
+
+	delta := m.uploadDelta(fileID, metadata.size, m.copyPhysical(source.chunks, storageClass))
+	warning, err := m.checkQuotas(m.writerOwner(ctx, fileID), fileID, delta)
+	if err != nil {
+		return nil, err
+	}
+	if warning != "" {
+		log.Printf("Copy to file %s: %s", fileID, warning)
+	}
+	return &metadata, nil
+}
+
+// copyPhysical returns the bytes the chunks of a copy take on Data Nodes in its storage class,
+// estimating it for those not stored in that class yet. The caller must hold m.mu.
+func (m *ManagerNode) copyPhysical(chunks map[int32]string, storageClass string) int64 {
+	erasureCoded := storageClass == erasure.ClassErasure
+	counted := make(map[string]bool)
+	var physical int64
+	for _, hash := range chunks {
+		if counted[hash] {
+			continue
+		}
+		counted[hash] = true
+
+		chunk := m.chunks[hash]
+		switch {
+		case storedAs(chunk, erasureCoded):
+			physical += m.physicalSize(hash, storageClass)
+		case erasureCoded:
+			physical += storedWithParity(chunk.storedSize, m.DataShards, m.ParityShards)
+		default:
+			physical += chunk.storedSize * int64(m.ReplicationFactor)
+		}
+	}
+	return physical
+}
+
+// unconverted returns the distinct chunks that aren't stored in a storage class yet, in order.
+// The caller must hold m.mu.
+func (m *ManagerNode) unconverted(chunks map[int32]string, storageClass string) []string {
+	erasureCoded := storageClass == erasure.ClassErasure
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, hash := range chunks {
+		if !seen[hash] && !storedAs(m.chunks[hash], erasureCoded) {
+			hashes = append(hashes, hash)
+		}
+		seen[hash] = true
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// convertChunk stores a chunk in another storage class. The chunk must be pinned by the caller.
+func (m *ManagerNode) convertChunk(hash, storageClass string) error {
+	if storageClass == erasure.ClassErasure {
+		return m.encodeChunk(hash)
+	}
+	return m.decodeChunk(hash)
+}
+
+// encodeChunk has Data Nodes erasure code a replicated chunk, each keeping one shard.
+// The replicas stay, files in the replicated class keep reading them.
+func (m *ManagerNode) encodeChunk(hash string) error {
+	m.mu.Lock()
+	chunk := m.chunks[hash]
+	if chunk.stripe != nil {
+		m.mu.Unlock()
+		return nil
+	}
+	if len(chunk.nodes) == 0 {
+		m.mu.Unlock()
+		return fmt.Errorf("no replica is available")
+	}
+	dataShards, parityShards := m.DataShards, m.ParityShards
+	targets, err := m.leastUsedNodes(dataShards + parityShards)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	addresses := m.nodeAddressesFor(targets)
+	req := encodeRequest{
+		DataShards:   dataShards,
+		ParityShards: parityShards,
+		Source:       m.nodes[chunk.nodes[0]],
+		SourceToken:  m.signToken(security.OpDownload, hash),
+	}
+	m.mu.Unlock()
+
+	shards := make([]string, len(addresses))
+	for i, address := range addresses {
+		req.Index = i
+		shard, err := m.encodeShard(address, hash, req)
+		if err != nil {
+			return fmt.Errorf("failed to store shard %d on %s: %v", i, targets[i], err)
+		}
+		shards[i] = shard
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if chunk.stripe != nil {
+		// Converted by another copy meanwhile, the shards stored here are orphans now
+		return nil
+	}
+	chunk.stripe = &stripe{dataShards: dataShards, parityShards: parityShards, shards: shards}
+	now := time.Now()
+	for i, shard := range shards {
+		m.addShard(shard, hash, targets[i], now)
+	}
+	log.Printf("Chunk %s erasure coded on %d nodes", hash, len(targets))
+	return nil
+}
+
+// decodeChunk has a Data Node rebuild an erasure coded chunk from its shards and replicates it
+// from there. The shards stay, files in the erasure coded class keep reading them.
+func (m *ManagerNode) decodeChunk(hash string) error {
+	m.mu.Lock()
+	chunk := m.chunks[hash]
+	if len(chunk.nodes) > 0 || chunk.stripe == nil {
+		m.mu.Unlock()
+		return nil
+	}
+	s := chunk.stripe
+	req := decodeRequest{DataShards: s.dataShards, ParityShards: s.parityShards, Size: chunk.storedSize}
+	for _, shard := range s.shards {
+		source := shardSource{Hash: shard}
+		if record, exists := m.chunks[shard]; exists && len(record.nodes) > 0 {
+			source.Source = m.nodes[record.nodes[0]]
+			source.Token = m.signToken(security.OpDownload, shard)
+		}
+		req.Shards = append(req.Shards, source)
+	}
+	replicas := m.ReplicationFactor
+	if active := len(m.activeNodes()); replicas > active {
+		replicas = active
+	}
+	targets, err := m.leastUsedNodes(replicas)
+	if err != nil {
+		m.mu.Unlock()
+		return err
+	}
+	addresses := m.nodeAddressesFor(targets)
+	m.mu.Unlock()
+
+	if err := m.decodeOn(addresses[0], hash, req); err != nil {
+		return fmt.Errorf("failed to decode on %s: %v", targets[0], err)
+	}
+	stored := targets[:1]
+	for i, address := range addresses[1:] {
+		if err := m.copyChunk(addresses[0], address, hash, 0); err != nil {
+			log.Printf("Failed to replicate decoded chunk %s: %v", hash, err)
+			continue
+		}
+		stored = append(stored, targets[i+1])
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(chunk.nodes) > 0 {
+		// Replicated by another copy meanwhile, the replicas stored here are orphans now
+		return nil
+	}
+	chunk.nodes = stored
+	log.Printf("Chunk %s decoded and stored on %d nodes", hash, len(stored))
+	return nil
+}
+
+// leastUsedNodes returns n distinct active nodes holding the fewest chunks. The caller must hold m.mu.
+func (m *ManagerNode) leastUsedNodes(n int) ([]string, error) {
+	nodeIDs := m.activeNodes()
+	if n == 0 || len(nodeIDs) < n {
+		return nil, fmt.Errorf("%d nodes are needed, only %d are available", n, len(nodeIDs))
+	}
+	usage := m.nodeUtilisation()
+	sort.SliceStable(nodeIDs, func(i, j int) bool { return usage[nodeIDs[i]] < usage[nodeIDs[j]] })
+	return nodeIDs[:n], nil
+}
+
+// encodeShard asks a Data Node to store a shard of a chunk and returns the shard hash
+func (m *ManagerNode) encodeShard(nodeAddress, hash string, req encodeRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	url := fmt.Sprintf("%s://%s/encode?hash=%s&token=%s", security.Scheme(m.DataNodeTLS), nodeAddress, hash,
+		m.signToken(security.OpReplicate, hash))
+	shard, err := m.callDataNode(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	if !validHash(shard) {
+		return "", fmt.Errorf("invalid shard hash %q", shard)
+	}
+	return shard, nil
+}
+
+// decodeOn asks a Data Node to rebuild a chunk from its shards
+func (m *ManagerNode) decodeOn(nodeAddress, hash string, req decodeRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	url := fmt.Sprintf("%s://%s/decode?hash=%s&token=%s", security.Scheme(m.DataNodeTLS), nodeAddress, hash,
+		m.signToken(security.OpReplicate, hash))
+	_, err = m.callDataNode(http.MethodPost, url, bytes.NewReader(body))
+	return err
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/erasure"
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCopyFile(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        *pb.CopyFileRequest
+		wantCode   codes.Code
+		wantChunks []string // Chunks of the destination afterwards
+	}{
+		{name: "current version", req: &pb.CopyFileRequest{SourceId: "a", DestinationId: "b"}, wantChunks: []string{"a2", "a2"}},
+		{name: "previous version", req: &pb.CopyFileRequest{SourceId: "a", DestinationId: "b", Version: 1}, wantChunks: []string{"a1"}},
+		{name: "snapshot", req: &pb.CopyFileRequest{SourceId: "a", DestinationId: "b", Snapshot: "s"}, wantChunks: []string{"a1"}},
+		{name: "onto an existing file", req: &pb.CopyFileRequest{SourceId: "a", DestinationId: "c"}, wantChunks: []string{"a2", "a2"}},
+		{name: "version and snapshot", req: &pb.CopyFileRequest{SourceId: "a", DestinationId: "b", Version: 1, Snapshot: "s"}, wantCode: codes.InvalidArgument},
+		{name: "directory destination", req: &pb.CopyFileRequest{SourceId: "a", DestinationId: "dir/"}, wantCode: codes.InvalidArgument},
+		{name: "unknown storage class", req: &pb.CopyFileRequest{SourceId: "a", DestinationId: "b", StorageClass: "tape"}, wantCode: codes.InvalidArgument},
+		{name: "unknown source", req: &pb.CopyFileRequest{SourceId: "x", DestinationId: "b"}, wantCode: codes.NotFound},
+		{name: "unknown version", req: &pb.CopyFileRequest{SourceId: "a", DestinationId: "b", Version: 7}, wantCode: codes.NotFound},
+		{name: "retained destination", req: &pb.CopyFileRequest{SourceId: "a", DestinationId: "kept"}, wantCode: codes.FailedPrecondition, wantChunks: []string{"k1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil,
+				testFile{id: "a", chunks: []string{"a1"}, chunkSize: 10},
+				testFile{id: "c", chunks: []string{"c1"}, chunkSize: 10},
+				testFile{id: "kept", chunks: []string{"k1"}, chunkSize: 10},
+			)
+			ctx := context.Background()
+			if _, err := m.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: "s"}); err != nil {
+				t.Fatalf("CreateSnapshot() error = %v", err)
+			}
+			storeFile(t, m, testFile{id: "a", chunks: []string{"a2", "a2"}, chunkSize: 10})
+			m.files["kept"].retainUntil = time.Now().Add(time.Hour)
+			refs := make(map[string]int)
+			for hash, chunk := range m.chunks {
+				refs[hash] = chunk.refs
+			}
+
+			_, err := m.CopyFile(ctx, tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("CopyFile() error = %v, want code %v", err, tt.wantCode)
+			}
+
+			var chunks []string
+			for i := 0; i < len(m.chunkMapping[tt.req.DestinationId]); i++ {
+				chunks = append(chunks, m.chunkMapping[tt.req.DestinationId][int32(i)])
+			}
+			if !reflect.DeepEqual(chunks, tt.wantChunks) {
+				t.Errorf("chunks of the destination = %v, want %v", chunks, tt.wantChunks)
+			}
+			// A copy refers to the chunks of the source, a failed one leaves them as they were
+			for _, hash := range chunks {
+				if err == nil {
+					refs[hash]++
+				}
+			}
+			for hash, want := range refs {
+				if got := m.chunks[hash].refs; got != want {
+					t.Errorf("refs of %s = %d, want %d", hash, got, want)
+				}
+			}
+			if len(m.pins) != 0 {
+				t.Errorf("pins = %v after the copy", m.pins)
+			}
+		})
+	}
+}
+
+func TestCopyFileMetadata(t *testing.T) {
+	created := time.Now().Add(-time.Hour)
+	m := newTestManager(t, nil,
+		testFile{id: "a", chunks: []string{"a1"}, chunkSize: 10, attributes: map[string]string{"shared": "new", "source": "1"}, contentType: "text/plain"},
+		testFile{id: "b", chunks: []string{"b1"}, chunkSize: 10, attributes: map[string]string{"shared": "old", "kept": "1"}, modifiedAt: created},
+	)
+	m.files["a"].legalHold = true
+
+	info, err := m.CopyFile(context.Background(), &pb.CopyFileRequest{SourceId: "a", DestinationId: "b"})
+	if err != nil {
+		t.Fatalf("CopyFile() error = %v", err)
+	}
+	if want := map[string]string{"shared": "new", "source": "1", "kept": "1"}; !reflect.DeepEqual(info.Attributes, want) {
+		t.Errorf("attributes = %v, want %v", info.Attributes, want)
+	}
+	metadata := m.files["b"]
+	if !metadata.createdAt.Equal(created) {
+		t.Errorf("created at %s, want the creation time of the replaced file %s", metadata.createdAt, created)
+	}
+	if metadata.legalHold || metadata.contentType != "text/plain" || metadata.size != 10 {
+		t.Errorf("copy has legal hold %v, content type %q and size %d, want no hold, text/plain and 10",
+			metadata.legalHold, metadata.contentType, metadata.size)
+	}
+}
+
+func TestCopyFileConvertsChunks(t *testing.T) {
+	tests := []struct {
+		name         string
+		deleteSource bool // The source is deleted while its chunks are converted
+		fail         bool // Data Nodes fail to convert the chunks
+		wantCode     codes.Code
+		wantRefs     int // References to each chunk afterwards, 0 if it is dropped
+	}{
+		{name: "converted", wantRefs: 2},
+		{name: "source deleted meanwhile", deleteSource: true, wantRefs: 1},
+		{name: "Data Nodes unavailable", fail: true, wantCode: codes.Unavailable, wantRefs: 1},
+		{name: "source deleted and Data Nodes unavailable", deleteSource: true, fail: true, wantCode: codes.Unavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, testFile{id: "a", chunks: []string{"c1", "c2"}, chunkSize: 10})
+			m.DataShards, m.ParityShards = 1, 1
+			var deleteOnce sync.Once
+			for _, nodeID := range []string{"n1", "n2"} {
+				node := startDataNode(t, m, nodeID)
+				node.fail = tt.fail
+				if tt.deleteSource {
+					node.onCommand = func() {
+						deleteOnce.Do(func() {
+							m.mu.Lock()
+							m.deleteFile("a")
+							m.mu.Unlock()
+						})
+					}
+				}
+			}
+
+			_, err := m.CopyFile(context.Background(), &pb.CopyFileRequest{SourceId: "a", DestinationId: "b", StorageClass: erasure.ClassErasure})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("CopyFile() error = %v, want code %v", err, tt.wantCode)
+			}
+			if len(m.pins) != 0 {
+				t.Errorf("pins = %v after the copy", m.pins)
+			}
+
+			for _, hash := range []string{"c1", "c2"} {
+				chunk, exists := m.chunks[hash]
+				if exists != (tt.wantRefs > 0) {
+					t.Fatalf("chunk %s recorded = %v, want %v", hash, exists, tt.wantRefs > 0)
+				}
+				if !exists {
+					continue
+				}
+				if chunk.refs != tt.wantRefs {
+					t.Errorf("refs of %s = %d, want %d", hash, chunk.refs, tt.wantRefs)
+				}
+				if converted := chunk.stripe != nil; converted != (err == nil) {
+					t.Errorf("chunk %s erasure coded = %v, want %v", hash, converted, err == nil)
+				}
+				if err == nil && (len(chunk.stripe.shards) != 2 || len(chunk.nodes) != 2) {
+					t.Errorf("chunk %s has %d shards and %d replicas, want 2 of each", hash, len(chunk.stripe.shards), len(chunk.nodes))
+				}
+			}
+			if _, exists := m.files["b"]; exists != (err == nil) {
+				t.Errorf("copy exists = %v, want %v", exists, err == nil)
+			}
+			if err == nil && m.files["b"].storageClass != erasure.ClassErasure {
+				t.Errorf("storage class of the copy = %s, want %s", m.files["b"].storageClass, erasure.ClassErasure)
+			}
+		})
+	}
+}
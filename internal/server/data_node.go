@@ -2,52 +2,79 @@ package server
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/compression"
+	"breezeFS/internal/security"
 	"google.golang.org/grpc"
 )
 
 type DataNode struct {
-	ManagerAddress string
-	NodeAddress    string
-}
+	ManagerAddress      string
+	NodeAddress         string                // Address to listen on, port 0 picks a free port
+	AdvertiseHost       string                // Host registered with the Manager Node, defaults to the listen host
+	DataDir             string                // Directory where chunks and the node ID are stored
+	NodeID              string                // Persistent identity, loaded from the data directory on start
+	RequestTimeout      time.Duration         // Timeout for requests to the Manager Node
+	ReportInterval      time.Duration         // How often incremental block reports are sent
+	FullReportInterval  time.Duration         // How often the full chunk inventory is reported
+	ServerTLS           *tls.Config           // TLS configuration for the HTTP server, nil for plain HTTP
+	ClientTLS           *tls.Config           // TLS configuration for connecting to the Manager Node and other Data Nodes
+	Tokens              *security.TokenSigner // Verifies chunk access tokens issued by the Manager Node, nil to disable
+	Keys                *security.Keyring     // Encrypts chunk files at rest, nil to store them as received
+	KeyRotationInterval time.Duration         // How often the key file is reloaded and chunks re-encrypted
 
-var fileTypeMap = struct {
-	sync.RWMutex
-	data map[string]string
-}{data: make(map[string]string)}
+	inventory  *chunkInventory // Chunk changes since the last block report
+	httpClient *http.Client    // Client for fetching chunks from other Data Nodes
+	chunkMu    sync.RWMutex    // Held exclusively while a chunk file is re-encrypted
+}
 
 // NewDataNode creates a new instance of DataNode with specified addresses
 func NewDataNode(managerAddress, nodeAddress string) *DataNode {
 	return &DataNode{
-		ManagerAddress: managerAddress,
-		NodeAddress:    nodeAddress,
+		ManagerAddress:      managerAddress,
+		NodeAddress:         nodeAddress,
+		DataDir:             "data",
+		RequestTimeout:      5 * time.Second,
+		ReportInterval:      30 * time.Second,
+		FullReportInterval:  time.Hour,
+		KeyRotationInterval: time.Hour,
+		inventory:           newChunkInventory(),
 	}
 }
 
 // RegisterWithManager registers the Data Node with the Manager Node via gRPC
 func (dn *DataNode) RegisterWithManager(nodeAddress string) error {
-	conn, err := grpc.Dial(dn.ManagerAddress, grpc.WithInsecure())
+	conn, err := grpc.Dial(dn.ManagerAddress, security.DialOption(dn.ClientTLS))
 	if err != nil {
 		return fmt.Errorf("failed to connect to Manager Node: %v", err)
 	}
 	defer conn.Close()
 
 	client := pb.NewManagerServiceClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), dn.RequestTimeout)
 	defer cancel()
 
 	req := &pb.RegisterNodeRequest{
 		NodeAddress: nodeAddress,
+		NodeId:      dn.NodeID,
 	}
 
 	_, err = client.RegisterNode(ctx, req)
@@ -55,30 +82,95 @@ func (dn *DataNode) RegisterWithManager(nodeAddress string) error {
 		return fmt.Errorf("failed to register node: %v", err)
 	}
 
-	log.Printf("Data Node registered with Manager at %s", dn.ManagerAddress)
+	log.Printf("Data Node %s registered with Manager at %s", dn.NodeID, dn.ManagerAddress)
 	return nil
 }
 
+// loadNodeID reads the node ID from the data directory, creating a new one on first start
+func (dn *DataNode) loadNodeID() (string, error) {
+	idPath := filepath.Join(dn.DataDir, "node_id")
+
+	data, err := os.ReadFile(idPath)
+	if err == nil {
+		return strings.TrimSpace(string(data)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read node ID: %v", err)
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("failed to generate node ID: %v", err)
+	}
+	nodeID := hex.EncodeToString(id)
+
+	if err := os.WriteFile(idPath, []byte(nodeID+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to save node ID: %v", err)
+	}
+	return nodeID, nil
+}
+
 // StartHTTPServer starts the HTTP server to handle chunk upload and download
 func (dn *DataNode) StartHTTPServer() {
-	// Listen on any available port
-	listener, err := net.Listen("tcp", fmt.Sprintf("%s:0", dn.NodeAddress))
+	if err := os.MkdirAll(dn.DataDir, 0755); err != nil {
+		log.Fatalf("Failed to create data directory: %v", err)
+	}
+
+	// Load the persistent node ID so restarts keep the same identity
+	nodeID, err := dn.loadNodeID()
+	if err != nil {
+		log.Fatalf("Failed to load node ID: %v", err)
+	}
+	dn.NodeID = nodeID
+
+	// Listen on any available port unless one is given
+	listenAddress := dn.NodeAddress
+	if _, _, err := net.SplitHostPort(listenAddress); err != nil {
+		listenAddress = net.JoinHostPort(listenAddress, "0")
+	}
+	listener, err := net.Listen("tcp", listenAddress)
 	if err != nil {
 		log.Fatalf("Failed to start listener: %v", err)
 	}
 
 	// Get the actual address including the dynamically assigned port
 	nodeAddress := listener.Addr().String()
-	log.Printf("Data Node is running on %s", nodeAddress)
+	if dn.AdvertiseHost != "" {
+		_, port, _ := net.SplitHostPort(nodeAddress)
+		nodeAddress = net.JoinHostPort(dn.AdvertiseHost, port)
+	}
+	log.Printf("Data Node %s is running on %s", dn.NodeID, nodeAddress)
 
 	// Register the Data Node with the Manager Node
 	if err := dn.RegisterWithManager(nodeAddress); err != nil {
 		log.Fatalf("Failed to register with Manager Node: %v", err)
 	}
 
-	// Handle HTTP requests
-	http.HandleFunc("/upload", dn.uploadChunkHandler)
-	http.HandleFunc("/download", dn.downloadChunkHandler)
+	// Report the chunks already on disk, then keep the Manager Node up to date
+	if err := dn.sendBlockReport(true); err != nil {
+		log.Printf("Failed to send initial block report: %v", err)
+	}
+	dn.StartBlockReports(dn.ReportInterval, dn.FullReportInterval)
+
+	// Bring chunks sealed with retired keys, or not at all, up to the current node key
+	dn.StartKeyRotation(dn.KeyRotationInterval)
+
+	dn.httpClient = security.HTTPClient(dn.ClientTLS)
+
+	// Handle HTTP requests, cluster-internal ones are restricted to nodes when TLS is enabled
+	http.HandleFunc("/upload", dn.requireToken(security.OpUpload, dn.uploadChunkHandler))
+	http.HandleFunc("/download", dn.requireToken(security.OpDownload, dn.downloadChunkHandler))
+	http.HandleFunc("/replicate", dn.requireNodeCert(dn.requireToken(security.OpReplicate, dn.replicateChunkHandler)))
+	http.HandleFunc("/reconstruct", dn.requireNodeCert(dn.requireToken(security.OpReplicate, dn.reconstructShardHandler)))
+	http.HandleFunc("/encode", dn.requireNodeCert(dn.requireToken(security.OpReplicate, dn.encodeShardHandler)))
+	http.HandleFunc("/decode", dn.requireNodeCert(dn.requireToken(security.OpReplicate, dn.decodeChunkHandler)))
+	http.HandleFunc("/checksum", dn.requireNodeCert(dn.requireToken(security.OpChecksum, dn.checksumChunkHandler)))
+	http.HandleFunc("/delete", dn.requireNodeCert(dn.requireToken(security.OpDelete, dn.deleteChunkHandler)))
+	http.HandleFunc("/delete-batch", dn.requireNodeCert(dn.deleteBatchHandler))
+
+	if dn.ServerTLS != nil {
+		listener = tls.NewListener(listener, dn.ServerTLS)
+	}
 	if err := http.Serve(listener, nil); err != nil {
 		log.Fatalf("Failed to start HTTP server: %v", err)
 	}
@@ -86,85 +178,332 @@ func (dn *DataNode) StartHTTPServer() {
 }
 
 func (dn *DataNode) uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
-	// Get file and chunk IDs from query parameters
+	// Chunks are stored under the SHA-256 of their data, the file and chunk IDs scope the token
+	hash := r.URL.Query().Get("hash")
 	fileID := r.URL.Query().Get("file_id")
-	chunkID := r.URL.Query().Get("chunk_id")
 
-	if fileID == "" || chunkID == "" {
-		http.Error(w, "Missing file_id or chunk_id", http.StatusBadRequest)
+	if !validHash(hash) || fileID == "" {
+		http.Error(w, "Missing or invalid hash or file_id", http.StatusBadRequest)
 		return
 	}
 
-	// Extract the file type from headers
-	fileType := r.Header.Get("File-Type")
-
-	// Store file type in the map
-	fileTypeMap.Lock()
-	if fileType != "" {
-		fileTypeMap.data[fileID] = fileType
+	// Clients name the codec of chunks the node can decompress, so the receipt confirms their uncompressed size
+	codec := r.URL.Query().Get("codec")
+	if codec != "" && !compression.Supported(codec) {
+		http.Error(w, "Unsupported codec", http.StatusBadRequest)
+		return
 	}
-	fileTypeMap.Unlock()
 
 	// Create a file path to store the chunk
-	filePath := fmt.Sprintf("data/%s_%s.chunk", fileID, chunkID)
-
-	fmt.Println("filePath: " + filePath)
+	filePath := dn.chunkPath(hash)
 
-	// Create or open the file for writing
-	out, err := os.Create(filePath)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create file: %v", err), http.StatusInternalServerError)
+	// The pipeline lists every node of the upload in order, hop is the position of this one
+	pipeline := pipelineNodes(r.URL.Query().Get("pipeline"))
+	hop, err := strconv.Atoi(r.URL.Query().Get("hop"))
+	if len(pipeline) > 0 && (err != nil || hop < 0 || hop >= len(pipeline)) {
+		http.Error(w, "Invalid pipeline hop", http.StatusBadRequest)
 		return
 	}
-	defer out.Close()
 
-	// Write the uploaded data to the file
-	_, err = io.Copy(out, r.Body)
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to save chunk: %v", err), http.StatusInternalServerError)
+	// Pass the chunk on to the rest of the pipeline while storing it, so the client sends it only once
+	var body io.Reader = r.Body
+	var forwarded chan error
+	var forward *io.PipeWriter
+	if hop+1 < len(pipeline) {
+		var reader *io.PipeReader
+		reader, forward = io.Pipe()
+		body = io.TeeReader(r.Body, forward)
+		forwarded = make(chan error, 1)
+		go func() {
+			forwarded <- dn.forwardChunk(r, reader, pipeline[hop+1], hop+1)
+		}()
+	}
+
+	// Write the uploaded data to the file, counting it for the receipt
+	counted := &countingReader{r: body}
+	_, err = dn.writeChunkFile(filePath, counted, hash)
+	var forwardErr error
+	if forward != nil {
+		forward.CloseWithError(err)
+		forwardErr = <-forwarded
+	}
+	if err == nil {
+		dn.inventory.recordAdded(hash)
+	}
+
+	switch {
+	case errors.Is(err, errHashMismatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case forwardErr != nil:
+		// A failure further down also stops the local copy, so it is the one to report
+		http.Error(w, forwardErr.Error(), http.StatusBadGateway)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// Only acknowledge once every node down the pipeline has stored the chunk too. The receipt
+	// lets the Manager Node trust the sizes the client declares for the chunk.
+	if dn.Tokens != nil {
+		size := counted.n
+		if codec != "" && codec != compression.None {
+			if size, err = dn.decompressedSize(hash, codec); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		w.Header().Set(security.ReceiptHeader, dn.Tokens.SignReceipt(hash, counted.n, size))
+	}
 	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, "Chunk %s of file %s stored successfully", chunkID, fileID)
+	fmt.Fprintf(w, "Chunk %s stored successfully", hash)
+}
+
+// forwardChunk streams a chunk to the next Data Node of an upload pipeline, which passes
+// it on to the nodes after it. It returns once the next node acknowledged the chunk.
+func (dn *DataNode) forwardChunk(r *http.Request, body *io.PipeReader, next string, hop int) error {
+	query := url.Values{}
+	for _, name := range []string{"hash", "file_id", "chunk_id", "token", "codec", "pipeline"} {
+		query.Set(name, r.URL.Query().Get(name))
+	}
+	query.Set("hop", strconv.Itoa(hop))
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost,
+		fmt.Sprintf("%s://%s/upload?%s", security.Scheme(dn.ClientTLS), next, query.Encode()), body)
+	if err != nil {
+		body.CloseWithError(err)
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := dn.httpClient.Do(req)
+	if err != nil {
+		body.CloseWithError(err)
+		return fmt.Errorf("failed to forward chunk to %s: %v", next, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		// Stop the upstream copy, the chunk can't be stored everywhere anyway
+		message, _ := io.ReadAll(resp.Body)
+		err := fmt.Errorf("error response from %s: %s", next, strings.TrimSpace(string(message)))
+		body.CloseWithError(err)
+		return err
+	}
+	return nil
 }
 
 // downloadChunkHandler handles downloading of chunks from the Data Node
 func (dn *DataNode) downloadChunkHandler(w http.ResponseWriter, r *http.Request) {
-	// Get file and chunk IDs from query parameters
-	fileID := r.URL.Query().Get("file_id")
-	chunkID := r.URL.Query().Get("chunk_id")
+	hash := r.URL.Query().Get("hash")
 
-	if fileID == "" || chunkID == "" {
-		http.Error(w, "Missing file_id or chunk_id", http.StatusBadRequest)
+	if !validHash(hash) {
+		http.Error(w, "Missing or invalid hash", http.StatusBadRequest)
 		return
 	}
 
-	// Construct the file path based on file and chunk IDs
-	filePath := filepath.Join("data", fmt.Sprintf("%s_%s.chunk", fileID, chunkID))
-
 	// Open the chunk file
-	file, err := os.Open(filePath)
+	file, err := dn.openChunkFile(hash)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to open chunk file: %v", err), http.StatusNotFound)
 		return
 	}
 	defer file.Close()
 
-	// Set the headers to indicate a file download
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s_%s.chunk\"", fileID, chunkID))
+	// Set the headers to indicate a file download, with the content type of the file being read if given
+	contentType := normalizeContentType(r.URL.Query().Get("content_type"))
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.chunk\"", hash))
+
+	// Compressed chunks are sent as stored to clients accepting the codec and decompressed for everyone else
+	var body io.Reader = file
+	if codec := r.URL.Query().Get("encoding"); codec != "" && codec != compression.None {
+		if compression.Accepts(r.Header.Get("Accept-Encoding"), codec) {
+			w.Header().Set("Content-Encoding", codec)
+		} else {
+			reader, err := compression.NewReader(codec, file)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer reader.Close()
+			body = reader
+		}
+	}
 
 	// Copy the file data to the response
-	_, err = io.Copy(w, file)
+	_, err = io.Copy(w, body)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to send chunk data: %v", err), http.StatusInternalServerError)
 		return
 	}
 }
 
-func getFileType(fileID string) string {
-	fileTypeMap.RLock()
-	defer fileTypeMap.RUnlock()
-	return fileTypeMap.data[fileID]
+// replicateChunkHandler pulls a chunk from another Data Node and stores it locally.
+// The SHA-256 checksum of the stored data is returned so the caller can verify the copy.
+func (dn *DataNode) replicateChunkHandler(w http.ResponseWriter, r *http.Request) {
+	hash := r.URL.Query().Get("hash")
+	source := r.URL.Query().Get("source")
+
+	if !validHash(hash) || source == "" {
+		http.Error(w, "Missing or invalid hash or source", http.StatusBadRequest)
+		return
+	}
+
+	// Optional bandwidth limit in bytes per second
+	var rate int64
+	if rateStr := r.URL.Query().Get("rate"); rateStr != "" {
+		var err error
+		rate, err = strconv.ParseInt(rateStr, 10, 64)
+		if err != nil || rate < 0 {
+			http.Error(w, "Invalid rate", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Fetch the chunk from the source node
+	url := fmt.Sprintf("%s://%s/download?hash=%s&token=%s", security.Scheme(dn.ClientTLS), source, hash,
+		r.URL.Query().Get("source_token"))
+	resp, err := dn.httpClient.Get(url)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch chunk from %s: %v", source, err), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		http.Error(w, fmt.Sprintf("Error response from %s: %s", source, resp.Status), http.StatusBadGateway)
+		return
+	}
+
+	// A failed or corrupted transfer never leaves a partial chunk behind
+	sum, err := dn.writeChunkFile(dn.chunkPath(hash), newRateLimitedReader(resp.Body, rate), hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dn.inventory.recordAdded(hash)
+
+	log.Printf("Chunk %s replicated from %s", hash, source)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, hex.EncodeToString(sum))
+}
+
+// checksumChunkHandler returns the SHA-256 checksum of a stored chunk's plaintext
+func (dn *DataNode) checksumChunkHandler(w http.ResponseWriter, r *http.Request) {
+	hash := r.URL.Query().Get("hash")
+
+	if !validHash(hash) {
+		http.Error(w, "Missing or invalid hash", http.StatusBadRequest)
+		return
+	}
+
+	file, err := dn.openChunkFile(hash)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open chunk file: %v", err), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, file); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read chunk: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, hex.EncodeToString(sum.Sum(nil)))
+}
+
+// deleteChunkHandler removes a stored chunk from the Data Node
+func (dn *DataNode) deleteChunkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hash := r.URL.Query().Get("hash")
+
+	if !validHash(hash) {
+		http.Error(w, "Missing or invalid hash", http.StatusBadRequest)
+		return
+	}
+
+	if err := dn.removeChunkFile(dn.chunkPath(hash)); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to delete chunk: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	dn.inventory.recordRemoved(hash)
+
+	log.Printf("Chunk %s deleted", hash)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "Chunk %s deleted successfully", hash)
+}
+
+// requireNodeCert only lets through requests from peers with a certificate signed by the cluster CA.
+// Without TLS every request is let through.
+func (dn *DataNode) requireNodeCert(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if dn.ServerTLS != nil && !security.IsNodeCertificate(r.TLS) {
+			http.Error(w, "A valid node certificate is required", http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// requireToken only lets through requests carrying a valid token for op on the requested chunk.
+// Upload tokens are scoped to a chunk of a file, since the hash isn't known when they are issued,
+// and to the nodes of its pipeline,
+// and download tokens to the chunk of the file the client is reading.
+// Without a token signer every request is let through.
+func (dn *DataNode) requireToken(op string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if dn.Tokens != nil {
+			query := r.URL.Query()
+			resource := query.Get("hash")
+			switch op {
+			case security.OpUpload:
+				resource = security.UploadResource(query.Get("file_id"), query.Get("chunk_id"), pipelineNodes(query.Get("pipeline")))
+			case security.OpDownload:
+				resource = security.DownloadResource(query.Get("file_id"), resource)
+			}
+			if err := dn.Tokens.Verify(query.Get("token"), op, resource); err != nil {
+				http.Error(w, fmt.Sprintf("Access denied: %v", err), http.StatusForbidden)
+				return
+			}
+		}
+		handler(w, r)
+	}
+}
+
+// pipelineNodes splits the node addresses of an upload pipeline
+func pipelineNodes(pipeline string) []string {
+	if pipeline == "" {
+		return nil
+	}
+	return strings.Split(pipeline, ",")
+}
+
+// chunkPath returns the on-disk location of a chunk
+func (dn *DataNode) chunkPath(hash string) string {
+	return filepath.Join(dn.DataDir, hash+".chunk")
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
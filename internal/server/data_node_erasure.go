@@ -0,0 +1,100 @@
+package server
+
+import (
+	"breezeFS/internal/erasure"
+	"breezeFS/internal/security"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+)
+
+// reconstructShardHandler rebuilds a lost shard of an erasure coded chunk from the other
+// shards of its stripe and stores it locally
+func (dn *DataNode) reconstructShardHandler(w http.ResponseWriter, r *http.Request) {
+	hash := r.URL.Query().Get("hash")
+
+	var req reconstructRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	total := req.DataShards + req.ParityShards
+	if req.DataShards <= 0 || req.ParityShards <= 0 || len(req.Shards) != total ||
+		req.Index < 0 || req.Index >= total || req.Shards[req.Index].Hash != hash || !validHash(hash) {
+		http.Error(w, "Invalid shard layout", http.StatusBadRequest)
+		return
+	}
+
+	// Fetch surviving shards until there are enough to rebuild the stripe
+	shards := make([][]byte, total)
+	fetched := 0
+	for i, shard := range req.Shards {
+		if fetched == req.DataShards {
+			break
+		}
+		if i == req.Index || shard.Source == "" {
+			continue
+		}
+		data, err := dn.fetchShard(shard)
+		if err != nil {
+			log.Printf("Failed to fetch shard %s from %s: %v", shard.Hash, shard.Source, err)
+			continue
+		}
+		shards[i] = data
+		fetched++
+	}
+	if fetched < req.DataShards {
+		http.Error(w, fmt.Sprintf("Only %d of the %d shards needed are available", fetched, req.DataShards), http.StatusBadGateway)
+		return
+	}
+
+	if err := erasure.Reconstruct(shards, req.DataShards, req.ParityShards); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum, err := dn.writeChunkFile(dn.chunkPath(hash), bytes.NewReader(shards[req.Index]), hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dn.inventory.recordAdded(hash)
+
+	log.Printf("Shard %s rebuilt from %d other shards", hash, fetched)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, hex.EncodeToString(sum))
+}
+
+// fetchShard downloads a shard from another Data Node and checks it against its hash
+func (dn *DataNode) fetchShard(shard shardSource) ([]byte, error) {
+	if !validHash(shard.Hash) {
+		return nil, fmt.Errorf("invalid hash")
+	}
+
+	url := fmt.Sprintf("%s://%s/download?hash=%s&token=%s", security.Scheme(dn.ClientTLS), shard.Source, shard.Hash, shard.Token)
+	resp, err := dn.httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error response: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != shard.Hash {
+		return nil, errHashMismatch
+	}
+	return data, nil
+}
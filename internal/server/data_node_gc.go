@@ -0,0 +1,48 @@
+package server
+
+import (
+	"breezeFS/internal/security"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// deleteBatchHandler deletes the garbage chunks the Manager Node sends in one request.
+// Every chunk carries its own delete token, chunks with an invalid token are skipped.
+func (dn *DataNode) deleteBatchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch deleteBatch
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	result := deleteBatchResult{Deleted: []string{}, Failed: make(map[string]string)}
+	for _, chunk := range batch.Chunks {
+		if !validHash(chunk.Hash) {
+			result.Failed[chunk.Hash] = "invalid hash"
+			continue
+		}
+		if dn.Tokens != nil {
+			if err := dn.Tokens.Verify(chunk.Token, security.OpDelete, chunk.Hash); err != nil {
+				result.Failed[chunk.Hash] = fmt.Sprintf("access denied: %v", err)
+				continue
+			}
+		}
+		if err := dn.removeChunkFile(dn.chunkPath(chunk.Hash)); err != nil {
+			result.Failed[chunk.Hash] = err.Error()
+			continue
+		}
+		dn.inventory.recordRemoved(chunk.Hash)
+		result.Deleted = append(result.Deleted, chunk.Hash)
+	}
+
+	log.Printf("Deleted %d garbage chunks, %d failed", len(result.Deleted), len(result.Failed))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
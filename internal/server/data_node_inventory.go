@@ -0,0 +1,163 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/security"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// chunkInventory tracks chunks stored or removed since the last block report, by hash
+type chunkInventory struct {
+	mu      sync.Mutex
+	added   map[string]bool
+	removed map[string]bool
+}
+
+func newChunkInventory() *chunkInventory {
+	return &chunkInventory{
+		added:   make(map[string]bool),
+		removed: make(map[string]bool),
+	}
+}
+
+// recordAdded notes that a chunk was stored on the node
+func (inv *chunkInventory) recordAdded(hash string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	delete(inv.removed, hash)
+	inv.added[hash] = true
+}
+
+// recordRemoved notes that a chunk was deleted from the node
+func (inv *chunkInventory) recordRemoved(hash string) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	delete(inv.added, hash)
+	inv.removed[hash] = true
+}
+
+// take returns the pending changes and resets them
+func (inv *chunkInventory) take() (map[string]bool, map[string]bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	added, removed := inv.added, inv.removed
+	inv.added = make(map[string]bool)
+	inv.removed = make(map[string]bool)
+	return added, removed
+}
+
+// restore puts back changes that failed to be reported, newer changes take precedence
+func (inv *chunkInventory) restore(added, removed map[string]bool) {
+	inv.mu.Lock()
+	defer inv.mu.Unlock()
+	for chunk := range added {
+		if !inv.removed[chunk] {
+			inv.added[chunk] = true
+		}
+	}
+	for chunk := range removed {
+		if !inv.added[chunk] {
+			inv.removed[chunk] = true
+		}
+	}
+}
+
+// StartBlockReports periodically reports stored chunks to the Manager Node.
+// Incremental reports are sent every interval and a full report every fullInterval.
+func (dn *DataNode) StartBlockReports(interval, fullInterval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		lastFull := time.Now()
+		for range ticker.C {
+			full := time.Since(lastFull) >= fullInterval
+			if err := dn.sendBlockReport(full); err != nil {
+				log.Printf("Failed to send block report: %v", err)
+				continue
+			}
+			if full {
+				lastFull = time.Now()
+			}
+		}
+	}()
+}
+
+// sendBlockReport reports stored chunks to the Manager Node
+func (dn *DataNode) sendBlockReport(full bool) error {
+	added, removed := dn.inventory.take()
+
+	req := &pb.BlockReportRequest{
+		NodeId: dn.NodeID,
+		Full:   full,
+	}
+
+	if full {
+		chunks, err := dn.scanChunks()
+		if err != nil {
+			dn.inventory.restore(added, removed)
+			return fmt.Errorf("failed to scan chunks: %v", err)
+		}
+		for _, hash := range chunks {
+			req.Added = append(req.Added, &pb.StoredChunk{Hash: hash})
+		}
+	} else {
+		if len(added) == 0 && len(removed) == 0 {
+			return nil
+		}
+		for hash := range added {
+			req.Added = append(req.Added, &pb.StoredChunk{Hash: hash})
+		}
+		for hash := range removed {
+			req.Removed = append(req.Removed, &pb.StoredChunk{Hash: hash})
+		}
+	}
+
+	conn, err := grpc.Dial(dn.ManagerAddress, security.DialOption(dn.ClientTLS))
+	if err != nil {
+		dn.inventory.restore(added, removed)
+		return fmt.Errorf("failed to connect to Manager Node: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewManagerServiceClient(conn)
+	ctx, cancel := context.WithTimeout(context.Background(), dn.RequestTimeout)
+	defer cancel()
+
+	if _, err := client.BlockReport(ctx, req); err != nil {
+		dn.inventory.restore(added, removed)
+		return fmt.Errorf("failed to send block report: %v", err)
+	}
+	return nil
+}
+
+// scanChunks lists the hashes of every chunk stored in the data directory
+func (dn *DataNode) scanChunks() ([]string, error) {
+	entries, err := os.ReadDir(dn.DataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var chunks []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".chunk") {
+			continue
+		}
+
+		// Chunks stored before content addressing are named after their file and ignored
+		hash := strings.TrimSuffix(name, ".chunk")
+		if validHash(hash) {
+			chunks = append(chunks, hash)
+		}
+	}
+	return chunks, nil
+}
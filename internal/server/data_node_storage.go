@@ -0,0 +1,259 @@
+package server
+
+import (
+	"breezeFS/internal/chunking"
+	"breezeFS/internal/compression"
+	"breezeFS/internal/security"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writeChunkFile stores a chunk read from r, sealing it with the node key when encryption at
+// rest is enabled. The data goes to a temporary file first so readers never see a partial chunk.
+// The SHA-256 checksum of the plaintext is returned, and the chunk is rejected unless it
+// matches the expected hash.
+func (dn *DataNode) writeChunkFile(path string, r io.Reader, expected string) ([]byte, error) {
+	tmpPath, sum, err := dn.stageChunkFile(path, r, expected)
+	if err != nil {
+		return nil, err
+	}
+
+	dn.chunkMu.RLock()
+	err = os.Rename(tmpPath, path)
+	dn.chunkMu.RUnlock()
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, fmt.Errorf("failed to save chunk: %v", err)
+	}
+	return sum, nil
+}
+
+// stageChunkFile writes a chunk to a temporary file next to path and syncs it, returning the
+// temporary file for the caller to rename into place and the checksum of the plaintext
+func (dn *DataNode) stageChunkFile(path string, r io.Reader, expected string) (string, []byte, error) {
+	out, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create file: %v", err)
+	}
+	tmpPath := out.Name()
+
+	// CreateTemp only grants the owner access, chunk files are readable like any other
+	err = out.Chmod(0644)
+
+	hash := sha256.New()
+	if err == nil && dn.Keys == nil {
+		_, err = io.Copy(io.MultiWriter(out, hash), r)
+	} else if err == nil {
+		var plaintext, sealed []byte
+		plaintext, err = io.ReadAll(io.TeeReader(r, hash))
+		if err == nil {
+			sealed, err = dn.Keys.Seal(plaintext)
+		}
+		if err == nil {
+			_, err = out.Write(sealed)
+		}
+	}
+	// Acknowledged chunks must survive a crash
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return "", nil, fmt.Errorf("failed to save chunk: %v", err)
+	}
+	sum := hash.Sum(nil)
+	if hex.EncodeToString(sum) != expected {
+		os.Remove(tmpPath)
+		return "", nil, errHashMismatch
+	}
+	return tmpPath, sum, nil
+}
+
+// errHashMismatch is returned when a chunk's data doesn't match the hash it is stored under
+var errHashMismatch = errors.New("chunk data does not match its hash")
+
+// openChunkFile opens a stored chunk for reading its plaintext
+func (dn *DataNode) openChunkFile(hash string) (io.ReadCloser, error) {
+	file, err := os.Open(dn.chunkPath(hash))
+	if err != nil || dn.Keys == nil {
+		return file, err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := dn.unsealChunk(hash, data)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(plaintext)), nil
+}
+
+// decompressedSize returns how many bytes a stored chunk decompresses to with codec. Chunks
+// decompressing to more than the largest chunk a client may cut are rejected.
+func (dn *DataNode) decompressedSize(hash, codec string) (int64, error) {
+	file, err := dn.openChunkFile(hash)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read chunk: %v", err)
+	}
+	defer file.Close()
+
+	reader, err := compression.NewReader(codec, file)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	n, err := io.Copy(io.Discard, io.LimitReader(reader, chunking.MaxChunkSize+1))
+	if err != nil {
+		return 0, fmt.Errorf("failed to decompress chunk: %v", err)
+	}
+	if n > chunking.MaxChunkSize {
+		return 0, fmt.Errorf("chunk decompresses to more than %d bytes", chunking.MaxChunkSize)
+	}
+	return n, nil
+}
+
+// unsealChunk decrypts the contents of a chunk file. Chunks stored before encryption was enabled
+// are plaintext, which is only accepted if it matches the chunk hash, so a damaged header is
+// reported rather than ciphertext being served as data.
+func (dn *DataNode) unsealChunk(hash string, data []byte) ([]byte, error) {
+	plaintext, err := dn.Keys.Open(data)
+	if errors.Is(err, security.ErrNotSealed) {
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != hash {
+			return nil, fmt.Errorf("chunk %s is neither sealed nor plaintext", hash)
+		}
+		return data, nil
+	}
+	return plaintext, err
+}
+
+// removeChunkFile deletes a stored chunk, ignoring chunks that are already gone
+func (dn *DataNode) removeChunkFile(path string) error {
+	dn.chunkMu.RLock()
+	defer dn.chunkMu.RUnlock()
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// StartKeyRotation periodically reloads the key file and re-encrypts chunks that aren't
+// sealed with the current key, including chunks stored before encryption was enabled
+func (dn *DataNode) StartKeyRotation(interval time.Duration) {
+	if dn.Keys == nil {
+		return
+	}
+
+	go func() {
+		for {
+			if err := dn.Keys.Reload(); err != nil {
+				log.Printf("Failed to reload node keys: %v", err)
+			} else {
+				dn.rotateChunks()
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// rotateChunks re-encrypts every chunk that isn't sealed with the current key
+func (dn *DataNode) rotateChunks() {
+	chunks, err := dn.scanChunks()
+	if err != nil {
+		log.Printf("Failed to scan chunks for key rotation: %v", err)
+		return
+	}
+
+	rotated := 0
+	for _, hash := range chunks {
+		changed, err := dn.rotateChunk(hash)
+		if err != nil {
+			log.Printf("Failed to re-encrypt chunk %s: %v", hash, err)
+			continue
+		}
+		if changed {
+			rotated++
+		}
+	}
+	if rotated > 0 {
+		log.Printf("Re-encrypted %d chunks with the current node key", rotated)
+	}
+}
+
+// rotateChunk seals one chunk with the current key. Uploads, replications and deletes of the
+// chunk wait while it is rewritten, so a deleted chunk is never brought back by its old contents.
+func (dn *DataNode) rotateChunk(hash string) (bool, error) {
+	// Most chunks are sealed with the current key already, their header is enough to tell
+	path := dn.chunkPath(hash)
+	header, err := readChunkHeader(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !dn.Keys.NeedsRotation(header) {
+		return false, nil
+	}
+
+	dn.chunkMu.Lock()
+	defer dn.chunkMu.Unlock()
+
+	// The chunk may have been deleted or rewritten since
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !dn.Keys.NeedsRotation(data) {
+		return false, nil
+	}
+
+	plaintext, err := dn.unsealChunk(hash, data)
+	if err != nil {
+		return false, err
+	}
+	tmpPath, _, err := dn.stageChunkFile(path, bytes.NewReader(plaintext), hash)
+	if err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// readChunkHeader reads the start of a chunk file, enough to tell which key it is sealed with
+func readChunkHeader(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := make([]byte, security.SealedHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return header[:n], nil
+	}
+	return header, err
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"breezeFS/internal/chunking"
+	"breezeFS/internal/compression"
+	"breezeFS/internal/security"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateChunk(t *testing.T) {
+	dn := NewDataNode("", "")
+	dn.DataDir = t.TempDir()
+	keyPath := filepath.Join(t.TempDir(), "node.keys")
+	addNodeKey(t, keyPath)
+	keys, err := security.LoadKeyring(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dn.Keys = keys
+
+	plaintext := []byte("chunk contents")
+	sum := sha256.Sum256(plaintext)
+	hash := hex.EncodeToString(sum[:])
+	if err := os.WriteFile(dn.chunkPath(hash), plaintext, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		hash    string
+		newKey  bool // Add a key before rotating
+		busy    bool // Another request holds the chunk lock
+		changed bool
+	}{
+		{name: "plaintext chunk", hash: hash, changed: true},
+		{name: "sealed with the current key", hash: hash, busy: true},
+		{name: "sealed with an old key", hash: hash, newKey: true, changed: true},
+		{name: "deleted chunk", hash: "missing"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.newKey {
+				addNodeKey(t, keyPath)
+				if err := keys.Reload(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			// Chunks that don't need rewriting are checked without waiting for the lock
+			if tt.busy {
+				dn.chunkMu.Lock()
+			}
+			changed, err := dn.rotateChunk(tt.hash)
+			if tt.busy {
+				dn.chunkMu.Unlock()
+			}
+			if err != nil {
+				t.Fatalf("rotateChunk() error = %v", err)
+			}
+			if changed != tt.changed {
+				t.Errorf("rotateChunk() = %v, want %v", changed, tt.changed)
+			}
+			if tt.hash == "missing" {
+				return
+			}
+
+			data, err := os.ReadFile(dn.chunkPath(tt.hash))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if keys.NeedsRotation(data) {
+				t.Error("chunk is not sealed with the current key")
+			}
+			if opened, err := keys.Open(data); err != nil || !bytes.Equal(opened, plaintext) {
+				t.Errorf("Open() = %q, %v, want %q", opened, err, plaintext)
+			}
+		})
+	}
+}
+
+func TestDecompressedSize(t *testing.T) {
+	dn := NewDataNode("", "")
+	dn.DataDir = t.TempDir()
+	text := bytes.Repeat([]byte("compressible chunk contents "), 100)
+	zstdChunk, _, err := compression.Compress(compression.Zstd, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzipChunk, _, err := compression.Compress(compression.Gzip, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bomb, _, err := compression.Compress(compression.Zstd, make([]byte, chunking.MaxChunkSize+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		stored  []byte
+		codec   string
+		want    int64
+		wantErr bool
+	}{
+		{name: "zstd", stored: zstdChunk, codec: compression.Zstd, want: int64(len(text))},
+		{name: "gzip", stored: gzipChunk, codec: compression.Gzip, want: int64(len(text))},
+		{name: "not compressed with the codec", stored: text, codec: compression.Gzip, wantErr: true},
+		{name: "larger than any chunk", stored: bomb, codec: compression.Zstd, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := sha256.Sum256(tt.stored)
+			hash := hex.EncodeToString(sum[:])
+			if err := os.WriteFile(dn.chunkPath(hash), tt.stored, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := dn.decompressedSize(hash, tt.codec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decompressedSize() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("decompressedSize() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+// addNodeKey appends a new current key to a node key file
+func addNodeKey(t *testing.T, path string) {
+	t.Helper()
+	key := make([]byte, security.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		t.Fatal(err)
+	}
+}
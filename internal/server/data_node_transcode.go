@@ -0,0 +1,113 @@
+package server
+
+import (
+	"breezeFS/internal/erasure"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// encodeShardHandler erasure codes a chunk fetched from another Data Node and stores one of its
+// shards locally, so a copy in the erasure coded class needs no client. Responds with the shard hash.
+func (dn *DataNode) encodeShardHandler(w http.ResponseWriter, r *http.Request) {
+	hash := r.URL.Query().Get("hash")
+
+	var req encodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	if req.DataShards <= 0 || req.ParityShards <= 0 || req.Index < 0 || req.Index >= req.DataShards+req.ParityShards ||
+		req.Source == "" || !validHash(hash) {
+		http.Error(w, "Invalid shard layout", http.StatusBadRequest)
+		return
+	}
+
+	data, err := dn.fetchShard(shardSource{Hash: hash, Source: req.Source, Token: req.SourceToken})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to fetch chunk from %s: %v", req.Source, err), http.StatusBadGateway)
+		return
+	}
+
+	// Encoding is deterministic, every node computes the same stripe and keeps its own shard
+	shards, err := erasure.Encode(data, req.DataShards, req.ParityShards)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	shard := shards[req.Index]
+	sum := sha256.Sum256(shard)
+	shardHash := hex.EncodeToString(sum[:])
+	if _, err := dn.writeChunkFile(dn.chunkPath(shardHash), bytes.NewReader(shard), shardHash); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dn.inventory.recordAdded(shardHash)
+
+	log.Printf("Shard %d of chunk %s stored as %s", req.Index, hash, shardHash)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, shardHash)
+}
+
+// decodeChunkHandler rebuilds an erasure coded chunk from shards fetched from other Data Nodes
+// and stores it whole, so a copy in the replicated class needs no client. Responds with the checksum.
+func (dn *DataNode) decodeChunkHandler(w http.ResponseWriter, r *http.Request) {
+	hash := r.URL.Query().Get("hash")
+
+	var req decodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %v", err), http.StatusBadRequest)
+		return
+	}
+	total := req.DataShards + req.ParityShards
+	if req.DataShards <= 0 || req.ParityShards <= 0 || len(req.Shards) != total || req.Size < 0 || !validHash(hash) {
+		http.Error(w, "Invalid shard layout", http.StatusBadRequest)
+		return
+	}
+
+	// Fetch shards until there are enough to decode the chunk
+	shards := make([][]byte, total)
+	fetched := 0
+	for i, shard := range req.Shards {
+		if fetched == req.DataShards {
+			break
+		}
+		if shard.Source == "" {
+			continue
+		}
+		data, err := dn.fetchShard(shard)
+		if err != nil {
+			log.Printf("Failed to fetch shard %s from %s: %v", shard.Hash, shard.Source, err)
+			continue
+		}
+		shards[i] = data
+		fetched++
+	}
+	if fetched < req.DataShards {
+		http.Error(w, fmt.Sprintf("Only %d of the %d shards needed are available", fetched, req.DataShards), http.StatusBadGateway)
+		return
+	}
+
+	data, err := erasure.Decode(shards, req.DataShards, req.ParityShards, req.Size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sum, err := dn.writeChunkFile(dn.chunkPath(hash), bytes.NewReader(data), hash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dn.inventory.recordAdded(hash)
+
+	log.Printf("Chunk %s decoded from %d shards", hash, fetched)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, hex.EncodeToString(sum))
+}
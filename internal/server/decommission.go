@@ -0,0 +1,177 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"fmt"
+	"log"
+)
+
+// Decommission states reported to clients
+const (
+	decommissionDraining       = "draining"
+	decommissionDecommissioned = "decommissioned"
+	decommissionFailed         = "failed"
+)
+
+// decommission tracks the progress of draining a Data Node
+type decommission struct {
+	state   string
+	total   int
+	drained int
+	failed  int
+}
+
+// DecommissionNode stops new placements on a node and drains its chunks to other nodes in the background.
+// Once every chunk has been moved the node is removed from the cluster and can be shut down safely.
+func (m *ManagerNode) DecommissionNode(ctx context.Context, req *pb.DecommissionNodeRequest) (*pb.DecommissionNodeResponse, error) {
+	if err := m.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nodeID, exists := m.resolveNode(req.NodeId)
+	if !exists {
+		return nil, fmt.Errorf("node %s is not registered", req.NodeId)
+	}
+
+	// Already retired or draining, just report progress
+	status, exists := m.decommissions[nodeID]
+	if _, registered := m.nodes[nodeID]; !registered && exists {
+		return status.toResponse(nodeID), nil
+	}
+	if exists && status.state == decommissionDraining {
+		return status.toResponse(nodeID), nil
+	}
+
+	if len(m.activeNodes()) < 2 {
+		return nil, fmt.Errorf("no other nodes available to take over chunks from %s", nodeID)
+	}
+
+	status = &decommission{state: decommissionDraining}
+	m.decommissions[nodeID] = status
+
+	go m.drainNode(nodeID, status, req.BandwidthLimit)
+
+	log.Printf("Decommissioning node %s at %s", nodeID, m.nodes[nodeID])
+	return status.toResponse(nodeID), nil
+}
+
+// GetDecommissionStatus reports the progress of a node being decommissioned
+func (m *ManagerNode) GetDecommissionStatus(ctx context.Context, req *pb.GetDecommissionStatusRequest) (*pb.DecommissionNodeResponse, error) {
+	if err := m.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nodeID, _ := m.resolveNode(req.NodeId)
+	status, exists := m.decommissions[nodeID]
+	if !exists {
+		return nil, fmt.Errorf("node %s is not being decommissioned", req.NodeId)
+	}
+	return status.toResponse(nodeID), nil
+}
+
+// drainNode copies every chunk replica held by the node to another active node
+func (m *ManagerNode) drainNode(nodeID string, status *decommission, bandwidthLimit int64) {
+	m.mu.Lock()
+	moves := m.planDrain(nodeID)
+	status.total = len(moves)
+	m.mu.Unlock()
+
+	for _, move := range moves {
+		if move.target == "" {
+			// Every other node already holds this chunk, dropping the replica is enough.
+			// A shard has no other copy though, it needs a node without a shard of its stripe.
+			m.mu.Lock()
+			chunk, exists := m.chunks[move.hash]
+			isShard := exists && len(chunk.shardOf) > 0
+			if isShard {
+				status.failed++
+			} else {
+				m.removeReplica(move.hash, move.source)
+				status.drained++
+			}
+			m.mu.Unlock()
+
+			if isShard {
+				log.Printf("Failed to drain shard %s from %s: every other node holds a shard of its stripe", move.hash, nodeID)
+			}
+			continue
+		}
+
+		// A chunk changed during the copy no longer needs draining, so it counts as drained too.
+		// If the node is still listed, the target already held a replica and this one can go.
+		updated, err := m.transferReplica(move, bandwidthLimit)
+
+		m.mu.Lock()
+		if err != nil {
+			status.failed++
+		} else {
+			if !updated {
+				m.removeReplica(move.hash, nodeID)
+			}
+			status.drained++
+		}
+		m.mu.Unlock()
+
+		if err != nil {
+			log.Printf("Failed to drain chunk %s from %s: %v", move.hash, nodeID, err)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if status.failed > 0 {
+		status.state = decommissionFailed
+		log.Printf("Decommissioning node %s failed, %d chunks could not be moved", nodeID, status.failed)
+		return
+	}
+
+	status.state = decommissionDecommissioned
+	log.Printf("Node %s at %s decommissioned, it can now be shut down", nodeID, m.nodes[nodeID])
+	delete(m.nodes, nodeID)
+}
+
+// planDrain picks a new home for every chunk replica on the node, spreading them
+// over the least used active nodes. The caller must hold m.mu.
+func (m *ManagerNode) planDrain(nodeID string) []chunkMove {
+	usage := m.nodeUtilisation()
+
+	var moves []chunkMove
+	for _, hash := range m.sortedChunks() {
+		if !containsNode(m.chunks[hash].nodes, nodeID) {
+			continue
+		}
+
+		target := ""
+		for candidate, count := range usage {
+			if m.placementConflict(hash, candidate) {
+				continue
+			}
+			if target == "" || count < usage[target] || (count == usage[target] && candidate < target) {
+				target = candidate
+			}
+		}
+		if target != "" {
+			usage[target]++
+		}
+		moves = append(moves, chunkMove{hash: hash, source: nodeID, target: target})
+	}
+	return moves
+}
+
+func (d *decommission) toResponse(nodeID string) *pb.DecommissionNodeResponse {
+	return &pb.DecommissionNodeResponse{
+		State:         d.state,
+		TotalChunks:   int32(d.total),
+		DrainedChunks: int32(d.drained),
+		FailedChunks:  int32(d.failed),
+		Message:       fmt.Sprintf("Node %s is %s: %d of %d chunks drained", nodeID, d.state, d.drained, d.total),
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var drainedFiles = []testFile{
+	{id: "a", chunks: []string{"h1"}, chunkSize: 10},
+	{id: "b", chunks: []string{"h2"}, chunkSize: 10},
+}
+
+func TestPlanDrain(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []string // Nodes registered besides n1 and n2
+		want  []chunkMove
+	}{
+		{
+			name:  "spare node",
+			nodes: []string{"n3"},
+			want:  []chunkMove{{hash: "h1", source: "n1", target: "n3"}, {hash: "h2", source: "n1", target: "n3"}},
+		},
+		{
+			name: "every node holds the chunks",
+			want: []chunkMove{{hash: "h1", source: "n1"}, {hash: "h2", source: "n1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, drainedFiles...)
+			for _, nodeID := range tt.nodes {
+				m.nodes[nodeID] = "127.0.0.1:3"
+			}
+			m.decommissions["n1"] = &decommission{state: decommissionDraining}
+
+			if got := m.planDrain("n1"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planDrain() = %+v, want %+v", got, tt.want)
+			}
+			if nodes := m.activeNodes(); containsNode(nodes, "n1") {
+				t.Errorf("activeNodes() = %v, includes the draining node", nodes)
+			}
+		})
+	}
+}
+
+func TestDrainNode(t *testing.T) {
+	tests := []struct {
+		name        string
+		spare       bool // Register a third node to take over the chunks
+		fail        bool // The third node fails every command
+		wantState   string
+		wantDrained int
+		wantFailed  int
+		wantNodes   []string // Replicas of h1 afterwards
+	}{
+		{name: "moved", spare: true, wantState: decommissionDecommissioned, wantDrained: 2, wantNodes: []string{"n3", "n2"}},
+		{name: "replica dropped", wantState: decommissionDecommissioned, wantDrained: 2, wantNodes: []string{"n2"}},
+		{name: "target unavailable", spare: true, fail: true, wantState: decommissionFailed, wantFailed: 2, wantNodes: []string{"n1", "n2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, drainedFiles...)
+			startDataNode(t, m, "n1")
+			startDataNode(t, m, "n2")
+			if tt.spare {
+				startDataNode(t, m, "n3").fail = tt.fail
+			}
+
+			drain := &decommission{state: decommissionDraining}
+			m.decommissions["n1"] = drain
+			m.drainNode("n1", drain, 0)
+
+			if drain.state != tt.wantState || drain.drained != tt.wantDrained || drain.failed != tt.wantFailed {
+				t.Errorf("drainNode() state %s, drained %d, failed %d, want %s, %d, %d",
+					drain.state, drain.drained, drain.failed, tt.wantState, tt.wantDrained, tt.wantFailed)
+			}
+			if got := m.chunks["h1"].nodes; !reflect.DeepEqual(got, tt.wantNodes) {
+				t.Errorf("nodes of h1 = %v, want %v", got, tt.wantNodes)
+			}
+			if _, registered := m.nodes["n1"]; registered != (tt.wantState == decommissionFailed) {
+				t.Errorf("n1 registered = %v after the node was %s", registered, drain.state)
+			}
+		})
+	}
+}
+
+func TestDecommissionNode(t *testing.T) {
+	m := newTestManager(t, map[string][]string{"root": nil, "alice": nil})
+	m.acls[rootDirectory] = &accessControl{owner: "root", entries: make(map[string]int)}
+
+	if _, err := m.DecommissionNode(asUser("alice"), &pb.DecommissionNodeRequest{NodeId: "n1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("DecommissionNode() by a user error = %v, want PermissionDenied", err)
+	}
+	if _, err := m.GetDecommissionStatus(asUser("alice"), &pb.GetDecommissionStatusRequest{NodeId: "n1"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("GetDecommissionStatus() by a user error = %v, want PermissionDenied", err)
+	}
+	if _, err := m.DecommissionNode(asUser("root"), &pb.DecommissionNodeRequest{NodeId: "n3"}); err == nil {
+		t.Error("DecommissionNode() of an unknown node succeeded")
+	}
+
+	delete(m.nodes, "n2")
+	if _, err := m.DecommissionNode(asUser("root"), &pb.DecommissionNodeRequest{NodeId: "n1"}); err == nil {
+		t.Error("DecommissionNode() of the last node succeeded")
+	}
+}
@@ -0,0 +1,253 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/security"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// defaultDataShards and defaultParityShards split erasure coded chunks into 4 data and
+// 2 parity shards, surviving the loss of any two nodes at 1.5 times the chunk size
+const (
+	defaultDataShards   = 4
+	defaultParityShards = 2
+)
+
+// stripe is the layout of an erasure coded chunk. Each shard is a chunk of its own,
+// stored on a single node that holds no other shard of the stripe.
+type stripe struct {
+	dataShards   int
+	parityShards int
+	shards       []string // Shard hashes, data shards first
+}
+
+// shardRepair rebuilds one lost shard of a stripe on a new node
+type shardRepair struct {
+	hash   string // Erasure coded chunk
+	index  int    // Shard to rebuild
+	target string // Node ID receiving the shard
+}
+
+// reconstructRequest asks a Data Node to rebuild a shard from the other shards of its stripe
+type reconstructRequest struct {
+	DataShards   int           `json:"data_shards"`
+	ParityShards int           `json:"parity_shards"`
+	Index        int           `json:"index"`
+	Shards       []shardSource `json:"shards"`
+}
+
+// shardSource tells a Data Node where to fetch a shard, shards without a source are lost
+type shardSource struct {
+	Hash   string `json:"hash"`
+	Source string `json:"source,omitempty"`
+	Token  string `json:"token,omitempty"`
+}
+
+// addShard records that a node holds a shard of an erasure coded chunk. The caller must hold m.mu.
+func (m *ManagerNode) addShard(hash, parent, nodeID string, now time.Time) {
+	record, exists := m.chunks[hash]
+	if !exists {
+		record = &chunkRecord{nodes: []string{nodeID}, storedAt: now, shardOf: make(map[string]bool)}
+		m.chunks[hash] = record
+	}
+	if record.shardOf == nil {
+		record.shardOf = make(map[string]bool)
+	}
+	record.refs++
+	record.shardOf[parent] = true
+}
+
+// deleteOrphanShards deletes the shards an upload sent for chunks whose stripe was already
+// stored, rather than leaving them to the garbage collector. Shards the nodes hold anyway, or that
+// another upload may be sending, are kept. The caller must hold m.mu.
+func (m *ManagerNode) deleteOrphanShards(orphans []*pb.ChunkMetadata, placement map[int32][]string, now time.Time) {
+	for _, chunk := range orphans {
+		for i, shard := range chunk.Shards {
+			nodeID := placement[chunk.ChunkId][i]
+			if record, exists := m.chunks[shard]; exists && containsNode(record.nodes, nodeID) {
+				continue
+			}
+			if m.uploading(nodeID, shard, now) {
+				continue
+			}
+
+			go func(nodeAddress, shard string) {
+				if err := m.deleteChunk(nodeAddress, shard); err != nil {
+					log.Printf("Failed to delete orphaned shard %s from %s: %v", shard, nodeAddress, err)
+				}
+			}(m.nodes[nodeID], shard)
+		}
+	}
+}
+
+// placementConflict reports whether a node already holds a chunk or, for a shard, another
+// shard of the same stripe. Shards sharing a node would be lost together. The caller must hold m.mu.
+func (m *ManagerNode) placementConflict(hash, nodeID string) bool {
+	chunk := m.chunks[hash]
+	if containsNode(chunk.nodes, nodeID) {
+		return true
+	}
+	for parent := range chunk.shardOf {
+		if record, exists := m.chunks[parent]; exists && m.stripeUses(record.stripe, nodeID) {
+			return true
+		}
+	}
+	return false
+}
+
+// stripeUses reports whether any shard of a stripe is stored on a node. The caller must hold m.mu.
+func (m *ManagerNode) stripeUses(s *stripe, nodeID string) bool {
+	for _, shard := range s.shards {
+		if record, exists := m.chunks[shard]; exists && containsNode(record.nodes, nodeID) {
+			return true
+		}
+	}
+	return false
+}
+
+// StartRepair periodically rebuilds lost shards of erasure coded chunks in the background
+func (m *ManagerNode) StartRepair(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if repaired := m.repairStripes(); repaired > 0 {
+				log.Printf("Rebuilt %d lost shards", repaired)
+			}
+		}
+	}()
+}
+
+// repairStripes rebuilds every lost shard that can still be reconstructed and returns how many were rebuilt
+func (m *ManagerNode) repairStripes() int {
+	m.mu.Lock()
+	repairs := m.planRepairs()
+	m.mu.Unlock()
+
+	repaired := 0
+	for _, repair := range repairs {
+		if err := m.repairShard(repair); err != nil {
+			log.Printf("Failed to rebuild shard %d of chunk %s: %v", repair.index, repair.hash, err)
+			continue
+		}
+		repaired++
+	}
+	return repaired
+}
+
+// planRepairs finds lost shards and picks a node for each that holds no other shard of the
+// stripe, preferring the least used. The caller must hold m.mu.
+func (m *ManagerNode) planRepairs() []shardRepair {
+	usage := m.nodeUtilisation()
+
+	var repairs []shardRepair
+	for _, hash := range m.sortedChunks() {
+		s := m.chunks[hash].stripe
+		if s == nil {
+			continue
+		}
+
+		var lost []int
+		for i, shard := range s.shards {
+			if record, exists := m.chunks[shard]; !exists || len(record.nodes) == 0 {
+				lost = append(lost, i)
+			}
+		}
+		if len(lost) == 0 {
+			continue
+		}
+		if len(s.shards)-len(lost) < s.dataShards {
+			log.Printf("Chunk %s has lost %d of %d shards and can't be rebuilt", hash, len(lost), len(s.shards))
+			continue
+		}
+
+		// Shards rebuilt in this pass count as placed, so they don't end up on the same node
+		planned := make(map[string]bool)
+		for _, index := range lost {
+			target := ""
+			for candidate, count := range usage {
+				if planned[candidate] || m.stripeUses(s, candidate) {
+					continue
+				}
+				if target == "" || count < usage[target] || (count == usage[target] && candidate < target) {
+					target = candidate
+				}
+			}
+			if target == "" {
+				log.Printf("No node left to rebuild shard %d of chunk %s on", index, hash)
+				break
+			}
+			usage[target]++
+			planned[target] = true
+			repairs = append(repairs, shardRepair{hash: hash, index: index, target: target})
+		}
+	}
+	return repairs
+}
+
+// repairShard has the target node rebuild a lost shard from the surviving ones, then records it there
+func (m *ManagerNode) repairShard(repair shardRepair) error {
+	m.mu.Lock()
+	chunk, exists := m.chunks[repair.hash]
+	if !exists {
+		m.mu.Unlock()
+		return nil
+	}
+	s := chunk.stripe
+	shardHash := s.shards[repair.index]
+	req := reconstructRequest{
+		DataShards:   s.dataShards,
+		ParityShards: s.parityShards,
+		Index:        repair.index,
+	}
+	for _, shard := range s.shards {
+		source := shardSource{Hash: shard}
+		if record, exists := m.chunks[shard]; exists && len(record.nodes) > 0 {
+			source.Source = m.nodes[record.nodes[0]]
+			source.Token = m.signToken(security.OpDownload, shard)
+		}
+		req.Shards = append(req.Shards, source)
+	}
+	targetAddress := m.nodes[repair.target]
+	key := replicaKey(repair.target, shardHash)
+	m.transfers[key] = true
+	m.mu.Unlock()
+
+	// Rebuild without holding the lock, it fetches several shards
+	err := m.reconstructShard(targetAddress, shardHash, req)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.transfers, key)
+	if err != nil {
+		return err
+	}
+
+	record, exists := m.chunks[shardHash]
+	if !exists || len(record.nodes) > 0 {
+		// The chunk was deleted or the shard turned up meanwhile, the rebuilt shard is an orphan now
+		return nil
+	}
+	record.nodes = append(append([]string(nil), record.nodes...), repair.target)
+	log.Printf("Rebuilt shard %d of chunk %s on %s", repair.index, repair.hash, repair.target)
+	return nil
+}
+
+// reconstructShard asks a Data Node to rebuild a shard from the other shards of its stripe
+func (m *ManagerNode) reconstructShard(nodeAddress, hash string, req reconstructRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	url := fmt.Sprintf("%s://%s/reconstruct?hash=%s&token=%s", security.Scheme(m.DataNodeTLS), nodeAddress, hash,
+		m.signToken(security.OpReplicate, hash))
+	_, err = m.callDataNode(http.MethodPost, url, bytes.NewReader(body))
+	return err
+}
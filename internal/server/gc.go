@@ -0,0 +1,334 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/security"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// gcBatchSize is the most chunks deleted from a Data Node with a single request
+const gcBatchSize = 500
+
+// pendingUploadExpiry is how long an upload may stay incomplete before it counts as
+// abandoned and stops protecting the chunks sent so far
+const pendingUploadExpiry = 24 * time.Hour
+
+// deleteBatch asks a Data Node to delete several chunks, each with its own delete token
+type deleteBatch struct {
+	Chunks []deleteTarget `json:"chunks"`
+}
+
+// deleteTarget is a chunk in a deleteBatch
+type deleteTarget struct {
+	Hash  string `json:"hash"`
+	Token string `json:"token,omitempty"`
+}
+
+// deleteBatchResult lists the chunks of a deleteBatch that were deleted and those that failed, with the reason
+type deleteBatchResult struct {
+	Deleted []string          `json:"deleted"`
+	Failed  map[string]string `json:"failed,omitempty"`
+}
+
+// CollectGarbage runs a garbage collection, or with dry_run only reports what it would delete.
+// Deleting needs admin permission on the root directory, a dry run read permission.
+func (m *ManagerNode) CollectGarbage(ctx context.Context, req *pb.CollectGarbageRequest) (*pb.CollectGarbageResponse, error) {
+	perm := permAdmin
+	if req.DryRun {
+		perm = permRead
+	}
+	m.mu.Lock()
+	err := m.checkAccess(ctx, rootDirectory, perm)
+	m.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return m.collectGarbage(req.DryRun)
+}
+
+// StartGarbageCollector periodically deletes replicas nothing refers to in the background
+func (m *ManagerNode) StartGarbageCollector(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			report, err := m.collectGarbage(false)
+			if err != nil {
+				log.Printf("Garbage collection failed: %v", err)
+				continue
+			}
+			if report.GarbageReplicas > 0 || report.DroppedRecords > 0 {
+				log.Println(report.Message)
+			}
+		}
+	}()
+}
+
+// collectGarbage marks every chunk that files, previous versions and snapshots refer to, then
+// sweeps the replicas Data Nodes reported that aren't marked. Only one collection runs at a time.
+func (m *ManagerNode) collectGarbage(dryRun bool) (*pb.CollectGarbageResponse, error) {
+	if !m.gcMu.TryLock() {
+		return nil, fmt.Errorf("garbage collection already in progress")
+	}
+	defer m.gcMu.Unlock()
+
+	m.mu.Lock()
+	now := time.Now()
+	report := &pb.CollectGarbageResponse{}
+	if !dryRun {
+		report.ExpiredUploads = int32(m.expireUploads(now))
+	}
+	live := m.markLive()
+	report.LiveChunks = int32(len(live))
+	garbage, protected := m.planSweep(live, now)
+	report.ProtectedReplicas = int32(protected)
+	if !dryRun {
+		report.DroppedRecords = int32(m.dropUnreferenced(live))
+	}
+	addresses := make(map[string]string, len(garbage))
+	for nodeID := range garbage {
+		addresses[nodeID] = m.nodes[nodeID]
+	}
+	m.mu.Unlock()
+
+	nodeIDs := make([]string, 0, len(garbage))
+	for nodeID := range garbage {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+
+	for _, nodeID := range nodeIDs {
+		hashes := garbage[nodeID]
+		sort.Strings(hashes)
+		nodeReport := &pb.NodeGarbage{NodeId: nodeID, Hashes: hashes}
+		report.Nodes = append(report.Nodes, nodeReport)
+		report.GarbageReplicas += int32(len(hashes))
+		if dryRun {
+			continue
+		}
+
+		// Delete without holding the lock, each batch is checked again just before it is sent
+		for start := 0; start < len(hashes); start += gcBatchSize {
+			end := start + gcBatchSize
+			if end > len(hashes) {
+				end = len(hashes)
+			}
+			deleted, failed := m.sweepBatch(nodeID, addresses[nodeID], hashes[start:end], live)
+			nodeReport.Deleted += int32(deleted)
+			nodeReport.Failed += int32(failed)
+		}
+		report.DeletedReplicas += nodeReport.Deleted
+		report.FailedReplicas += nodeReport.Failed
+	}
+
+	if dryRun {
+		report.Message = fmt.Sprintf("Dry run: %d live chunks, %d garbage replicas would be deleted, %d protected",
+			report.LiveChunks, report.GarbageReplicas, report.ProtectedReplicas)
+	} else {
+		report.Message = fmt.Sprintf("Garbage collection: %d live chunks, deleted %d of %d garbage replicas, %d protected, %d records dropped, %d uploads expired",
+			report.LiveChunks, report.DeletedReplicas, report.GarbageReplicas, report.ProtectedReplicas, report.DroppedRecords, report.ExpiredUploads)
+	}
+	return report, nil
+}
+
+// markLive returns every chunk and shard referred to by the current or a previous version of
+// a file, by a snapshot or by a copy in progress. The caller must hold m.mu.
+func (m *ManagerNode) markLive() map[string]bool {
+	live := make(map[string]bool)
+	markChunk := func(hash string) {
+		live[hash] = true
+		if chunk, exists := m.chunks[hash]; exists && chunk.stripe != nil {
+			for _, shard := range chunk.stripe.shards {
+				live[shard] = true
+			}
+		}
+	}
+	mark := func(chunks map[int32]string) {
+		for _, hash := range chunks {
+			markChunk(hash)
+		}
+	}
+
+	for _, chunks := range m.chunkMapping {
+		mark(chunks)
+	}
+	for _, versions := range m.versions {
+		for _, version := range versions {
+			mark(version.chunks)
+		}
+	}
+	for _, s := range m.snapshots {
+		for _, version := range s.files {
+			mark(version.chunks)
+		}
+	}
+	for hash := range m.pins {
+		markChunk(hash)
+	}
+	return live
+}
+
+// planSweep returns the reported replicas that are garbage, by node, and how many
+// unreferenced replicas are protected for now. The caller must hold m.mu.
+func (m *ManagerNode) planSweep(live map[string]bool, now time.Time) (map[string][]string, int) {
+	garbage := make(map[string][]string)
+	protected := 0
+	for nodeID, replicas := range m.reported {
+		if _, registered := m.nodes[nodeID]; !registered {
+			continue
+		}
+		for hash, firstSeen := range replicas {
+			switch m.replicaState(nodeID, hash, firstSeen, live, now) {
+			case replicaGarbage:
+				garbage[nodeID] = append(garbage[nodeID], hash)
+			case replicaProtected:
+				protected++
+			}
+		}
+	}
+	return garbage, protected
+}
+
+// States of a reported replica during garbage collection
+const (
+	replicaInUse     = iota // Recorded as a replica of a live chunk, or about to be
+	replicaProtected        // Unreferenced, but recent or possibly part of an upload in progress
+	replicaGarbage          // Unreferenced for longer than the grace period
+)
+
+// replicaState decides whether a replica a node reported is garbage. The caller must hold m.mu.
+func (m *ManagerNode) replicaState(nodeID, hash string, firstSeen time.Time, live map[string]bool, now time.Time) int {
+	if live[hash] {
+		chunk := m.chunks[hash]
+		// Spare copies of under-replicated chunks are adopted with the next block report
+		if chunk != nil && (containsNode(chunk.nodes, nodeID) || len(chunk.nodes) < m.wantedReplicas(chunk)) {
+			return replicaInUse
+		}
+	}
+	if m.transfers[replicaKey(nodeID, hash)] {
+		return replicaInUse
+	}
+	if now.Sub(firstSeen) < m.GCGracePeriod || m.uploading(nodeID, hash, firstSeen) {
+		return replicaProtected
+	}
+	return replicaGarbage
+}
+
+// uploading reports whether a replica may belong to an upload in progress: one about to send
+// the chunk, or one placing chunks on the node that started before the replica appeared.
+// The caller must hold m.mu.
+func (m *ManagerNode) uploading(nodeID, hash string, firstSeen time.Time) bool {
+	for _, upload := range m.uploads {
+		if upload.hashes[hash] {
+			return true
+		}
+		if firstSeen.Before(upload.startedAt) {
+			continue
+		}
+		for _, nodes := range upload.placement {
+			if containsNode(nodes, nodeID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// expireUploads forgets uploads that were never completed, so the chunks they sent become
+// garbage, and returns how many expired. The caller must hold m.mu.
+func (m *ManagerNode) expireUploads(now time.Time) int {
+	expired := 0
+	for fileID, upload := range m.uploads {
+		if now.Sub(upload.startedAt) >= pendingUploadExpiry {
+			m.endUpload(fileID)
+			log.Printf("Upload of file %s was abandoned, started %s", fileID, upload.startedAt.Format(time.RFC3339))
+			expired++
+		}
+	}
+	return expired
+}
+
+// dropUnreferenced removes chunk records that nothing refers to any more, which can only be
+// left behind by a bug in reference counting, and returns how many were dropped.
+// The caller must hold m.mu.
+func (m *ManagerNode) dropUnreferenced(live map[string]bool) int {
+	dropped := 0
+	for hash := range m.chunks {
+		if !live[hash] {
+			log.Printf("Dropping record of chunk %s, nothing refers to it", hash)
+			delete(m.chunks, hash)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// sweepBatch deletes garbage replicas from a node after checking again that they are still
+// garbage, and returns how many were deleted and how many failed
+func (m *ManagerNode) sweepBatch(nodeID, nodeAddress string, hashes []string, live map[string]bool) (int, int) {
+	m.mu.Lock()
+	now := time.Now()
+	batch := deleteBatch{}
+	for _, hash := range hashes {
+		firstSeen, reported := m.reported[nodeID][hash]
+		if !reported || m.replicaState(nodeID, hash, firstSeen, live, now) != replicaGarbage {
+			continue
+		}
+		// A chunk recorded since marking is live now
+		if _, exists := m.chunks[hash]; exists && !live[hash] {
+			continue
+		}
+		batch.Chunks = append(batch.Chunks, deleteTarget{Hash: hash, Token: m.signToken(security.OpDelete, hash)})
+	}
+	m.mu.Unlock()
+
+	if len(batch.Chunks) == 0 {
+		return 0, 0
+	}
+
+	result, err := m.deleteChunks(nodeAddress, batch)
+	if err != nil {
+		log.Printf("Failed to delete %d garbage chunks from %s: %v", len(batch.Chunks), nodeID, err)
+		return 0, len(batch.Chunks)
+	}
+	for hash, reason := range result.Failed {
+		log.Printf("Failed to delete garbage chunk %s from %s: %s", hash, nodeID, reason)
+	}
+
+	m.mu.Lock()
+	for _, hash := range result.Deleted {
+		delete(m.reported[nodeID], hash)
+	}
+	m.mu.Unlock()
+
+	log.Printf("Deleted %d garbage chunks from %s", len(result.Deleted), nodeID)
+	return len(result.Deleted), len(batch.Chunks) - len(result.Deleted)
+}
+
+// deleteChunks removes a batch of chunks from a Data Node
+func (m *ManagerNode) deleteChunks(nodeAddress string, batch deleteBatch) (*deleteBatchResult, error) {
+	body, err := json.Marshal(batch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode request: %v", err)
+	}
+
+	url := fmt.Sprintf("%s://%s/delete-batch", security.Scheme(m.DataNodeTLS), nodeAddress)
+	resp, err := m.callDataNode(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	var result deleteBatchResult
+	if err := json.Unmarshal([]byte(resp), &result); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return &result, nil
+}
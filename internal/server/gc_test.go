@@ -0,0 +1,192 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMarkLive(t *testing.T) {
+	m := newTestManager(t, nil)
+	storeFile(t, m, testFile{id: "a", chunks: []string{"current"}, chunkSize: 10})
+	storeFile(t, m, testFile{id: "b", chunks: []string{"old"}, chunkSize: 10})
+	storeFile(t, m, testFile{id: "b", chunks: []string{"new"}, chunkSize: 10})
+	storeFile(t, m, testFile{id: "c", chunks: []string{"snapshotted"}, chunkSize: 10})
+	m.snapshots["s"] = &snapshot{path: rootDirectory, files: map[string]*fileVersion{"c": m.currentVersion("c")}}
+	m.chunks["snapshotted"].refs++
+	m.deleteFile("c")
+
+	// An erasure coded chunk is live together with its shards
+	storeFile(t, m, testFile{id: "d", chunks: []string{"striped"}, chunkSize: 10})
+	m.chunks["striped"].stripe = &stripe{dataShards: 1, parityShards: 1, shards: []string{"shard0", "shard1"}}
+	m.pins["pinned"] = 1
+	m.chunks["dead"] = &chunkRecord{nodes: []string{"n1"}}
+
+	want := map[string]bool{
+		"current": true, "old": true, "new": true, "snapshotted": true,
+		"striped": true, "shard0": true, "shard1": true, "pinned": true,
+	}
+	if got := m.markLive(); !reflect.DeepEqual(got, want) {
+		t.Errorf("markLive = %v, want %v", got, want)
+	}
+}
+
+func TestReplicaState(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-2 * uploadGracePeriod)
+
+	tests := []struct {
+		name      string
+		nodeID    string
+		hash      string
+		firstSeen time.Time
+		setup     func(*ManagerNode)
+		want      int
+	}{
+		{name: "recorded replica", nodeID: "n1", hash: "live", firstSeen: old, want: replicaInUse},
+		{name: "unreferenced and old", nodeID: "n1", hash: "junk", firstSeen: old, want: replicaGarbage},
+		{name: "unreferenced but recent", nodeID: "n1", hash: "junk", firstSeen: now, want: replicaProtected},
+		{
+			name: "spare copy of an under-replicated chunk", nodeID: "n2", hash: "live", firstSeen: old,
+			setup: func(m *ManagerNode) { m.chunks["live"].nodes = []string{"n1"} },
+			want:  replicaInUse,
+		},
+		{
+			name: "extra copy of a fully replicated chunk", nodeID: "n3", hash: "live", firstSeen: old,
+			setup: func(m *ManagerNode) { m.nodes["n3"] = "127.0.0.1:3" },
+			want:  replicaGarbage,
+		},
+		{
+			name: "copy in flight", nodeID: "n2", hash: "junk", firstSeen: old,
+			setup: func(m *ManagerNode) { m.transfers[replicaKey("n2", "junk")] = true },
+			want:  replicaInUse,
+		},
+		{
+			name: "chunk an upload asked about", nodeID: "n1", hash: "junk", firstSeen: old,
+			setup: func(m *ManagerNode) {
+				m.uploads["up"] = &pendingUpload{hashes: map[string]bool{"junk": true}, startedAt: now.Add(-3 * uploadGracePeriod)}
+			},
+			want: replicaProtected,
+		},
+		{
+			name: "node an upload places chunks on", nodeID: "n1", hash: "junk", firstSeen: old,
+			setup: func(m *ManagerNode) {
+				m.uploads["up"] = &pendingUpload{hashes: map[string]bool{}, placement: map[int32][]string{0: {"n1"}}, startedAt: old.Add(-time.Minute)}
+			},
+			want: replicaProtected,
+		},
+		{
+			name: "upload started after the replica appeared", nodeID: "n1", hash: "junk", firstSeen: old,
+			setup: func(m *ManagerNode) {
+				m.uploads["up"] = &pendingUpload{hashes: map[string]bool{}, placement: map[int32][]string{0: {"n1"}}, startedAt: now}
+			},
+			want: replicaGarbage,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, testFile{id: "a", chunks: []string{"live"}, chunkSize: 10})
+			if tt.setup != nil {
+				tt.setup(m)
+			}
+			if got := m.replicaState(tt.nodeID, tt.hash, tt.firstSeen, m.markLive(), now); got != tt.want {
+				t.Errorf("replicaState = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollectGarbageDryRun(t *testing.T) {
+	m := newTestManager(t, nil, testFile{id: "a", chunks: []string{"live"}, chunkSize: 10})
+	old := time.Now().Add(-2 * uploadGracePeriod)
+	m.reported["n1"] = map[string]time.Time{"live": old, "junk": old, "fresh": time.Now()}
+	m.reported["n2"] = map[string]time.Time{"live": old, "junk2": old}
+	m.reported["gone"] = map[string]time.Time{"junk3": old} // No longer registered
+
+	report, err := m.collectGarbage(true)
+	if err != nil {
+		t.Fatalf("collectGarbage: %v", err)
+	}
+	if report.LiveChunks != 1 || report.GarbageReplicas != 2 || report.ProtectedReplicas != 1 || report.DeletedReplicas != 0 {
+		t.Errorf("report = %d live, %d garbage, %d protected, %d deleted, want 1, 2, 1, 0",
+			report.LiveChunks, report.GarbageReplicas, report.ProtectedReplicas, report.DeletedReplicas)
+	}
+	got := make(map[string][]string)
+	for _, node := range report.Nodes {
+		got[node.NodeId] = node.Hashes
+	}
+	if want := map[string][]string{"n1": {"junk"}, "n2": {"junk2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("garbage = %v, want %v", got, want)
+	}
+
+	// A dry run changes nothing
+	if _, exists := m.reported["n1"]["junk"]; !exists {
+		t.Error("dry run forgot a garbage replica")
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	m := newTestManager(t, nil, testFile{id: "a", chunks: []string{"live"}, chunkSize: 10})
+	node := startDataNode(t, m, "n1")
+	old := time.Now().Add(-2 * uploadGracePeriod)
+	m.reported["n1"] = map[string]time.Time{"live": old, "junk": old, "fresh": time.Now()}
+	m.chunks["dead"] = &chunkRecord{nodes: []string{"n1"}}
+	m.uploads["up"] = &pendingUpload{hashes: map[string]bool{}, startedAt: time.Now().Add(-pendingUploadExpiry)}
+
+	report, err := m.collectGarbage(false)
+	if err != nil {
+		t.Fatalf("collectGarbage: %v", err)
+	}
+	if report.DeletedReplicas != 1 || report.FailedReplicas != 0 || report.DroppedRecords != 1 || report.ExpiredUploads != 1 {
+		t.Errorf("report = %d deleted, %d failed, %d dropped, %d expired, want 1, 0, 1, 1",
+			report.DeletedReplicas, report.FailedReplicas, report.DroppedRecords, report.ExpiredUploads)
+	}
+	if got := node.deletedChunks(); !reflect.DeepEqual(got, []string{"junk"}) {
+		t.Errorf("deleted chunks = %v, want [junk]", got)
+	}
+
+	var reported []string
+	for hash := range m.reported["n1"] {
+		reported = append(reported, hash)
+	}
+	sort.Strings(reported)
+	if want := []string{"fresh", "live"}; !reflect.DeepEqual(reported, want) {
+		t.Errorf("replicas reported by n1 = %v, want %v", reported, want)
+	}
+	if _, exists := m.chunks["dead"]; exists {
+		t.Error("record of a chunk nothing refers to was kept")
+	}
+	if len(m.uploads) != 0 {
+		t.Errorf("uploads = %v, want the abandoned upload expired", m.uploads)
+	}
+}
+
+func TestCollectGarbagePermissions(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		dryRun   bool
+		wantCode codes.Code
+	}{
+		{name: "dry run by a reader", user: "bob", dryRun: true},
+		{name: "collection by a reader", user: "bob", wantCode: codes.PermissionDenied},
+		{name: "collection by the root owner", user: "root"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, map[string][]string{"root": nil, "bob": nil})
+			m.SeedRootACL("root", "")
+			m.acls[rootDirectory].entries[otherPrincipal] = permRead
+
+			_, err := m.CollectGarbage(asUser(tt.user), &pb.CollectGarbageRequest{DryRun: tt.dryRun})
+			if status.Code(err) != tt.wantCode {
+				t.Errorf("CollectGarbage() error = %v, want code %v", err, tt.wantCode)
+			}
+		})
+	}
+}
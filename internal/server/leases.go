@@ -0,0 +1,147 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"time"
+)
+
+// defaultLeaseDuration and maxLeaseDuration bound how long a write lease lasts without being renewed
+const (
+	defaultLeaseDuration = time.Minute
+	maxLeaseDuration     = 10 * time.Minute
+)
+
+// lease gives a single writer the right to change a file. Other uploads, copies, restores and
+// deletes of the file fail until it is released or expires.
+type lease struct {
+	id        string
+	holder    string // User who acquired the lease
+	expiresAt time.Time
+}
+
+// AcquireLease gives the caller the write lease on an existing file, which appends and range
+// writes need. Only one lease can be held on a file at a time.
+func (m *ManagerNode) AcquireLease(ctx context.Context, req *pb.AcquireLeaseRequest) (*pb.Lease, error) {
+	duration, err := leaseDuration(req.Duration)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+	if _, exists := m.files[req.FileId]; !exists {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+	now := time.Now()
+	if err := m.checkRetention(req.FileId, now); err != nil {
+		return nil, err
+	}
+	if err := m.checkLease(req.FileId, "", now); err != nil {
+		return nil, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to generate lease ID: %v", err)
+	}
+	l := &lease{id: hex.EncodeToString(id), holder: userFromContext(ctx), expiresAt: now.Add(duration)}
+	m.leases[req.FileId] = l
+
+	log.Printf("User %s acquired the lease on file %s until %s", l.holder, req.FileId, l.expiresAt.Format(time.RFC3339))
+	return m.leaseInfo(req.FileId, l), nil
+}
+
+// RenewLease extends a write lease the caller holds
+func (m *ManagerNode) RenewLease(ctx context.Context, req *pb.RenewLeaseRequest) (*pb.Lease, error) {
+	duration, err := leaseDuration(req.Duration)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := m.checkLease(req.FileId, req.LeaseId, now); err != nil {
+		return nil, err
+	}
+	l := m.leases[req.FileId]
+	l.expiresAt = now.Add(duration)
+
+	return m.leaseInfo(req.FileId, l), nil
+}
+
+// ReleaseLease gives up a write lease, abandoning any write started under it that wasn't completed
+func (m *ManagerNode) ReleaseLease(ctx context.Context, req *pb.ReleaseLeaseRequest) (*pb.ReleaseLeaseResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+	if err := m.checkLease(req.FileId, req.LeaseId, time.Now()); err != nil {
+		return nil, err
+	}
+	delete(m.leases, req.FileId)
+	if upload, exists := m.uploads[req.FileId]; exists && upload.leaseID == req.LeaseId {
+		m.endUpload(req.FileId)
+	}
+
+	log.Printf("User %s released the lease on file %s", userFromContext(ctx), req.FileId)
+	return &pb.ReleaseLeaseResponse{Message: "Lease released"}, nil
+}
+
+// checkLease fails unless leaseID is the write lease currently held on a file, or, with an empty
+// leaseID, no lease is held on it. Expired leases are forgotten. The caller must hold m.mu.
+func (m *ManagerNode) checkLease(fileID, leaseID string, now time.Time) error {
+	l, exists := m.leases[fileID]
+	if exists && !now.Before(l.expiresAt) {
+		delete(m.leases, fileID)
+		exists = false
+	}
+	switch {
+	case exists && l.id == leaseID:
+		return nil
+	case exists:
+		return status.Errorf(codes.FailedPrecondition, "file %s is leased to %s until %s", fileID, l.holder, l.expiresAt.Format(time.RFC3339))
+	case leaseID != "":
+		return status.Errorf(codes.FailedPrecondition, "lease %s on file %s isn't held, it may have expired", leaseID, fileID)
+	}
+	return nil
+}
+
+// leaseInfo converts a lease to its protobuf message, with the file as committed. The caller must hold m.mu.
+func (m *ManagerNode) leaseInfo(fileID string, l *lease) *pb.Lease {
+	return &pb.Lease{
+		FileId:    fileID,
+		LeaseId:   l.id,
+		Holder:    l.holder,
+		ExpiresAt: l.expiresAt.Unix(),
+		File:      m.fileInfo(fileID),
+	}
+}
+
+// leaseDuration returns how long a lease requested for seconds lasts
+func leaseDuration(seconds int64) (time.Duration, error) {
+	duration := time.Duration(seconds) * time.Second
+	switch {
+	case seconds == 0:
+		return defaultLeaseDuration, nil
+	case seconds < 0 || duration > maxLeaseDuration:
+		return 0, status.Errorf(codes.InvalidArgument, "leases last between 1s and %s, got %ds", maxLeaseDuration, seconds)
+	}
+	return duration, nil
+}
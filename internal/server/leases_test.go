@@ -0,0 +1,131 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAcquireLease(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *pb.AcquireLeaseRequest
+		wantCode codes.Code
+		wantFor  time.Duration
+	}{
+		{name: "default duration", req: &pb.AcquireLeaseRequest{FileId: "a"}, wantFor: defaultLeaseDuration},
+		{name: "longest duration", req: &pb.AcquireLeaseRequest{FileId: "a", Duration: 600}, wantFor: maxLeaseDuration},
+		{name: "too long", req: &pb.AcquireLeaseRequest{FileId: "a", Duration: 601}, wantCode: codes.InvalidArgument},
+		{name: "negative duration", req: &pb.AcquireLeaseRequest{FileId: "a", Duration: -1}, wantCode: codes.InvalidArgument},
+		{name: "unknown file", req: &pb.AcquireLeaseRequest{FileId: "x"}, wantCode: codes.NotFound},
+		{name: "leased file", req: &pb.AcquireLeaseRequest{FileId: "leased"}, wantCode: codes.FailedPrecondition},
+		{name: "retained file", req: &pb.AcquireLeaseRequest{FileId: "kept"}, wantCode: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil,
+				testFile{id: "a", chunks: []string{"a1"}, chunkSize: 10},
+				testFile{id: "leased", chunks: []string{"l1"}, chunkSize: 10},
+				testFile{id: "kept", chunks: []string{"k1"}, chunkSize: 10},
+			)
+			m.leases["leased"] = &lease{id: "other", expiresAt: time.Now().Add(time.Minute)}
+			m.files["kept"].retainUntil = time.Now().Add(time.Hour)
+
+			now := time.Now()
+			resp, err := m.AcquireLease(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("AcquireLease() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+			if expiresAt := time.Unix(resp.ExpiresAt, 0); expiresAt.Before(now.Add(tt.wantFor-time.Second)) || expiresAt.After(now.Add(tt.wantFor+time.Second)) {
+				t.Errorf("lease expires at %s, want %s from now", expiresAt, tt.wantFor)
+			}
+			if resp.LeaseId == "" || m.leases[tt.req.FileId].id != resp.LeaseId {
+				t.Errorf("lease %q isn't the one held", resp.LeaseId)
+			}
+		})
+	}
+}
+
+func TestLeaseLifecycle(t *testing.T) {
+	m := newTestManager(t, nil, testFile{id: "a", chunks: []string{"a1"}, chunkSize: 10})
+	ctx := context.Background()
+	l, err := m.AcquireLease(ctx, &pb.AcquireLeaseRequest{FileId: "a"})
+	if err != nil {
+		t.Fatalf("AcquireLease() error = %v", err)
+	}
+
+	// Only the holder writes to the file while it is leased
+	if err := upload(t, m, "a", 10, hashOf("other")); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("upload without the lease error = %v, want FailedPrecondition", err)
+	}
+	if _, err := m.DeleteFile(ctx, &pb.DeleteFileRequest{FileId: "a"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("DeleteFile() without the lease error = %v, want FailedPrecondition", err)
+	}
+	if _, err := m.RenewLease(ctx, &pb.RenewLeaseRequest{FileId: "a", LeaseId: "other"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("RenewLease() of another lease error = %v, want FailedPrecondition", err)
+	}
+	if _, err := m.RenewLease(ctx, &pb.RenewLeaseRequest{FileId: "a", LeaseId: l.LeaseId, Duration: 300}); err != nil {
+		t.Fatalf("RenewLease() error = %v", err)
+	}
+	if expiresAt := m.leases["a"].expiresAt; time.Until(expiresAt) < 4*time.Minute {
+		t.Errorf("renewed lease expires at %s, want 5 minutes from now", expiresAt)
+	}
+
+	// Releasing the lease abandons the write started under it
+	_, err = m.GetNodesForChunks(ctx, &pb.GetNodesForChunksRequest{FileId: "a", LeaseId: l.LeaseId, Patch: true, FirstChunk: 1, TotalChunks: 1})
+	if err != nil {
+		t.Fatalf("GetNodesForChunks() error = %v", err)
+	}
+	if _, err := m.ReleaseLease(ctx, &pb.ReleaseLeaseRequest{FileId: "a", LeaseId: l.LeaseId}); err != nil {
+		t.Fatalf("ReleaseLease() error = %v", err)
+	}
+	if _, exists := m.uploads["a"]; exists {
+		t.Error("write started under the released lease is still in progress")
+	}
+	if _, err := m.ReleaseLease(ctx, &pb.ReleaseLeaseRequest{FileId: "a", LeaseId: l.LeaseId}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("ReleaseLease() of a released lease error = %v, want FailedPrecondition", err)
+	}
+	if err := upload(t, m, "a", 10, hashOf("other")); err != nil {
+		t.Errorf("upload after the lease was released error = %v", err)
+	}
+}
+
+func TestCheckLease(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name     string
+		lease    *lease
+		leaseID  string
+		wantCode codes.Code
+		wantGone bool // The lease is forgotten afterwards
+	}{
+		{name: "not leased"},
+		{name: "not leased with an ID", leaseID: "l", wantCode: codes.FailedPrecondition},
+		{name: "held", lease: &lease{id: "l", expiresAt: now.Add(time.Minute)}, leaseID: "l"},
+		{name: "held by another", lease: &lease{id: "l", expiresAt: now.Add(time.Minute)}, wantCode: codes.FailedPrecondition},
+		{name: "expired", lease: &lease{id: "l", expiresAt: now}, wantGone: true},
+		{name: "expired with its ID", lease: &lease{id: "l", expiresAt: now.Add(-time.Second)}, leaseID: "l", wantCode: codes.FailedPrecondition, wantGone: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, testFile{id: "a", chunks: []string{"a1"}, chunkSize: 10})
+			if tt.lease != nil {
+				m.leases["a"] = tt.lease
+			}
+
+			if err := m.checkLease("a", tt.leaseID, now); status.Code(err) != tt.wantCode {
+				t.Errorf("checkLease() error = %v, want code %v", err, tt.wantCode)
+			}
+			if _, exists := m.leases["a"]; exists != (tt.lease != nil && !tt.wantGone) {
+				t.Errorf("lease held = %v, want %v", exists, tt.lease != nil && !tt.wantGone)
+			}
+		})
+	}
+}
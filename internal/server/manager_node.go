@@ -2,36 +2,132 @@ package server
 
 import (
 	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/compression"
+	"breezeFS/internal/erasure"
+	"breezeFS/internal/security"
 	"context"
+	"crypto/tls"
 	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
+// defaultReplicationFactor is the number of copies kept of every chunk unless configured otherwise
+const defaultReplicationFactor = 2
+
 type ManagerNode struct {
 	pb.UnimplementedManagerServiceServer
-	mu            sync.Mutex
-	nodes         map[string]bool               // Registered nodes
-	nodeAddresses []string                      // List of node addresses
-	chunkMapping  map[string]map[int32][]string // FileID -> ChunkID -> []NodeAddresses
-	fileTypes     map[string]string             // Map to store file types by file ID
+	ReplicationFactor int                   // Number of copies kept of every chunk
+	DataNodeTLS       *tls.Config           // TLS configuration for commands sent to Data Nodes, nil for plain HTTP
+	RequireNodeCerts  bool                  // Only accept Data Nodes presenting a certificate signed by the cluster CA
+	APIKeys           map[string]string     // API key -> user, clients are anonymous when empty
+	Groups            map[string][]string   // User -> groups they belong to, for ACLs
+	Tokens            *security.TokenSigner // Signs chunk access tokens for Data Nodes, nil to disable
+	Compression       string                // Preferred codec for new chunks, none to store them uncompressed
+	DataShards        int                   // Data shards per chunk of erasure coded files
+	ParityShards      int                   // Parity shards per chunk of erasure coded files
+	VersionsKept      int                   // Previous versions kept of every file
+	GCGracePeriod     time.Duration         // How long newly reported replicas are protected from garbage collection
+
+	dataNodeHTTP     *http.Client // Client for commands sent to Data Nodes, created on first use
+	dataNodeHTTPOnce sync.Once
+
+	mu             sync.Mutex
+	rebalanceMu    sync.Mutex                      // Held while a rebalance is running
+	gcMu           sync.Mutex                      // Held while a garbage collection is running
+	nodes          map[string]string               // Registered nodes, NodeID -> current address
+	nodeAddresses  []string                        // List of node addresses
+	chunkMapping   map[string]map[int32]string     // FileID -> ChunkID -> chunk hash
+	chunks         map[string]*chunkRecord         // Chunk hash -> replicas and references
+	uploads        map[string]*pendingUpload       // FileID -> upload in progress
+	reported       map[string]map[string]time.Time // NodeID -> chunks the node reported -> when first reported
+	files          map[string]*fileMetadata        // FileID -> metadata of the current contents
+	versions       map[string][]*fileVersion       // FileID -> previous versions, oldest first
+	snapshots      map[string]*snapshot            // Snapshot name -> files captured
+	retentionRules map[string]*retentionRule       // Rule name -> when the files it applies to expire and are retained
+	charges        map[string]*charge              // FileID -> usage the file counts towards its owner and directories
+	ownerUsage     map[string]*usage               // Owner -> usage of their files
+	dirUsage       map[string]*usage               // Directory -> usage of the files under it
+	ownerQuotas    map[string]*quota               // Owner -> limits on their usage
+	dirQuotas      map[string]*quota               // Directory -> limits on the usage of the files under it
+	index          *fileIndex                      // Secondary indexes over files, for queries
+	decommissions  map[string]*decommission        // Nodes being drained or already retired
+	transfers      map[string]bool                 // Chunk copies to nodes currently in flight
+	pins           map[string]int                  // Chunk hash -> file copies and uploads in progress referring to it
+	leases         map[string]*lease               // FileID -> write lease held on the file
+	acls           map[string]*accessControl       // File ID or directory -> ACL
+	encryption     map[string]*pb.FileEncryption   // FileID -> wrapped data key of client-side encrypted files
 
 	//chunks map[string][]pb.ChunkInfo
 }
 
 func NewManagerNode() *ManagerNode {
 	return &ManagerNode{
-		nodes:        make(map[string]bool),
-		chunkMapping: make(map[string]map[int32][]string),
-		fileTypes:    make(map[string]string),
+		ReplicationFactor: defaultReplicationFactor,
+		Compression:       compression.Zstd,
+		DataShards:        defaultDataShards,
+		ParityShards:      defaultParityShards,
+		VersionsKept:      defaultVersionsKept,
+		GCGracePeriod:     uploadGracePeriod,
+
+		nodes:          make(map[string]string),
+		chunkMapping:   make(map[string]map[int32]string),
+		chunks:         make(map[string]*chunkRecord),
+		uploads:        make(map[string]*pendingUpload),
+		reported:       make(map[string]map[string]time.Time),
+		files:          make(map[string]*fileMetadata),
+		versions:       make(map[string][]*fileVersion),
+		snapshots:      make(map[string]*snapshot),
+		retentionRules: make(map[string]*retentionRule),
+		charges:        make(map[string]*charge),
+		ownerUsage:     make(map[string]*usage),
+		dirUsage:       make(map[string]*usage),
+		ownerQuotas:    make(map[string]*quota),
+		dirQuotas:      make(map[string]*quota),
+		index:          newFileIndex(),
+		decommissions:  make(map[string]*decommission),
+		transfers:      make(map[string]bool),
+		pins:           make(map[string]int),
+		leases:         make(map[string]*lease),
+		acls:           make(map[string]*accessControl),
+		encryption:     make(map[string]*pb.FileEncryption),
 
 		//chunks: make(map[string][]pb.ChunkInfo),
 	}
 }
 
 func (m *ManagerNode) RegisterNode(ctx context.Context, req *pb.RegisterNodeRequest) (*pb.RegisterNodeResponse, error) {
+	if err := m.authenticateNode(ctx); err != nil {
+		return nil, err
+	}
+
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.nodes[req.NodeAddress] = true
+
+	// Nodes without a persistent ID are identified by their address
+	nodeID := req.NodeId
+	if nodeID == "" {
+		nodeID = req.NodeAddress
+	}
+
+	// A restarted node keeps its ID, so its chunks stay reachable at the new address
+	if previous, exists := m.nodes[nodeID]; exists && previous != req.NodeAddress {
+		log.Printf("Node %s moved from %s to %s", nodeID, previous, req.NodeAddress)
+	}
+	m.nodes[nodeID] = req.NodeAddress
+
+	// A node that registers again after being retired rejoins as a fresh node
+	if status, exists := m.decommissions[nodeID]; exists && status.state != decommissionDraining {
+		delete(m.decommissions, nodeID)
+	}
 	return &pb.RegisterNodeResponse{Message: "Node registered successfully"}, nil
 }
 
@@ -39,57 +135,211 @@ func (m *ManagerNode) GetNodesForChunks(ctx context.Context, req *pb.GetNodesFor
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Extract node addresses from the map keys
-	nodeAddresses := make([]string, 0, len(m.nodes))
-	for address := range m.nodes {
-		nodeAddresses = append(nodeAddresses, address)
-	}
+	// Only place chunks on nodes that aren't being decommissioned
+	nodeIDs := m.activeNodes()
 
 	// Check if there are any registered nodes
-	if len(nodeAddresses) == 0 {
+	if len(nodeIDs) == 0 {
 		return nil, fmt.Errorf("no registered nodes available")
 	}
 	var chunkNodes []*pb.ChunkNodeInfo
 
-	// Initialize chunk mapping for the file if not already present
-	if _, exists := m.chunkMapping[req.FileId]; !exists {
-		m.chunkMapping[req.FileId] = make(map[int32][]string)
+	// Overwriting a file, or creating one in a directory, needs write permission
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if err := m.checkRetention(req.FileId, now); err != nil {
+		return nil, err
+	}
+	expiresAt, err := expiryFromRequest(req.ExpiresAt, req.Ttl, now)
+	if err != nil {
+		return nil, err
+	}
+	if req.Size < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid file size %d", req.Size)
+	}
+	if err := m.checkLease(req.FileId, req.LeaseId, now); err != nil {
+		return nil, err
+	}
+	var base *fileVersion
+	if req.Patch {
+		if base, err = m.patchBase(req); err != nil {
+			return nil, err
+		}
+	}
+	// Patches keep the storage class of the file
+	storageClass := req.StorageClass
+	if base != nil {
+		storageClass = base.metadata.storageClass
+	}
+	if storageClass == "" {
+		storageClass = erasure.ClassReplicated
+	}
+	if !erasure.SupportedClass(storageClass) {
+		return nil, fmt.Errorf("unknown storage class %q", storageClass)
+	}
+
+	// Keep as many copies as configured, but never more than there are nodes.
+	// Erasure coded chunks need a node for each shard.
+	replicas := m.ReplicationFactor
+	if replicas > len(nodeIDs) {
+		replicas = len(nodeIDs)
+	}
+	if storageClass == erasure.ClassErasure {
+		replicas = m.DataShards + m.ParityShards
+		if replicas > len(nodeIDs) {
+			return nil, fmt.Errorf("erasure coding needs %d nodes, only %d are available", replicas, len(nodeIDs))
+		}
 	}
 
-	// Assign each chunk to two nodes using round robin
-	for i := 0; i < int(req.TotalChunks); i++ {
+	// Patches keep the metadata of the file, a whole upload describes it anew
+	var metadata *fileMetadata
+	if base != nil {
+		patched := *base.metadata
+		patched.attributes = copyAttributes(base.metadata.attributes)
+		metadata = &patched
+	} else if metadata, err = newFileMetadata(req.FileType, req.DetectedType, req.ContentType); err != nil {
+		return nil, err
+	}
+	if err := validateAttributes(req.Attributes); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for key, value := range req.Attributes {
+		metadata.attributes[key] = value
+	}
+	metadata.expiresAt = expiresAt
+
+	// Check quotas before assigning any nodes, counting the upload as stored uncompressed
+	physical := req.Size * int64(replicas)
+	if storageClass == erasure.ClassErasure {
+		physical = storedWithParity(req.Size, m.DataShards, m.ParityShards)
+	}
+	owner := m.writerOwner(ctx, req.FileId)
+	reserved := m.uploadDelta(req.FileId, req.Size, physical)
+	warning, err := m.checkQuotas(owner, req.FileId, reserved)
+	if err != nil {
+		return nil, err
+	}
+	if warning != "" {
+		log.Printf("Upload of file %s: %s", req.FileId, warning)
+	}
+
+	codec := compression.Negotiate(m.Compression, req.Codecs, req.FileType)
+	log.Printf("User %s is uploading %d chunks of file %s", userFromContext(ctx), req.TotalChunks, req.FileId)
+
+	// The file keeps its current chunks until the upload is completed
+	upload := &pendingUpload{
+		metadata:     metadata,
+		encryption:   req.Encryption,
+		storageClass: storageClass,
+		owner:        owner,
+		reserved:     reserved,
+		leaseID:      req.LeaseId,
+		base:         base,
+		placement:    make(map[int32][]string, req.TotalChunks),
+		hashes:       make(map[string]bool),
+		pinned:       make(map[string]bool),
+		startedAt:    now,
+	}
+	m.endUpload(req.FileId) // A new upload replaces one in progress
+	m.uploads[req.FileId] = upload
+
+	// Patches number their chunks from the first one they replace
+	if base != nil {
+		upload.firstChunk = req.FirstChunk
+	}
 
-		firstNode := nodeAddresses[i%len(nodeAddresses)]
-		var secondNode string
+	// Assign each chunk to consecutive nodes using round robin
+	for i := 0; i < int(req.TotalChunks); i++ {
+		assigned := make([]string, 0, replicas)
+		for r := 0; r < replicas; r++ {
+			assigned = append(assigned, nodeIDs[(i+r)%len(nodeIDs)])
+		}
+		chunkID := upload.firstChunk + int32(i)
+		upload.placement[chunkID] = assigned
 
-		// Only assign a second node if more than one node is available
-		if len(nodeAddresses) > 1 {
-			secondNode = nodeAddresses[(i+1)%len(nodeAddresses)]
-		} else {
-			secondNode = firstNode // Fall back to the same node if only one is available
+		// Tell the client to upload the chunk to every assigned node. Replicas are passed along
+		// the pipeline of assigned nodes, shards are each sent to their node alone.
+		var pipeline []string
+		if storageClass != erasure.ClassErasure && len(assigned) > 1 {
+			pipeline = m.nodeAddressesFor(assigned)
 		}
+		token := m.signToken(security.OpUpload, security.UploadResource(req.FileId, strconv.Itoa(int(chunkID)), pipeline))
+		for shard, nodeID := range assigned {
+			chunkNodes = append(chunkNodes, &pb.ChunkNodeInfo{
+				ChunkId:     chunkID,
+				NodeAddress: m.nodes[nodeID],
+				Token:       token,
+				Shard:       int32(shard),
+			})
+		}
+	}
+
+	resp := &pb.GetNodesForChunksResponse{Nodes: chunkNodes, Codec: codec, Warning: warning}
+	if storageClass == erasure.ClassErasure {
+		upload.dataShards, upload.parityShards = m.DataShards, m.ParityShards
+		resp.DataShards, resp.ParityShards = int32(m.DataShards), int32(m.ParityShards)
+	}
+	return resp, nil
+}
+
+// authenticateNode rejects callers without a verified certificate when node certificates are required
+func (m *ManagerNode) authenticateNode(ctx context.Context) error {
+	if !m.RequireNodeCerts {
+		return nil
+	}
 
-		// Update chunk mapping with the assigned nodes, ensuring no duplicates
-		if firstNode != secondNode {
-			m.chunkMapping[req.FileId][int32(i)] = []string{firstNode, secondNode}
-		} else {
-			m.chunkMapping[req.FileId][int32(i)] = []string{firstNode}
+	p, ok := peer.FromContext(ctx)
+	if ok {
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && security.IsNodeCertificate(&info.State) {
+			return nil
 		}
+	}
+	return status.Error(codes.Unauthenticated, "a valid node certificate is required")
+}
 
-		// Assign file type to map
-		m.fileTypes[req.FileId] = req.FileType
+// activeNodes returns the IDs of registered nodes that accept new chunks, in a stable order.
+// The caller must hold m.mu.
+func (m *ManagerNode) activeNodes() []string {
+	nodeIDs := make([]string, 0, len(m.nodes))
+	for nodeID := range m.nodes {
+		if status, exists := m.decommissions[nodeID]; exists && status.state == decommissionDraining {
+			continue
+		}
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+	return nodeIDs
+}
 
-		// Ensure chunk duplication by assigning the chunk to two nodes
-		chunkNodes = append(chunkNodes, &pb.ChunkNodeInfo{
-			ChunkId:     int32(i),
-			NodeAddress: firstNode,
-		}, &pb.ChunkNodeInfo{
-			ChunkId:     int32(i),
-			NodeAddress: secondNode,
-		})
+// nodeAddressesFor resolves node IDs to their current addresses, skipping unknown nodes.
+// The caller must hold m.mu.
+func (m *ManagerNode) nodeAddressesFor(nodeIDs []string) []string {
+	addresses := make([]string, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		if address, exists := m.nodes[nodeID]; exists {
+			addresses = append(addresses, address)
+		}
 	}
+	return addresses
+}
 
-	return &pb.GetNodesForChunksResponse{Nodes: chunkNodes}, nil
+// resolveNode finds a registered or retired node by ID or by current address.
+// The caller must hold m.mu.
+func (m *ManagerNode) resolveNode(ref string) (string, bool) {
+	if _, exists := m.nodes[ref]; exists {
+		return ref, true
+	}
+	if _, exists := m.decommissions[ref]; exists {
+		return ref, true
+	}
+	for nodeID, address := range m.nodes {
+		if address == ref {
+			return nodeID, true
+		}
+	}
+	return "", false
 }
 
 // GetChunkLocations provides the locations of each chunk for a file
@@ -97,24 +347,90 @@ func (m *ManagerNode) GetChunkLocations(ctx context.Context, req *pb.GetChunkLoc
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	chunkLocations := m.chunkMapping[req.FileId]
-	if chunkLocations == nil {
-		fmt.Println("COULD NOT FIND")
+	if req.Snapshot != "" {
+		if req.Version != 0 {
+			return nil, status.Error(codes.InvalidArgument, "a snapshot holds a single version of every file")
+		}
+		if err := m.checkAccess(ctx, req.FileId, permRead); err != nil {
+			return nil, err
+		}
+		version, err := m.snapshotVersion(req.Snapshot, req.FileId)
+		if err != nil {
+			return nil, err
+		}
+		return m.versionLocations(req.FileId, version)
+	}
+
+	version := m.findVersion(req.FileId, req.Version)
+	if version == nil {
+		if req.Version != 0 {
+			return nil, fmt.Errorf("file %s has no version %d", req.FileId, req.Version)
+		}
 		return nil, fmt.Errorf("file not found")
 	}
+	if err := m.checkAccess(ctx, req.FileId, permRead); err != nil {
+		return nil, err
+	}
+	return m.versionLocations(req.FileId, version)
+}
 
-	fmt.Println("Chunk mapping for file %s: %v", req.FileId, chunkLocations)
+// versionLocations lists the chunks of a version of a file with the nodes holding them.
+// The caller must hold m.mu.
+func (m *ManagerNode) versionLocations(fileID string, version *fileVersion) (*pb.GetChunkLocationsResponse, error) {
+	chunkHashes := version.chunks
+	if err := m.checkChunks(fileID, chunkHashes); err != nil {
+		return nil, err
+	}
+
+	// Chunks vary in size, so each one starts where the ones before it end
+	chunkIDs := make([]int32, 0, len(chunkHashes))
+	for chunkID := range chunkHashes {
+		chunkIDs = append(chunkIDs, chunkID)
+	}
+	sort.Slice(chunkIDs, func(i, j int) bool { return chunkIDs[i] < chunkIDs[j] })
 
 	var chunkInfos []*pb.ChunkLocationInfo
-	for chunkID, nodes := range chunkLocations {
-		chunkInfos = append(chunkInfos, &pb.ChunkLocationInfo{
+	var offset int64
+	for _, chunkID := range chunkIDs {
+		hash := chunkHashes[chunkID]
+		chunk := m.chunks[hash]
+		info := &pb.ChunkLocationInfo{
 			ChunkId: chunkID,
-			Nodes:   nodes,
-		})
+			Nodes:   m.nodeAddressesFor(chunk.nodes),
+			Token:   m.signToken(security.OpDownload, security.DownloadResource(fileID, hash)),
+			Codec:   chunk.codec,
+			Size:    chunk.size,
+			Hash:    hash,
+			Offset:  offset,
+		}
+		// Chunks stored both ways are read the way the version's storage class keeps them
+		if chunk.stripe != nil && (len(chunk.nodes) == 0 || version.metadata.storageClass == erasure.ClassErasure) {
+			info.StoredSize = chunk.storedSize
+			info.DataShards = int32(chunk.stripe.dataShards)
+			info.ParityShards = int32(chunk.stripe.parityShards)
+			for _, shard := range chunk.stripe.shards {
+				// A lost shard is listed without nodes, the others are enough to decode the chunk
+				var nodes []string
+				if record, exists := m.chunks[shard]; exists {
+					nodes = m.nodeAddressesFor(record.nodes)
+				}
+				info.Shards = append(info.Shards, &pb.ShardLocation{
+					Hash:  shard,
+					Nodes: nodes,
+					Token: m.signToken(security.OpDownload, security.DownloadResource(fileID, shard)),
+				})
+			}
+		}
+		chunkInfos = append(chunkInfos, info)
+		offset += chunk.size
 	}
 
 	return &pb.GetChunkLocationsResponse{
-		Chunks:   chunkInfos,
-		FileType: m.fileTypes[req.FileId],
+		Chunks:       chunkInfos,
+		FileType:     version.metadata.extension,
+		Encryption:   version.encryption,
+		ContentType:  version.metadata.servedType(),
+		DetectedType: version.metadata.detectedType,
+		Version:      version.metadata.version,
 	}, nil
 }
@@ -0,0 +1,197 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/erasure"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testFile describes a file stored by newTestManager or storeFile
+type testFile struct {
+	id          string
+	owner       string            // Owner with an ACL of their own, empty for none
+	chunks      []string          // Hashes of the chunks, recorded with chunkSize bytes each
+	chunkSize   int64             // Logical and stored size of every chunk
+	attributes  map[string]string // Attributes of the file
+	extension   string
+	contentType string
+	modifiedAt  time.Time // Zero for now
+}
+
+// newTestManager returns a Manager Node with the Data Nodes n1 and n2 holding the given files.
+// Given users, clients authenticate, every user's API key is their name and the map lists their groups.
+func newTestManager(t *testing.T, users map[string][]string, files ...testFile) *ManagerNode {
+	t.Helper()
+	m := NewManagerNode()
+	m.nodes["n1"] = "127.0.0.1:1"
+	m.nodes["n2"] = "127.0.0.1:2"
+	if users != nil {
+		m.APIKeys = make(map[string]string, len(users))
+		for user := range users {
+			m.APIKeys[user] = user
+		}
+		m.Groups = users
+	}
+	for _, f := range files {
+		storeFile(t, m, f)
+	}
+	return m
+}
+
+// asUser returns a context authenticated as user
+func asUser(user string) context.Context {
+	return context.WithValue(context.Background(), userKey{}, user)
+}
+
+// storeFile records a file as if it was uploaded, replacing the current version if there is one
+func storeFile(t *testing.T, m *ManagerNode, f testFile) {
+	t.Helper()
+	if f.owner != "" {
+		m.acls[f.id] = &accessControl{owner: f.owner, entries: make(map[string]int)}
+	}
+
+	chunks := make(map[int32]string, len(f.chunks))
+	for i, hash := range f.chunks {
+		chunks[int32(i)] = hash
+		if record, exists := m.chunks[hash]; exists {
+			record.refs++
+			continue
+		}
+		m.chunks[hash] = &chunkRecord{
+			nodes:      []string{"n1", "n2"},
+			refs:       1,
+			codec:      "none",
+			size:       f.chunkSize,
+			storedSize: f.chunkSize,
+		}
+	}
+
+	now := f.modifiedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+	attributes := f.attributes
+	if attributes == nil {
+		attributes = make(map[string]string)
+	}
+	metadata := &fileMetadata{
+		extension:    f.extension,
+		contentType:  normalizeContentType(f.contentType),
+		attributes:   attributes,
+		size:         f.chunkSize * int64(len(f.chunks)),
+		storageClass: erasure.ClassReplicated,
+		createdAt:    now,
+	}
+	if previous, exists := m.files[f.id]; exists {
+		metadata.createdAt = previous.createdAt
+	}
+	m.replaceVersion(f.id, &fileVersion{chunks: chunks, metadata: metadata}, now)
+}
+
+// hashOf returns the hash of a chunk holding data
+func hashOf(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// upload stores a file through the Manager Node like a client does: it starts an upload, asks which
+// chunks are stored already and completes it. Every chunk is size bytes long and stored as is.
+func upload(t *testing.T, m *ManagerNode, fileID string, size int64, hashes ...string) error {
+	t.Helper()
+	ctx := context.Background()
+	if _, err := m.GetNodesForChunks(ctx, &pb.GetNodesForChunksRequest{FileId: fileID, TotalChunks: int32(len(hashes))}); err != nil {
+		return err
+	}
+	if _, err := m.FindChunks(ctx, &pb.FindChunksRequest{FileId: fileID, Hashes: hashes}); err != nil {
+		return err
+	}
+	_, err := m.CompleteUpload(ctx, &pb.CompleteUploadRequest{FileId: fileID, Chunks: uploadedChunks(size, hashes...)})
+	return err
+}
+
+// uploadedChunks describes chunks of size bytes stored as they are, numbered from 0
+func uploadedChunks(size int64, hashes ...string) []*pb.ChunkMetadata {
+	chunks := make([]*pb.ChunkMetadata, len(hashes))
+	for i, hash := range hashes {
+		chunks[i] = &pb.ChunkMetadata{ChunkId: int32(i), Hash: hash, Codec: "none", Size: size, StoredSize: size}
+	}
+	return chunks
+}
+
+// fakeDataNode answers the commands the Manager Node sends to Data Nodes. Every chunk
+// has the same checksum, unless the node is told to report a different one.
+type fakeDataNode struct {
+	mu        sync.Mutex
+	checksum  string
+	fail      bool     // Fail every command
+	deleted   []string // Hashes of deleted chunks
+	onCommand func()   // Called before every command is answered, set before the first one
+}
+
+// startDataNode serves a fake Data Node and registers it with the Manager Node as nodeID
+func startDataNode(t *testing.T, m *ManagerNode, nodeID string) *fakeDataNode {
+	t.Helper()
+	node := &fakeDataNode{checksum: "sum"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if node.onCommand != nil {
+			node.onCommand()
+		}
+		node.mu.Lock()
+		defer node.mu.Unlock()
+
+		if node.fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/replicate", "/checksum":
+			w.Write([]byte(node.checksum))
+		case "/encode":
+			// Every shard gets a hash of its own
+			var req encodeRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(hashOf(fmt.Sprintf("%s/%d", r.URL.Query().Get("hash"), req.Index))))
+		case "/decode":
+		case "/delete":
+			node.deleted = append(node.deleted, r.URL.Query().Get("hash"))
+		case "/delete-batch":
+			var batch deleteBatch
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			result := deleteBatchResult{}
+			for _, chunk := range batch.Chunks {
+				node.deleted = append(node.deleted, chunk.Hash)
+				result.Deleted = append(result.Deleted, chunk.Hash)
+			}
+			json.NewEncoder(w).Encode(result)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	m.nodes[nodeID] = strings.TrimPrefix(server.URL, "http://")
+	return node
+}
+
+// deletedChunks returns the hashes deleted from the node so far
+func (n *fakeDataNode) deletedChunks() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.deleted...)
+}
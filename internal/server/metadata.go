@@ -0,0 +1,189 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"log"
+	"mime"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultContentType is served for files whose type is unknown
+const defaultContentType = "application/octet-stream"
+
+// fileMetadata describes a file's current contents, as given by the client on upload
+type fileMetadata struct {
+	extension    string            // Original file extension, without the dot
+	detectedType string            // MIME type sniffed from the start of the file
+	contentType  string            // Content type given by the user, empty if none was
+	attributes   map[string]string // User-defined attributes
+	size         int64             // Size in bytes, set once the upload completes
+	storageClass string
+	createdAt    time.Time // When the file was first uploaded
+	modifiedAt   time.Time // When the upload completed
+	version      int64     // Counts uploads and restores of the file
+	expiresAt    time.Time // When the file expires, zero unless set on the file
+	retainUntil  time.Time // The file can't be deleted or overwritten before then
+	legalHold    bool      // The file can't be deleted or overwritten until the hold is released
+}
+
+// newFileMetadata records the metadata of an upload. Sniffed types that don't parse are
+// dropped, while an invalid content type from the user is an error.
+func newFileMetadata(extension, detectedType, contentType string) (*fileMetadata, error) {
+	metadata := &fileMetadata{
+		extension:    strings.TrimPrefix(extension, "."),
+		detectedType: normalizeContentType(detectedType),
+		contentType:  normalizeContentType(contentType),
+		attributes:   make(map[string]string),
+	}
+	if contentType != "" && metadata.contentType == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid content type %q", contentType)
+	}
+	return metadata, nil
+}
+
+// servedType returns the content type the file is served with: the one given by the user,
+// otherwise the one registered for the extension, otherwise the sniffed one
+func (f *fileMetadata) servedType() string {
+	if f.contentType != "" {
+		return f.contentType
+	}
+	if f.extension != "" {
+		if byExtension := mime.TypeByExtension("." + f.extension); byExtension != "" {
+			return byExtension
+		}
+	}
+	if f.detectedType != "" {
+		return f.detectedType
+	}
+	return defaultContentType
+}
+
+// normalizeContentType returns a content type in canonical form, or an empty string if it's invalid
+func normalizeContentType(contentType string) string {
+	if contentType == "" {
+		return ""
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return ""
+	}
+	return mime.FormatMediaType(mediaType, params)
+}
+
+// StatFile returns the metadata of a file
+func (m *ManagerNode) StatFile(ctx context.Context, req *pb.StatFileRequest) (*pb.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permRead); err != nil {
+		return nil, err
+	}
+	if _, exists := m.files[req.FileId]; !exists {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+	return m.versionInfo(req.FileId, m.currentVersion(req.FileId)), nil
+}
+
+// DeleteFile deletes a file with all of its versions. Snapshots keep the versions they captured.
+func (m *ManagerNode) DeleteFile(ctx context.Context, req *pb.DeleteFileRequest) (*pb.DeleteFileResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+	if _, exists := m.files[req.FileId]; !exists {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+	now := time.Now()
+	if err := m.checkRetention(req.FileId, now); err != nil {
+		return nil, err
+	}
+	if err := m.checkLease(req.FileId, "", now); err != nil {
+		return nil, err
+	}
+	m.deleteFile(req.FileId)
+
+	log.Printf("User %s deleted file %s", userFromContext(ctx), req.FileId)
+	return &pb.DeleteFileResponse{Message: "File deleted"}, nil
+}
+
+// ListFiles returns the metadata of every file under a prefix that the user may read, in the
+// live namespace or in a snapshot
+func (m *ManagerNode) ListFiles(ctx context.Context, req *pb.ListFilesRequest) (*pb.ListFilesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	resp := &pb.ListFilesResponse{}
+	if req.Snapshot != "" {
+		fileIDs, err := m.snapshotFiles(req.Snapshot, req.Prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, fileID := range fileIDs {
+			if m.checkAccess(ctx, fileID, permRead) == nil {
+				version, _ := m.snapshotVersion(req.Snapshot, fileID)
+				resp.Files = append(resp.Files, m.versionInfo(fileID, version))
+			}
+		}
+		return resp, nil
+	}
+
+	for _, fileID := range m.index.prefixed(req.Prefix) {
+		if m.checkAccess(ctx, fileID, permRead) == nil {
+			resp.Files = append(resp.Files, m.fileInfo(fileID))
+		}
+	}
+	return resp, nil
+}
+
+// deleteFile forgets a file and its versions, releasing their chunks. The caller must hold m.mu.
+func (m *ManagerNode) deleteFile(fileID string) {
+	metadata, exists := m.files[fileID]
+	if !exists {
+		return
+	}
+	m.releaseVersion(m.currentVersion(fileID))
+	for _, version := range m.versions[fileID] {
+		m.releaseVersion(version)
+	}
+
+	m.index.remove(fileID, metadata)
+	m.uncharge(fileID)
+	delete(m.files, fileID)
+	delete(m.chunkMapping, fileID)
+	delete(m.versions, fileID)
+	delete(m.encryption, fileID)
+	delete(m.acls, fileID)
+}
+
+// fileInfo describes the current version of a stored file. The caller must hold m.mu.
+func (m *ManagerNode) fileInfo(fileID string) *pb.FileInfo {
+	return m.versionInfo(fileID, m.currentVersion(fileID))
+}
+
+// versionInfo describes a version of a stored file. The caller must hold m.mu.
+func (m *ManagerNode) versionInfo(fileID string, version *fileVersion) *pb.FileInfo {
+	metadata := version.metadata
+	return &pb.FileInfo{
+		FileId:       fileID,
+		Size:         metadata.size,
+		Chunks:       int32(len(version.chunks)),
+		FileType:     metadata.extension,
+		ContentType:  metadata.servedType(),
+		StorageClass: metadata.storageClass,
+		Encrypted:    version.encryption != nil,
+		ModifiedAt:   metadata.modifiedAt.Unix(),
+		CreatedAt:    metadata.createdAt.Unix(),
+		Version:      metadata.version,
+		Attributes:   copyAttributes(metadata.attributes),
+		ExpiresAt:    unixOrZero(m.expiry(fileID, metadata)),
+		RetainUntil:  unixOrZero(m.retainedUntil(fileID, metadata)),
+		LegalHold:    metadata.legalHold,
+	}
+}
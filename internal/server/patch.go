@@ -0,0 +1,70 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// patchBase returns the current version of a file that a patch, an append or a write to a range
+// of the file, applies to. Patches need the write lease, so nothing else changes the file until
+// they are completed. The caller must hold m.mu.
+func (m *ManagerNode) patchBase(req *pb.GetNodesForChunksRequest) (*fileVersion, error) {
+	if req.LeaseId == "" {
+		return nil, status.Errorf(codes.FailedPrecondition, "writing part of file %s needs its write lease", req.FileId)
+	}
+	base := m.currentVersion(req.FileId)
+	if base == nil {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+	// Chunk nonces come from their IDs, rewriting a chunk under the same data key would reuse one
+	if base.encryption != nil || req.Encryption != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "file %s is encrypted on the client, upload it again instead of writing part of it", req.FileId)
+	}
+	if req.TotalChunks <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "a patch needs at least one chunk")
+	}
+	if req.FirstChunk < 0 || int(req.FirstChunk) > len(base.chunks) {
+		return nil, status.Errorf(codes.InvalidArgument, "first chunk %d is outside file %s, which has %d chunks",
+			req.FirstChunk, req.FileId, len(base.chunks))
+	}
+	return base, nil
+}
+
+// patchChunks merges the chunks uploaded by a patch into the version it was started on, and
+// returns the chunks of the patched file along with those it keeps. Chunks after the written
+// range stay where they are, so the chunks replaced before them must keep their sizes.
+// The caller must hold m.mu.
+func (m *ManagerNode) patchChunks(fileID string, upload *pendingUpload, uploaded map[int32]string, chunks []*pb.ChunkMetadata) (map[int32]string, []string, error) {
+	base := upload.base
+	if current, exists := m.files[fileID]; !exists || current.version != base.metadata.version {
+		return nil, nil, status.Errorf(codes.Aborted, "file %s changed since the write to version %d started", fileID, base.metadata.version)
+	}
+	// The chunks kept, and the sizes of those replaced, come from the records of the base
+	if err := m.checkChunks(fileID, base.chunks); err != nil {
+		return nil, nil, err
+	}
+
+	end := upload.firstChunk + int32(len(uploaded))
+	if int(end) < len(base.chunks) {
+		for _, chunk := range chunks {
+			if replaced := m.chunks[base.chunks[chunk.ChunkId]]; chunk.Size != replaced.size {
+				return nil, nil, status.Errorf(codes.InvalidArgument, "chunk %d must stay %d bytes long, the chunks after it are kept",
+					chunk.ChunkId, replaced.size)
+			}
+		}
+	}
+
+	merged := make(map[int32]string, len(base.chunks)+len(uploaded))
+	var kept []string
+	for chunkID, hash := range base.chunks {
+		if chunkID < upload.firstChunk || chunkID >= end {
+			merged[chunkID] = hash
+			kept = append(kept, hash)
+		}
+	}
+	for chunkID, hash := range uploaded {
+		merged[chunkID] = hash
+	}
+	return merged, kept, nil
+}
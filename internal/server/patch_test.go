@@ -0,0 +1,129 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestPatchBase(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *pb.GetNodesForChunksRequest
+		wantCode codes.Code
+	}{
+		{name: "append", req: &pb.GetNodesForChunksRequest{FileId: "a", LeaseId: "l", FirstChunk: 2, TotalChunks: 1}},
+		{name: "range", req: &pb.GetNodesForChunksRequest{FileId: "a", LeaseId: "l", TotalChunks: 1}},
+		{name: "without a lease", req: &pb.GetNodesForChunksRequest{FileId: "a", TotalChunks: 1}, wantCode: codes.FailedPrecondition},
+		{name: "unknown file", req: &pb.GetNodesForChunksRequest{FileId: "x", LeaseId: "l", TotalChunks: 1}, wantCode: codes.NotFound},
+		{name: "encrypted file", req: &pb.GetNodesForChunksRequest{FileId: "secret", LeaseId: "l", TotalChunks: 1}, wantCode: codes.FailedPrecondition},
+		{name: "encrypted chunks", req: &pb.GetNodesForChunksRequest{FileId: "a", LeaseId: "l", TotalChunks: 1, Encryption: &pb.FileEncryption{}}, wantCode: codes.FailedPrecondition},
+		{name: "no chunks", req: &pb.GetNodesForChunksRequest{FileId: "a", LeaseId: "l"}, wantCode: codes.InvalidArgument},
+		{name: "before the file", req: &pb.GetNodesForChunksRequest{FileId: "a", LeaseId: "l", FirstChunk: -1, TotalChunks: 1}, wantCode: codes.InvalidArgument},
+		{name: "past the end", req: &pb.GetNodesForChunksRequest{FileId: "a", LeaseId: "l", FirstChunk: 3, TotalChunks: 1}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil,
+				testFile{id: "a", chunks: []string{"c0", "c1"}, chunkSize: 10},
+				testFile{id: "secret", chunks: []string{"s0"}, chunkSize: 10},
+			)
+			m.encryption["secret"] = &pb.FileEncryption{}
+
+			base, err := m.patchBase(tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("patchBase() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err == nil && base.metadata != m.files[tt.req.FileId] {
+				t.Error("patchBase() didn't return the current version")
+			}
+		})
+	}
+}
+
+func TestPatchFile(t *testing.T) {
+	n1, n2, n3 := hashOf("n1"), hashOf("n2"), hashOf("n3")
+	tests := []struct {
+		name       string
+		firstChunk int32
+		hashes     []string
+		size       int64
+		meanwhile  func(m *ManagerNode) // Runs after the patch started
+		wantCode   codes.Code
+		wantChunks []string // Chunks of the current version afterwards
+		wantSize   int64
+	}{
+		{name: "append", firstChunk: 3, hashes: []string{n3}, size: 5, wantChunks: []string{"c0", "c1", "c2", n3}, wantSize: 35},
+		{name: "chunk in the middle", firstChunk: 1, hashes: []string{n1}, size: 10, wantChunks: []string{"c0", n1, "c2"}, wantSize: 30},
+		{name: "chunks up to the end", firstChunk: 2, hashes: []string{n2, n3}, size: 7, wantChunks: []string{"c0", "c1", n2, n3}, wantSize: 34},
+		{
+			name: "chunk in the middle resized", firstChunk: 1, hashes: []string{n1}, size: 5,
+			wantCode: codes.InvalidArgument, wantChunks: []string{"c0", "c1", "c2"}, wantSize: 30,
+		},
+		{
+			name: "file changed meanwhile", firstChunk: 3, hashes: []string{n3}, size: 5,
+			meanwhile: func(m *ManagerNode) { storeFile(t, m, testFile{id: "a", chunks: []string{"c9"}, chunkSize: 10}) },
+			wantCode:  codes.Aborted, wantChunks: []string{"c9"}, wantSize: 10,
+		},
+		{
+			name: "replaced chunk lost meanwhile", firstChunk: 1, hashes: []string{n1}, size: 10,
+			meanwhile: func(m *ManagerNode) { delete(m.chunks, "c1") },
+			wantCode:  codes.FailedPrecondition, wantChunks: []string{"c0", "c1", "c2"}, wantSize: 30,
+		},
+		{
+			name: "kept chunk lost meanwhile", firstChunk: 3, hashes: []string{n3}, size: 5,
+			meanwhile: func(m *ManagerNode) { delete(m.chunks, "c2") },
+			wantCode:  codes.FailedPrecondition, wantChunks: []string{"c0", "c1", "c2"}, wantSize: 30,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, testFile{id: "a", chunks: []string{"c0", "c1", "c2"}, chunkSize: 10})
+			ctx := context.Background()
+			l, err := m.AcquireLease(ctx, &pb.AcquireLeaseRequest{FileId: "a"})
+			if err != nil {
+				t.Fatalf("AcquireLease() error = %v", err)
+			}
+			_, err = m.GetNodesForChunks(ctx, &pb.GetNodesForChunksRequest{
+				FileId: "a", LeaseId: l.LeaseId, Patch: true, FirstChunk: tt.firstChunk, TotalChunks: int32(len(tt.hashes)),
+			})
+			if err != nil {
+				t.Fatalf("GetNodesForChunks() error = %v", err)
+			}
+			if tt.meanwhile != nil {
+				tt.meanwhile(m)
+			}
+
+			chunks := uploadedChunks(tt.size, tt.hashes...)
+			for _, chunk := range chunks {
+				chunk.ChunkId += tt.firstChunk
+			}
+			_, err = m.CompleteUpload(ctx, &pb.CompleteUploadRequest{FileId: "a", Chunks: chunks})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("CompleteUpload() error = %v, want code %v", err, tt.wantCode)
+			}
+
+			current := m.currentVersion("a")
+			var got []string
+			for i := 0; i < len(current.chunks); i++ {
+				got = append(got, current.chunks[int32(i)])
+			}
+			if !reflect.DeepEqual(got, tt.wantChunks) {
+				t.Errorf("chunks = %v, want %v", got, tt.wantChunks)
+			}
+			if current.metadata.size != tt.wantSize {
+				t.Errorf("size = %d, want %d", current.metadata.size, tt.wantSize)
+			}
+			// The chunks kept are shared with the previous version
+			if err == nil {
+				if refs := m.chunks["c0"].refs; refs != 2 {
+					t.Errorf("refs of a kept chunk = %d, want 2", refs)
+				}
+			}
+		})
+	}
+}
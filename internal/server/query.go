@@ -0,0 +1,429 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"sort"
+	"strings"
+)
+
+// Page sizes of query results
+const (
+	defaultQueryPageSize = 100
+	maxQueryPageSize     = 1000
+)
+
+// Fields query results can be sorted by
+const (
+	sortByID       = "id"
+	sortBySize     = "size"
+	sortByCreated  = "created"
+	sortByModified = "modified"
+)
+
+// indexEntry is a file in an ordered index
+type indexEntry struct {
+	value  int64
+	fileID string
+}
+
+// fileIndex holds secondary indexes over file metadata, so queries only look at files
+// that can match their most selective filter
+type fileIndex struct {
+	ids         []string                              // Every file ID in order, for prefix scans
+	bySize      []indexEntry                          // Ordered by size
+	byCreated   []indexEntry                          // Ordered by creation time
+	byModified  []indexEntry                          // Ordered by modification time
+	byType      map[string]map[string]bool            // Media type -> file IDs
+	byExtension map[string]map[string]bool            // Lower case extension -> file IDs
+	byAttribute map[string]map[string]map[string]bool // Attribute -> value -> file IDs
+}
+
+// queryFilter is a validated query
+type queryFilter struct {
+	*pb.QueryRequest
+	pageSize int
+	cursor   *pageCursor // Last file of the previous page, nil for the first page
+}
+
+// pageCursor is the position a page of query results ends at, sent to clients as the page token
+type pageCursor struct {
+	SortBy     string `json:"s"`
+	Descending bool   `json:"d"`
+	Key        int64  `json:"k"`
+	FileID     string `json:"f"`
+}
+
+func newFileIndex() *fileIndex {
+	return &fileIndex{
+		byType:      make(map[string]map[string]bool),
+		byExtension: make(map[string]map[string]bool),
+		byAttribute: make(map[string]map[string]map[string]bool),
+	}
+}
+
+// Query finds the files matching every given filter, sorted and split into pages
+func (m *ManagerNode) Query(ctx context.Context, req *pb.QueryRequest) (*pb.QueryResponse, error) {
+	filter, err := newQueryFilter(req)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var matches []string
+	for _, fileID := range m.index.candidates(filter) {
+		if filter.matches(fileID, m.files[fileID]) && m.checkAccess(ctx, fileID, permRead) == nil {
+			matches = append(matches, fileID)
+		}
+	}
+
+	less := func(a, b string) bool {
+		keyA, keyB := m.files[a].sortKey(req.SortBy), m.files[b].sortKey(req.SortBy)
+		if keyA != keyB {
+			return keyA < keyB != req.Descending
+		}
+		return a < b != req.Descending
+	}
+	sort.Slice(matches, func(i, j int) bool { return less(matches[i], matches[j]) })
+
+	// Resume after the last file of the previous page, even if it has changed or gone since
+	start := 0
+	if cursor := filter.cursor; cursor != nil {
+		start = sort.Search(len(matches), func(i int) bool {
+			key := m.files[matches[i]].sortKey(req.SortBy)
+			if key != cursor.Key {
+				return key > cursor.Key != req.Descending
+			}
+			if req.Descending {
+				return matches[i] < cursor.FileID
+			}
+			return matches[i] > cursor.FileID
+		})
+	}
+
+	resp := &pb.QueryResponse{}
+	end := start + filter.pageSize
+	if end > len(matches) {
+		end = len(matches)
+	}
+	for _, fileID := range matches[start:end] {
+		resp.Files = append(resp.Files, m.fileInfo(fileID))
+	}
+	if end < len(matches) {
+		last := matches[end-1]
+		resp.NextPageToken = encodeCursor(&pageCursor{
+			SortBy:     req.SortBy,
+			Descending: req.Descending,
+			Key:        m.files[last].sortKey(req.SortBy),
+			FileID:     last,
+		})
+	}
+	return resp, nil
+}
+
+// newQueryFilter checks a query and decodes its page token
+func newQueryFilter(req *pb.QueryRequest) (*queryFilter, error) {
+	if req.SortBy == "" {
+		req.SortBy = sortByID
+	}
+	switch req.SortBy {
+	case sortByID, sortBySize, sortByCreated, sortByModified:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown sort field %q, expected id, size, created or modified", req.SortBy)
+	}
+	if req.ContentType != "" {
+		req.ContentType = strings.ToLower(req.ContentType)
+		if !strings.HasSuffix(req.ContentType, "/*") && normalizeContentType(req.ContentType) == "" {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid content type %q", req.ContentType)
+		}
+	}
+	if req.MinSize < 0 || req.MaxSize < 0 || req.PageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "sizes and page size can't be negative")
+	}
+
+	filter := &queryFilter{QueryRequest: req, pageSize: int(req.PageSize)}
+	if filter.pageSize == 0 {
+		filter.pageSize = defaultQueryPageSize
+	}
+	if filter.pageSize > maxQueryPageSize {
+		filter.pageSize = maxQueryPageSize
+	}
+	if req.PageToken != "" {
+		cursor, err := decodeCursor(req.PageToken)
+		if err != nil || cursor.SortBy != req.SortBy || cursor.Descending != req.Descending {
+			return nil, status.Error(codes.InvalidArgument, "invalid page token")
+		}
+		filter.cursor = cursor
+	}
+	return filter, nil
+}
+
+// matches reports whether a file matches every filter of the query
+func (f *queryFilter) matches(fileID string, metadata *fileMetadata) bool {
+	if !strings.HasPrefix(fileID, f.Prefix) {
+		return false
+	}
+	if f.ContentType != "" && !matchesContentType(metadata.mediaType(), f.ContentType) {
+		return false
+	}
+	if f.FileType != "" && !strings.EqualFold(metadata.extension, strings.TrimPrefix(f.FileType, ".")) {
+		return false
+	}
+	if metadata.size < f.MinSize || (f.MaxSize > 0 && metadata.size > f.MaxSize) {
+		return false
+	}
+	if !inTimeRange(metadata.createdAt.Unix(), f.CreatedAfter, f.CreatedBefore) ||
+		!inTimeRange(metadata.modifiedAt.Unix(), f.ModifiedAfter, f.ModifiedBefore) {
+		return false
+	}
+	for key, value := range f.Attributes {
+		if actual, exists := metadata.attributes[key]; !exists || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// candidates returns the files that may match a query, taken from whichever index narrows it down the most
+func (ix *fileIndex) candidates(f *queryFilter) []string {
+	best := ix.ids
+
+	consider := func(fileIDs []string) {
+		if len(fileIDs) < len(best) {
+			best = fileIDs
+		}
+	}
+	considerSet := func(set map[string]bool) {
+		if len(set) < len(best) {
+			best = setToSlice(set)
+		}
+	}
+	considerRange := func(entries []indexEntry, from, to int64) {
+		if matching := indexRange(entries, from, to); len(matching) < len(best) {
+			best = make([]string, len(matching))
+			for i, entry := range matching {
+				best[i] = entry.fileID
+			}
+		}
+	}
+
+	for key, value := range f.Attributes {
+		considerSet(ix.byAttribute[key][value])
+	}
+	if f.ContentType != "" {
+		if major, wildcard := strings.CutSuffix(f.ContentType, "*"); wildcard {
+			union := make(map[string]bool)
+			for mediaType, fileIDs := range ix.byType {
+				if strings.HasPrefix(mediaType, major) {
+					for fileID := range fileIDs {
+						union[fileID] = true
+					}
+				}
+			}
+			considerSet(union)
+		} else {
+			considerSet(ix.byType[mediaTypeOf(f.ContentType)])
+		}
+	}
+	if f.FileType != "" {
+		considerSet(ix.byExtension[strings.ToLower(strings.TrimPrefix(f.FileType, "."))])
+	}
+	if f.Prefix != "" {
+		consider(ix.prefixed(f.Prefix))
+	}
+	if f.MinSize > 0 || f.MaxSize > 0 {
+		to := int64(0)
+		if f.MaxSize > 0 {
+			to = f.MaxSize + 1
+		}
+		considerRange(ix.bySize, f.MinSize, to)
+	}
+	if f.CreatedAfter > 0 || f.CreatedBefore > 0 {
+		considerRange(ix.byCreated, f.CreatedAfter, f.CreatedBefore)
+	}
+	if f.ModifiedAfter > 0 || f.ModifiedBefore > 0 {
+		considerRange(ix.byModified, f.ModifiedAfter, f.ModifiedBefore)
+	}
+	return best
+}
+
+// add indexes a file
+func (ix *fileIndex) add(fileID string, metadata *fileMetadata) {
+	i := sort.SearchStrings(ix.ids, fileID)
+	ix.ids = append(ix.ids, "")
+	copy(ix.ids[i+1:], ix.ids[i:])
+	ix.ids[i] = fileID
+
+	ix.bySize = insertEntry(ix.bySize, indexEntry{metadata.size, fileID})
+	ix.byCreated = insertEntry(ix.byCreated, indexEntry{metadata.createdAt.Unix(), fileID})
+	ix.byModified = insertEntry(ix.byModified, indexEntry{metadata.modifiedAt.Unix(), fileID})
+	addToSet(ix.byType, metadata.mediaType(), fileID)
+	addToSet(ix.byExtension, strings.ToLower(metadata.extension), fileID)
+	for key, value := range metadata.attributes {
+		if ix.byAttribute[key] == nil {
+			ix.byAttribute[key] = make(map[string]map[string]bool)
+		}
+		addToSet(ix.byAttribute[key], value, fileID)
+	}
+}
+
+// remove drops a file from the index, metadata must be what it was indexed with
+func (ix *fileIndex) remove(fileID string, metadata *fileMetadata) {
+	if i := sort.SearchStrings(ix.ids, fileID); i < len(ix.ids) && ix.ids[i] == fileID {
+		ix.ids = append(ix.ids[:i], ix.ids[i+1:]...)
+	}
+
+	ix.bySize = removeEntry(ix.bySize, indexEntry{metadata.size, fileID})
+	ix.byCreated = removeEntry(ix.byCreated, indexEntry{metadata.createdAt.Unix(), fileID})
+	ix.byModified = removeEntry(ix.byModified, indexEntry{metadata.modifiedAt.Unix(), fileID})
+	removeFromSet(ix.byType, metadata.mediaType(), fileID)
+	removeFromSet(ix.byExtension, strings.ToLower(metadata.extension), fileID)
+	for key, value := range metadata.attributes {
+		removeFromSet(ix.byAttribute[key], value, fileID)
+		if len(ix.byAttribute[key]) == 0 {
+			delete(ix.byAttribute, key)
+		}
+	}
+}
+
+// prefixed returns the file IDs starting with prefix, in order
+func (ix *fileIndex) prefixed(prefix string) []string {
+	from := sort.SearchStrings(ix.ids, prefix)
+	to := from
+	for to < len(ix.ids) && strings.HasPrefix(ix.ids[to], prefix) {
+		to++
+	}
+	return ix.ids[from:to]
+}
+
+// putFile records the metadata of a file and updates the indexes and usage. The caller must hold m.mu.
+func (m *ManagerNode) putFile(fileID string, metadata *fileMetadata) {
+	if previous, exists := m.files[fileID]; exists {
+		m.index.remove(fileID, previous)
+	}
+	m.files[fileID] = metadata
+	m.index.add(fileID, metadata)
+	m.recharge(fileID)
+}
+
+// sortKey returns the value a file is sorted by, files sorted by ID all share the same key
+func (f *fileMetadata) sortKey(sortBy string) int64 {
+	switch sortBy {
+	case sortBySize:
+		return f.size
+	case sortByCreated:
+		return f.createdAt.Unix()
+	case sortByModified:
+		return f.modifiedAt.Unix()
+	default:
+		return 0
+	}
+}
+
+// mediaType returns the media type the file is served with, without parameters
+func (f *fileMetadata) mediaType() string {
+	return mediaTypeOf(f.servedType())
+}
+
+// mediaTypeOf strips the parameters from a content type
+func mediaTypeOf(contentType string) string {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mediaType))
+}
+
+// matchesContentType reports whether a media type matches a pattern such as text/plain or image/*
+func matchesContentType(mediaType, pattern string) bool {
+	if major, wildcard := strings.CutSuffix(pattern, "*"); wildcard {
+		return strings.HasPrefix(mediaType, major)
+	}
+	return mediaType == mediaTypeOf(pattern)
+}
+
+// inTimeRange reports whether t is within [after, before), zero bounds are open
+func inTimeRange(t, after, before int64) bool {
+	return (after == 0 || t >= after) && (before == 0 || t < before)
+}
+
+// indexRange returns the entries with values in [from, to), to 0 meaning no upper bound
+func indexRange(entries []indexEntry, from, to int64) []indexEntry {
+	start := sort.Search(len(entries), func(i int) bool { return entries[i].value >= from })
+	end := len(entries)
+	if to != 0 {
+		end = sort.Search(len(entries), func(i int) bool { return entries[i].value >= to })
+	}
+	if end < start {
+		end = start
+	}
+	return entries[start:end]
+}
+
+// entryLess orders index entries by value, then by file ID
+func entryLess(a, b indexEntry) bool {
+	if a.value != b.value {
+		return a.value < b.value
+	}
+	return a.fileID < b.fileID
+}
+
+func insertEntry(entries []indexEntry, entry indexEntry) []indexEntry {
+	i := sort.Search(len(entries), func(i int) bool { return !entryLess(entries[i], entry) })
+	entries = append(entries, indexEntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = entry
+	return entries
+}
+
+func removeEntry(entries []indexEntry, entry indexEntry) []indexEntry {
+	i := sort.Search(len(entries), func(i int) bool { return !entryLess(entries[i], entry) })
+	if i < len(entries) && entries[i] == entry {
+		entries = append(entries[:i], entries[i+1:]...)
+	}
+	return entries
+}
+
+func addToSet(sets map[string]map[string]bool, key, fileID string) {
+	if sets[key] == nil {
+		sets[key] = make(map[string]bool)
+	}
+	sets[key][fileID] = true
+}
+
+func removeFromSet(sets map[string]map[string]bool, key, fileID string) {
+	delete(sets[key], fileID)
+	if len(sets[key]) == 0 {
+		delete(sets, key)
+	}
+}
+
+func setToSlice(set map[string]bool) []string {
+	fileIDs := make([]string, 0, len(set))
+	for fileID := range set {
+		fileIDs = append(fileIDs, fileID)
+	}
+	return fileIDs
+}
+
+// encodeCursor turns a cursor into an opaque page token
+func encodeCursor(cursor *pageCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodeCursor is the inverse of encodeCursor
+func decodeCursor(token string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, err
+	}
+	return &cursor, nil
+}
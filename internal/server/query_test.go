@@ -0,0 +1,171 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// queryFiles are the files queried by the tests, modified a minute apart in this order
+var queryFiles = []testFile{
+	{id: "docs/a.txt", chunks: []string{"h1"}, chunkSize: 300, extension: "txt", contentType: "text/plain"},
+	{id: "docs/b.md", chunks: []string{"h2", "h3"}, chunkSize: 100, extension: "md", contentType: "text/markdown",
+		attributes: map[string]string{"team": "data"}},
+	{id: "img/c.png", chunks: []string{"h4"}, chunkSize: 1000, extension: "PNG", contentType: "image/png",
+		attributes: map[string]string{"team": "data", "stage": "final"}},
+	{id: "img/d.jpg", chunks: []string{"h5"}, chunkSize: 50, extension: "jpg", contentType: "image/jpeg"},
+	{id: "logs/e.log", chunks: []string{"h6"}, chunkSize: 200, extension: "log", contentType: "text/plain",
+		attributes: map[string]string{"team": "ops"}},
+}
+
+// queryManager returns a Manager Node holding the query files, authenticating the given users
+func queryManager(t *testing.T, users map[string][]string) *ManagerNode {
+	t.Helper()
+	base := time.Now().Add(-time.Hour)
+	files := make([]testFile, len(queryFiles))
+	for i, f := range queryFiles {
+		f.modifiedAt = base.Add(time.Duration(i) * time.Minute)
+		files[i] = f
+	}
+	return newTestManager(t, users, files...)
+}
+
+// fileIDs lists the IDs of the files in a query response
+func fileIDs(resp *pb.QueryResponse) []string {
+	var ids []string
+	for _, file := range resp.Files {
+		ids = append(ids, file.FileId)
+	}
+	return ids
+}
+
+func TestQueryFilters(t *testing.T) {
+	m := queryManager(t, nil)
+	third := m.files["img/c.png"].modifiedAt.Unix()
+
+	tests := []struct {
+		name string
+		req  *pb.QueryRequest
+		want []string
+	}{
+		{"everything", &pb.QueryRequest{}, []string{"docs/a.txt", "docs/b.md", "img/c.png", "img/d.jpg", "logs/e.log"}},
+		{"prefix", &pb.QueryRequest{Prefix: "img/"}, []string{"img/c.png", "img/d.jpg"}},
+		{"content type", &pb.QueryRequest{ContentType: "text/plain"}, []string{"docs/a.txt", "logs/e.log"}},
+		{"major type", &pb.QueryRequest{ContentType: "image/*"}, []string{"img/c.png", "img/d.jpg"}},
+		{"extension ignores case and dot", &pb.QueryRequest{FileType: ".png"}, []string{"img/c.png"}},
+		{"size range", &pb.QueryRequest{MinSize: 100, MaxSize: 300}, []string{"docs/a.txt", "docs/b.md", "logs/e.log"}},
+		{"attribute", &pb.QueryRequest{Attributes: map[string]string{"team": "data"}}, []string{"docs/b.md", "img/c.png"}},
+		{"attributes together", &pb.QueryRequest{Attributes: map[string]string{"team": "data", "stage": "final"}}, []string{"img/c.png"}},
+		{"modified after", &pb.QueryRequest{ModifiedAfter: third}, []string{"img/c.png", "img/d.jpg", "logs/e.log"}},
+		{"modified before", &pb.QueryRequest{ModifiedBefore: third}, []string{"docs/a.txt", "docs/b.md"}},
+		{"combined", &pb.QueryRequest{Prefix: "img/", MinSize: 100}, []string{"img/c.png"}},
+		{"no match", &pb.QueryRequest{ContentType: "video/*"}, nil},
+		{"sorted by size", &pb.QueryRequest{SortBy: sortBySize}, []string{"img/d.jpg", "docs/b.md", "logs/e.log", "docs/a.txt", "img/c.png"}},
+		{"sorted by modification, newest first", &pb.QueryRequest{SortBy: sortByModified, Descending: true, Prefix: "docs/"}, []string{"docs/b.md", "docs/a.txt"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := m.Query(asUser(anonymousUser), tt.req)
+			if err != nil {
+				t.Fatalf("Query: %v", err)
+			}
+			if got := fileIDs(resp); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryPaging(t *testing.T) {
+	tests := []struct {
+		name       string
+		sortBy     string
+		descending bool
+		pageSize   int32
+		want       []string
+	}{
+		{"by ID", sortByID, false, 2, []string{"docs/a.txt", "docs/b.md", "img/c.png", "img/d.jpg", "logs/e.log"}},
+		{"by size, largest first", sortBySize, true, 2, []string{"img/c.png", "docs/a.txt", "logs/e.log", "docs/b.md", "img/d.jpg"}},
+		{"one page", sortByID, false, 10, []string{"docs/a.txt", "docs/b.md", "img/c.png", "img/d.jpg", "logs/e.log"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := queryManager(t, nil)
+			var got []string
+			token := ""
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want) {
+					t.Fatal("paging doesn't end")
+				}
+				resp, err := m.Query(asUser(anonymousUser), &pb.QueryRequest{SortBy: tt.sortBy, Descending: tt.descending, PageSize: tt.pageSize, PageToken: token})
+				if err != nil {
+					t.Fatalf("Query: %v", err)
+				}
+				if len(resp.Files) > int(tt.pageSize) {
+					t.Fatalf("page has %d files, more than %d", len(resp.Files), tt.pageSize)
+				}
+				got = append(got, fileIDs(resp)...)
+				if token = resp.NextPageToken; token == "" {
+					break
+				}
+
+				// Files deleted between pages don't make later pages skip or repeat files
+				if pages == 0 {
+					m.deleteFile(got[len(got)-1])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryInvalid(t *testing.T) {
+	m := queryManager(t, nil)
+	resp, err := m.Query(asUser(anonymousUser), &pb.QueryRequest{SortBy: sortBySize, PageSize: 1})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  *pb.QueryRequest
+	}{
+		{"unknown sort field", &pb.QueryRequest{SortBy: "name"}},
+		{"negative size", &pb.QueryRequest{MinSize: -1}},
+		{"invalid content type", &pb.QueryRequest{ContentType: "not a type"}},
+		{"garbage page token", &pb.QueryRequest{PageToken: "garbage"}},
+		{"page token of another sort", &pb.QueryRequest{SortBy: sortByCreated, PageToken: resp.NextPageToken}},
+		{"page token of another direction", &pb.QueryRequest{SortBy: sortBySize, Descending: true, PageToken: resp.NextPageToken}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := m.Query(asUser(anonymousUser), tt.req); status.Code(err) != codes.InvalidArgument {
+				t.Errorf("Query error = %v, want InvalidArgument", err)
+			}
+		})
+	}
+}
+
+func TestQueryOnlyReadableFiles(t *testing.T) {
+	m := queryManager(t, map[string][]string{"alice": nil, "bob": nil})
+	m.acls["img/"] = &accessControl{owner: "alice", entries: map[string]int{}}
+
+	for user, want := range map[string][]string{
+		"alice": {"img/c.png", "img/d.jpg"},
+		"bob":   nil,
+	} {
+		resp, err := m.Query(asUser(user), &pb.QueryRequest{Prefix: "img/"})
+		if err != nil {
+			t.Fatalf("Query: %v", err)
+		}
+		if got := fileIDs(resp); !reflect.DeepEqual(got, want) {
+			t.Errorf("Query by %s = %v, want %v", user, got, want)
+		}
+	}
+}
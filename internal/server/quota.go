@@ -0,0 +1,413 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"breezeFS/internal/erasure"
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"sort"
+	"strings"
+)
+
+// usage is the storage taken by a set of files
+type usage struct {
+	files    int64
+	logical  int64 // Size of the current contents of the files
+	physical int64 // Bytes stored for every kept version, counting each replica and shard
+}
+
+// quota limits the usage of an owner or a directory. Zero limits are unlimited.
+type quota struct {
+	hard usage // Uploads exceeding these are rejected
+	soft usage // Uploads exceeding these are accepted with a warning
+}
+
+// charge is the usage a file counts towards its owner and the directories it is in
+type charge struct {
+	owner string
+	usage usage
+}
+
+// SetQuota sets the quota of an owner or a directory, replacing the previous one. Owner quotas
+// need admin permission on the root directory, directory quotas on the directory.
+func (m *ManagerNode) SetQuota(ctx context.Context, req *pb.Quota) (*pb.Quota, error) {
+	if err := validQuotaTarget(req.Owner, req.Path); err != nil {
+		return nil, err
+	}
+	limits := &quota{hard: usageFromProto(req.Hard), soft: usageFromProto(req.Soft)}
+	if err := limits.validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, quotaPath(req.Path), permAdmin); err != nil {
+		return nil, err
+	}
+	if req.Owner != "" {
+		m.ownerQuotas[req.Owner] = limits
+		log.Printf("Quota of owner %s set", req.Owner)
+	} else {
+		m.dirQuotas[req.Path] = limits
+		log.Printf("Quota of directory %s set", req.Path)
+	}
+	return limits.toProto(req.Owner, req.Path), nil
+}
+
+// DeleteQuota removes the quota of an owner or a directory
+func (m *ManagerNode) DeleteQuota(ctx context.Context, req *pb.DeleteQuotaRequest) (*pb.DeleteQuotaResponse, error) {
+	if err := validQuotaTarget(req.Owner, req.Path); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, quotaPath(req.Path), permAdmin); err != nil {
+		return nil, err
+	}
+	quotas, key := m.dirQuotas, req.Path
+	if req.Owner != "" {
+		quotas, key = m.ownerQuotas, req.Owner
+	}
+	if _, exists := quotas[key]; !exists {
+		return nil, status.Errorf(codes.NotFound, "no quota set for %s", key)
+	}
+	delete(quotas, key)
+
+	log.Printf("Quota of %s deleted", key)
+	return &pb.DeleteQuotaResponse{Message: "Quota deleted"}, nil
+}
+
+// GetUsage reports the usage and quota of an owner or a directory or, without either, of every
+// owner and every directory with a quota. Users see their own usage and that of the directories
+// they may read, admins of the root directory see every owner's.
+func (m *ManagerNode) GetUsage(ctx context.Context, req *pb.GetUsageRequest) (*pb.UsageReport, error) {
+	if req.Owner != "" && req.Path != "" {
+		return nil, status.Error(codes.InvalidArgument, "usage is reported for either an owner or a directory")
+	}
+	if req.Path != "" && !strings.HasSuffix(req.Path, "/") {
+		return nil, status.Errorf(codes.InvalidArgument, "directories end in /, got %q", req.Path)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	report := &pb.UsageReport{}
+	switch {
+	case req.Owner != "":
+		if err := m.checkOwnerAccess(ctx, req.Owner); err != nil {
+			return nil, err
+		}
+		report.Entries = append(report.Entries, m.usageEntry(req.Owner, ""))
+
+	case req.Path != "":
+		if err := m.checkAccess(ctx, req.Path, permRead); err != nil {
+			return nil, err
+		}
+		report.Entries = append(report.Entries, m.usageEntry("", req.Path))
+
+	default:
+		for _, owner := range unionKeys(m.ownerUsage, m.ownerQuotas) {
+			if m.checkOwnerAccess(ctx, owner) == nil {
+				report.Entries = append(report.Entries, m.usageEntry(owner, ""))
+			}
+		}
+		paths := unionKeys(nil, m.dirQuotas)
+		if _, exists := m.dirQuotas[rootDirectory]; !exists {
+			paths = append([]string{rootDirectory}, paths...)
+		}
+		for _, path := range paths {
+			if m.checkAccess(ctx, path, permRead) == nil {
+				report.Entries = append(report.Entries, m.usageEntry("", path))
+			}
+		}
+	}
+	return report, nil
+}
+
+// checkQuotas fails with ResourceExhausted if adding delta to the usage of a file's owner and
+// directories would take them over a hard quota, and describes the soft quotas it would exceed.
+// Other uploads in progress count towards the usage. Only limits that delta adds to are
+// checked, so shrinking a file is always allowed. The caller must hold m.mu.
+func (m *ManagerNode) checkQuotas(owner, fileID string, delta usage) (string, error) {
+	var warnings []string
+	check := func(name string, limits *quota, current usage, owns func(string, *pendingUpload) bool) error {
+		projected := current.plus(delta)
+		for uploadID, upload := range m.uploads {
+			if uploadID != fileID && owns(uploadID, upload) {
+				projected = projected.plus(upload.reserved)
+			}
+		}
+		if over := projected.over(limits.hard, delta); over != "" {
+			return status.Errorf(codes.ResourceExhausted, "quota exceeded: %s would have %s", name, over)
+		}
+		if over := projected.over(limits.soft, delta); over != "" {
+			warnings = append(warnings, fmt.Sprintf("%s is over its soft quota with %s", name, over))
+		}
+		return nil
+	}
+
+	if limits, exists := m.ownerQuotas[owner]; exists {
+		owns := func(_ string, upload *pendingUpload) bool { return upload.owner == owner }
+		if err := check("owner "+owner, limits, m.usageOf(m.ownerUsage, owner), owns); err != nil {
+			return "", err
+		}
+	}
+	for _, dir := range fileDirectories(fileID) {
+		limits, exists := m.dirQuotas[dir]
+		if !exists {
+			continue
+		}
+		owns := func(uploadID string, _ *pendingUpload) bool { return inDirectory(uploadID, dir) }
+		if err := check("directory "+dir, limits, m.usageOf(m.dirUsage, dir), owns); err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(warnings, "; "), nil
+}
+
+// uploadDelta returns how an upload of logical bytes, taking physical bytes on Data Nodes,
+// changes the usage of a file. The caller must hold m.mu.
+func (m *ManagerNode) uploadDelta(fileID string, logical, physical int64) usage {
+	delta := usage{files: 1, logical: logical, physical: physical}
+	if metadata, exists := m.files[fileID]; exists {
+		delta.files = 0
+		delta.logical -= metadata.size
+	}
+	return delta
+}
+
+// recharge counts a file towards the usage of its owner and directories again, after its
+// contents, versions or owner changed. The caller must hold m.mu.
+func (m *ManagerNode) recharge(fileID string) {
+	m.uncharge(fileID)
+	if _, exists := m.files[fileID]; !exists {
+		return
+	}
+	c := &charge{owner: m.fileOwner(fileID), usage: m.fileUsage(fileID)}
+	m.charges[fileID] = c
+	m.applyCharge(fileID, c, 1)
+}
+
+// uncharge stops counting a file towards any usage. The caller must hold m.mu.
+func (m *ManagerNode) uncharge(fileID string) {
+	if c, exists := m.charges[fileID]; exists {
+		m.applyCharge(fileID, c, -1)
+		delete(m.charges, fileID)
+	}
+}
+
+// applyCharge adds a file's charge to, or with sign -1 subtracts it from, the usage of its owner
+// and directories. The caller must hold m.mu.
+func (m *ManagerNode) applyCharge(fileID string, c *charge, sign int64) {
+	addUsage(m.ownerUsage, c.owner, c.usage, sign)
+	for _, dir := range fileDirectories(fileID) {
+		addUsage(m.dirUsage, dir, c.usage, sign)
+	}
+}
+
+// fileUsage returns the usage of a file. Chunks shared by several of its versions count once,
+// chunks shared with other files count for each of them. The caller must hold m.mu.
+func (m *ManagerNode) fileUsage(fileID string) usage {
+	u := usage{files: 1, logical: m.files[fileID].size}
+	counted := make(map[string]bool)
+	versions := append([]*fileVersion{m.currentVersion(fileID)}, m.versions[fileID]...)
+	for _, version := range versions {
+		for _, hash := range version.chunks {
+			if !counted[hash] {
+				counted[hash] = true
+				u.physical += m.physicalSize(hash, version.metadata.storageClass)
+			}
+		}
+	}
+	return u
+}
+
+// physicalSize returns the bytes a chunk takes on Data Nodes, with all of its replicas or
+// shards. Chunks stored both ways count as the storage class keeps them. The caller must hold m.mu.
+func (m *ManagerNode) physicalSize(hash, storageClass string) int64 {
+	chunk, exists := m.chunks[hash]
+	if !exists {
+		return 0
+	}
+	if s := chunk.stripe; s != nil && (len(chunk.nodes) == 0 || storageClass == erasure.ClassErasure) {
+		return storedWithParity(chunk.storedSize, s.dataShards, s.parityShards)
+	}
+	return chunk.storedSize * int64(m.wantedReplicas(chunk))
+}
+
+// fileOwner returns the user a file counts towards. Files without an ACL of their own,
+// stored while clients weren't authenticated, belong to the anonymous user.
+// The caller must hold m.mu.
+func (m *ManagerNode) fileOwner(fileID string) string {
+	if acl, exists := m.acls[fileID]; exists {
+		return acl.owner
+	}
+	return anonymousUser
+}
+
+// checkOwnerAccess lets users see their own usage, and admins of the root directory everyone's.
+// The caller must hold m.mu.
+func (m *ManagerNode) checkOwnerAccess(ctx context.Context, owner string) error {
+	if len(m.APIKeys) == 0 || userFromContext(ctx) == owner {
+		return nil
+	}
+	return m.checkAccess(ctx, rootDirectory, permAdmin)
+}
+
+// usageEntry reports the usage and quota of an owner or a directory. The caller must hold m.mu.
+func (m *ManagerNode) usageEntry(owner, path string) *pb.UsageEntry {
+	current, limits := m.usageOf(m.dirUsage, path), m.dirQuotas[path]
+	if owner != "" {
+		current, limits = m.usageOf(m.ownerUsage, owner), m.ownerQuotas[owner]
+	}
+
+	entry := &pb.UsageEntry{Owner: owner, Path: path, Usage: current.toProto()}
+	if limits != nil {
+		entry.Quota = limits.toProto(owner, path)
+		entry.HardExceeded = current.over(limits.hard, current) != ""
+		entry.SoftExceeded = current.over(limits.soft, current) != ""
+	}
+	return entry
+}
+
+// usageOf returns the usage recorded under key, zero if there is none. The caller must hold m.mu.
+func (m *ManagerNode) usageOf(totals map[string]*usage, key string) usage {
+	if total, exists := totals[key]; exists {
+		return *total
+	}
+	return usage{}
+}
+
+// addUsage adds u to, or with sign -1 subtracts it from, the usage recorded under key.
+// Usage that drops to no files is removed.
+func addUsage(totals map[string]*usage, key string, u usage, sign int64) {
+	total, exists := totals[key]
+	if !exists {
+		total = &usage{}
+		totals[key] = total
+	}
+	total.files += sign * u.files
+	total.logical += sign * u.logical
+	total.physical += sign * u.physical
+	if total.files <= 0 {
+		delete(totals, key)
+	}
+}
+
+// plus returns the sum of two usages
+func (u usage) plus(other usage) usage {
+	return usage{files: u.files + other.files, logical: u.logical + other.logical, physical: u.physical + other.physical}
+}
+
+// over describes the first limit u exceeds, counting only the limits that grew by a positive
+// amount of delta, or returns an empty string if there is none. Zero limits are unlimited.
+func (u usage) over(limits, delta usage) string {
+	switch {
+	case limits.files > 0 && delta.files > 0 && u.files > limits.files:
+		return fmt.Sprintf("%d files, over the limit of %d", u.files, limits.files)
+	case limits.logical > 0 && delta.logical > 0 && u.logical > limits.logical:
+		return fmt.Sprintf("%d bytes, over the limit of %d", u.logical, limits.logical)
+	case limits.physical > 0 && delta.physical > 0 && u.physical > limits.physical:
+		return fmt.Sprintf("%d physical bytes, over the limit of %d", u.physical, limits.physical)
+	}
+	return ""
+}
+
+// toProto converts the usage to its protobuf message
+func (u usage) toProto() *pb.Usage {
+	return &pb.Usage{Files: u.files, LogicalBytes: u.logical, PhysicalBytes: u.physical}
+}
+
+// usageFromProto converts limits from their protobuf message, nil meaning no limits
+func usageFromProto(u *pb.Usage) usage {
+	if u == nil {
+		return usage{}
+	}
+	return usage{files: u.Files, logical: u.LogicalBytes, physical: u.PhysicalBytes}
+}
+
+// validate checks limits aren't negative, at least one is set, and soft limits are below hard ones
+func (q *quota) validate() error {
+	limits := []struct {
+		name       string
+		hard, soft int64
+	}{
+		{"files", q.hard.files, q.soft.files},
+		{"logical bytes", q.hard.logical, q.soft.logical},
+		{"physical bytes", q.hard.physical, q.soft.physical},
+	}
+	set := false
+	for _, limit := range limits {
+		if limit.hard < 0 || limit.soft < 0 {
+			return fmt.Errorf("the %s limits can't be negative", limit.name)
+		}
+		if limit.hard > 0 && limit.soft > limit.hard {
+			return fmt.Errorf("the soft %s limit is above the hard limit", limit.name)
+		}
+		set = set || limit.hard > 0 || limit.soft > 0
+	}
+	if !set {
+		return fmt.Errorf("quotas need at least one limit")
+	}
+	return nil
+}
+
+// toProto converts the quota to its protobuf message
+func (q *quota) toProto(owner, path string) *pb.Quota {
+	return &pb.Quota{Owner: owner, Path: path, Hard: q.hard.toProto(), Soft: q.soft.toProto()}
+}
+
+// validQuotaTarget checks a quota names either an owner or a directory
+func validQuotaTarget(owner, path string) error {
+	if (owner == "") == (path == "") {
+		return status.Error(codes.InvalidArgument, "quotas apply to either an owner or a directory")
+	}
+	if path != "" && !strings.HasSuffix(path, "/") {
+		return status.Errorf(codes.InvalidArgument, "quotas apply to directories, which end in /, got %q", path)
+	}
+	return nil
+}
+
+// quotaPath returns the path whose admins manage a quota, the root directory for owner quotas
+func quotaPath(path string) string {
+	if path == "" {
+		return rootDirectory
+	}
+	return path
+}
+
+// fileDirectories lists the directories a file is in, from its parent up to the root
+func fileDirectories(fileID string) []string {
+	return aclLookupPaths(fileID)[1:]
+}
+
+// inDirectory reports whether a file is in a directory or any directory below it
+func inDirectory(fileID, dir string) bool {
+	return dir == rootDirectory || strings.HasPrefix(fileID, dir)
+}
+
+// storedWithParity returns the bytes a chunk takes once split into data shards and parity shards are added
+func storedWithParity(size int64, dataShards, parityShards int) int64 {
+	return size * int64(dataShards+parityShards) / int64(dataShards)
+}
+
+// unionKeys returns the keys of both maps in order
+func unionKeys(usages map[string]*usage, quotas map[string]*quota) []string {
+	keys := make([]string, 0, len(usages)+len(quotas))
+	for key := range usages {
+		keys = append(keys, key)
+	}
+	for key := range quotas {
+		if _, exists := usages[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
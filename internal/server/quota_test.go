@@ -0,0 +1,114 @@
+package server
+
+import (
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUsageAccounting(t *testing.T) {
+	m := newTestManager(t, nil)
+	storeFile(t, m, testFile{id: "proj/a", owner: "alice", chunks: []string{"h1", "h2"}, chunkSize: 100})
+	storeFile(t, m, testFile{id: "proj/b", owner: "alice", chunks: []string{"h2"}, chunkSize: 100})
+	storeFile(t, m, testFile{id: "docs/c", owner: "bob", chunks: []string{"h3"}, chunkSize: 50})
+
+	// Overwriting keeps the previous version, whose chunks still count once
+	storeFile(t, m, testFile{id: "docs/c", owner: "bob", chunks: []string{"h3", "h4"}, chunkSize: 50})
+
+	tests := []struct {
+		name   string
+		totals map[string]*usage
+		key    string
+		want   usage
+	}{
+		// Chunks shared with other files count for each, every chunk has two replicas
+		{"owner alice", m.ownerUsage, "alice", usage{files: 2, logical: 300, physical: 600}},
+		{"owner bob", m.ownerUsage, "bob", usage{files: 1, logical: 100, physical: 200}},
+		{"directory proj/", m.dirUsage, "proj/", usage{files: 2, logical: 300, physical: 600}},
+		{"root directory", m.dirUsage, rootDirectory, usage{files: 3, logical: 400, physical: 800}},
+		{"unused owner", m.ownerUsage, "carol", usage{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.usageOf(tt.totals, tt.key); got != tt.want {
+				t.Errorf("usage = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	// Deleting a file stops counting it
+	m.deleteFile("proj/b")
+	if got, want := m.usageOf(m.ownerUsage, "alice"), (usage{files: 1, logical: 200, physical: 400}); got != want {
+		t.Errorf("usage after delete = %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckQuotas(t *testing.T) {
+	tests := []struct {
+		name        string
+		fileID      string
+		owner       string
+		logical     int64
+		physical    int64
+		pending     map[string]*pendingUpload
+		wantCode    codes.Code
+		wantWarning string
+	}{
+		{name: "within limits", fileID: "docs/new", owner: "alice", logical: 100, physical: 200},
+		{name: "over the soft limit", fileID: "docs/new", owner: "alice", logical: 350, physical: 700, wantWarning: "owner alice is over its soft quota"},
+		{name: "over the hard limit", fileID: "docs/new", owner: "alice", logical: 600, physical: 1200, wantCode: codes.ResourceExhausted},
+		{name: "owner without a quota", fileID: "docs/new", owner: "bob", logical: 10000, physical: 20000},
+		{name: "overwrite adds the difference", fileID: "proj/a", owner: "alice", logical: 700, physical: 200},
+		{name: "shrinking while over", fileID: "proj/a", owner: "alice", logical: 100, physical: 200,
+			pending: map[string]*pendingUpload{"docs/big": {owner: "alice", reserved: usage{files: 1, logical: 5000}}}},
+		{name: "directory file limit", fileID: "proj/c", owner: "bob", logical: 1, physical: 2,
+			pending: map[string]*pendingUpload{"proj/b": {owner: "bob", reserved: usage{files: 1}}}, wantCode: codes.ResourceExhausted},
+		{name: "pending upload of the same file", fileID: "proj/c", owner: "bob", logical: 1, physical: 2,
+			pending: map[string]*pendingUpload{"proj/c": {owner: "bob", reserved: usage{files: 1}}}},
+		{name: "other owner's uploads", fileID: "docs/new", owner: "alice", logical: 100, physical: 200,
+			pending: map[string]*pendingUpload{"docs/other": {owner: "bob", reserved: usage{files: 1, logical: 5000}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil)
+			m.ownerQuotas["alice"] = &quota{hard: usage{logical: 1000}, soft: usage{logical: 800}}
+			m.dirQuotas["proj/"] = &quota{hard: usage{files: 2}}
+			storeFile(t, m, testFile{id: "proj/a", owner: "alice", chunks: []string{"h1", "h2", "h3", "h4", "h5"}, chunkSize: 100})
+			for fileID, upload := range tt.pending {
+				m.uploads[fileID] = upload
+			}
+
+			warning, err := m.checkQuotas(tt.owner, tt.fileID, m.uploadDelta(tt.fileID, tt.logical, tt.physical))
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("checkQuotas error = %v, want code %v", err, tt.wantCode)
+			}
+			if !strings.Contains(warning, tt.wantWarning) || (tt.wantWarning == "" && warning != "") {
+				t.Errorf("checkQuotas warning = %q, want %q", warning, tt.wantWarning)
+			}
+		})
+	}
+}
+
+func TestQuotaValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   quota
+		wantErr bool
+	}{
+		{"hard limit", quota{hard: usage{files: 10}}, false},
+		{"soft below hard", quota{hard: usage{logical: 10}, soft: usage{logical: 5}}, false},
+		{"soft without hard", quota{soft: usage{physical: 5}}, false},
+		{"no limits", quota{}, true},
+		{"negative", quota{hard: usage{files: -1}}, true},
+		{"soft above hard", quota{hard: usage{logical: 5}, soft: usage{logical: 10}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.quota.validate(); (err != nil) != tt.wantErr {
+				t.Errorf("validate error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,272 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// defaultRebalanceThreshold is the allowed deviation from the mean utilisation when none is given
+const defaultRebalanceThreshold = 0.1
+
+// chunkMove describes moving one replica of a chunk from one Data Node to another
+type chunkMove struct {
+	hash   string
+	source string // Node ID holding the replica
+	target string // Node ID receiving the replica
+}
+
+// Rebalance moves chunk replicas from over-full to under-full nodes on demand
+func (m *ManagerNode) Rebalance(ctx context.Context, req *pb.RebalanceRequest) (*pb.RebalanceResponse, error) {
+	if err := m.checkAdmin(ctx); err != nil {
+		return nil, err
+	}
+	moved, err := m.runRebalance(req.Threshold, req.BandwidthLimit)
+	if err != nil {
+		return nil, err
+	}
+	return &pb.RebalanceResponse{
+		MovedChunks: int32(moved),
+		Message:     fmt.Sprintf("Moved %d chunk replicas", moved),
+	}, nil
+}
+
+// StartRebalancer periodically rebalances the cluster in the background
+func (m *ManagerNode) StartRebalancer(interval time.Duration, threshold float64, bandwidthLimit int64) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			moved, err := m.runRebalance(threshold, bandwidthLimit)
+			if err != nil {
+				log.Printf("Background rebalance failed: %v", err)
+				continue
+			}
+			if moved > 0 {
+				log.Printf("Background rebalance moved %d chunk replicas", moved)
+			}
+		}
+	}()
+}
+
+// runRebalance plans and executes chunk moves. Only one rebalance runs at a time.
+func (m *ManagerNode) runRebalance(threshold float64, bandwidthLimit int64) (int, error) {
+	if !m.rebalanceMu.TryLock() {
+		return 0, fmt.Errorf("rebalance already in progress")
+	}
+	defer m.rebalanceMu.Unlock()
+
+	if threshold <= 0 {
+		threshold = defaultRebalanceThreshold
+	}
+
+	m.mu.Lock()
+	moves := m.planRebalance(threshold)
+	m.mu.Unlock()
+
+	moved := 0
+	for _, move := range moves {
+		updated, err := m.transferReplica(move, bandwidthLimit)
+		if err != nil {
+			log.Printf("Failed to move chunk %s: %v", move.hash, err)
+			continue
+		}
+		if !updated {
+			continue
+		}
+
+		m.mu.Lock()
+		sourceAddress := m.nodes[move.source]
+		m.mu.Unlock()
+
+		if err := m.deleteChunk(sourceAddress, move.hash); err != nil {
+			log.Printf("Failed to delete chunk %s from %s: %v", move.hash, move.source, err)
+		}
+
+		log.Printf("Moved chunk %s from %s to %s", move.hash, move.source, move.target)
+		moved++
+	}
+
+	return moved, nil
+}
+
+// transferReplica copies a chunk replica to the move's target and points the mapping at the copy.
+// It returns false if the chunk changed during the copy, in which case the copy is discarded.
+func (m *ManagerNode) transferReplica(move chunkMove, bandwidthLimit int64) (bool, error) {
+	key := replicaKey(move.target, move.hash)
+	m.mu.Lock()
+	sourceAddress, targetAddress := m.nodes[move.source], m.nodes[move.target]
+	m.transfers[key] = true
+	m.mu.Unlock()
+
+	// Copy and verify without holding the lock, transfers can take a while
+	err := m.copyChunk(sourceAddress, targetAddress, move.hash, bandwidthLimit)
+
+	m.mu.Lock()
+	updated := err == nil && m.replaceReplica(move.hash, move.source, move.target)
+	delete(m.transfers, key)
+	m.mu.Unlock()
+
+	if err != nil {
+		return false, err
+	}
+	if !updated {
+		m.deleteChunk(targetAddress, move.hash)
+	}
+	return updated, nil
+}
+
+// nodeUtilisation counts the chunk replicas stored on each active node.
+// The caller must hold m.mu.
+func (m *ManagerNode) nodeUtilisation() map[string]int {
+	usage := make(map[string]int, len(m.nodes))
+	for _, nodeID := range m.activeNodes() {
+		usage[nodeID] = 0
+	}
+	for _, chunk := range m.chunks {
+		for _, nodeID := range chunk.nodes {
+			if _, exists := usage[nodeID]; exists {
+				usage[nodeID]++
+			}
+		}
+	}
+	return usage
+}
+
+// planRebalance computes the chunk moves needed to bring every node within
+// threshold of the mean utilisation. The caller must hold m.mu.
+func (m *ManagerNode) planRebalance(threshold float64) []chunkMove {
+	usage := m.nodeUtilisation()
+	if len(usage) < 2 {
+		return nil
+	}
+
+	total := 0
+	for _, count := range usage {
+		total += count
+	}
+	mean := float64(total) / float64(len(usage))
+	upper := mean * (1 + threshold)
+	lower := mean * (1 - threshold)
+
+	// Index the chunks held by each node, in a stable order
+	nodeChunks := make(map[string][]chunkMove)
+	for _, hash := range m.sortedChunks() {
+		for _, nodeID := range m.chunks[hash].nodes {
+			nodeChunks[nodeID] = append(nodeChunks[nodeID], chunkMove{hash: hash, source: nodeID})
+		}
+	}
+
+	var moves []chunkMove
+	planned := make(map[string]bool) // Chunks already scheduled to move
+
+	for {
+		source, target := mostAndLeastUsed(usage)
+		if float64(usage[source]) <= upper && float64(usage[target]) >= lower {
+			break
+		}
+		if usage[source]-usage[target] <= 1 {
+			break
+		}
+
+		// Pick a chunk on the source that the target doesn't already hold
+		found := false
+		for i, candidate := range nodeChunks[source] {
+			if planned[candidate.hash] || m.placementConflict(candidate.hash, target) {
+				continue
+			}
+
+			candidate.target = target
+			moves = append(moves, candidate)
+			planned[candidate.hash] = true
+			nodeChunks[source] = append(nodeChunks[source][:i], nodeChunks[source][i+1:]...)
+			usage[source]--
+			usage[target]++
+			found = true
+			break
+		}
+		if !found {
+			break
+		}
+	}
+
+	return moves
+}
+
+// replaceReplica swaps source for target in a chunk's replica list.
+// It returns false if the chunk no longer matches. The caller must hold m.mu.
+func (m *ManagerNode) replaceReplica(hash, source, target string) bool {
+	chunk, exists := m.chunks[hash]
+	if !exists || containsNode(chunk.nodes, target) {
+		return false
+	}
+	for i, nodeID := range chunk.nodes {
+		if nodeID == source {
+			// Copy the slice, it may be shared with an in-flight response
+			updated := append([]string(nil), chunk.nodes...)
+			updated[i] = target
+			chunk.nodes = updated
+			return true
+		}
+	}
+	return false
+}
+
+// removeReplica drops a node from a chunk's replica list. The caller must hold m.mu.
+func (m *ManagerNode) removeReplica(hash, nodeID string) {
+	chunk, exists := m.chunks[hash]
+	if !exists {
+		return
+	}
+	updated := make([]string, 0, len(chunk.nodes))
+	for _, n := range chunk.nodes {
+		if n != nodeID {
+			updated = append(updated, n)
+		}
+	}
+	chunk.nodes = updated
+}
+
+// sortedChunks returns the hashes of all stored chunks in a stable order. The caller must hold m.mu.
+func (m *ManagerNode) sortedChunks() []string {
+	hashes := make([]string, 0, len(m.chunks))
+	for hash := range m.chunks {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// mostAndLeastUsed returns the nodes with the highest and lowest chunk counts
+func mostAndLeastUsed(usage map[string]int) (string, string) {
+	nodeIDs := make([]string, 0, len(usage))
+	for nodeID := range usage {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Strings(nodeIDs)
+
+	most, least := nodeIDs[0], nodeIDs[0]
+	for _, nodeID := range nodeIDs[1:] {
+		if usage[nodeID] > usage[most] {
+			most = nodeID
+		}
+		if usage[nodeID] < usage[least] {
+			least = nodeID
+		}
+	}
+	return most, least
+}
+
+// containsNode reports whether nodeID is in the list
+func containsNode(nodeIDs []string, nodeID string) bool {
+	for _, n := range nodeIDs {
+		if n == nodeID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unbalancedFiles leaves n1 and n2 with three chunks each and n3 with none
+var unbalancedFiles = []testFile{
+	{id: "a", chunks: []string{"h1"}, chunkSize: 10},
+	{id: "b", chunks: []string{"h2"}, chunkSize: 10},
+	{id: "c", chunks: []string{"h3"}, chunkSize: 10},
+}
+
+func TestPlanRebalance(t *testing.T) {
+	tests := []struct {
+		name      string
+		nodes     []string // Nodes registered besides n1 and n2
+		draining  []string
+		threshold float64
+		want      []chunkMove
+	}{
+		{name: "balanced", threshold: 0.1},
+		{
+			name:      "empty node",
+			nodes:     []string{"n3"},
+			threshold: 0.1,
+			want: []chunkMove{
+				{hash: "h1", source: "n1", target: "n3"},
+				{hash: "h2", source: "n2", target: "n3"},
+			},
+		},
+		{name: "within threshold", nodes: []string{"n3"}, threshold: 2},
+		{name: "draining node", nodes: []string{"n3"}, draining: []string{"n3"}, threshold: 0.1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, unbalancedFiles...)
+			for _, nodeID := range tt.nodes {
+				m.nodes[nodeID] = "127.0.0.1:3"
+			}
+			for _, nodeID := range tt.draining {
+				m.decommissions[nodeID] = &decommission{state: decommissionDraining}
+			}
+
+			if got := m.planRebalance(tt.threshold); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("planRebalance() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceReplica(t *testing.T) {
+	tests := []struct {
+		name   string
+		hash   string
+		source string
+		target string
+		want   bool
+		nodes  []string
+	}{
+		{name: "moved", hash: "h1", source: "n1", target: "n3", want: true, nodes: []string{"n3", "n2"}},
+		{name: "target holds chunk", hash: "h1", source: "n1", target: "n2", nodes: []string{"n1", "n2"}},
+		{name: "source dropped chunk", hash: "h1", source: "n4", target: "n3", nodes: []string{"n1", "n2"}},
+		{name: "chunk deleted", hash: "gone", source: "n1", target: "n3"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, testFile{id: "a", chunks: []string{"h1"}, chunkSize: 10})
+			nodes := m.chunks["h1"].nodes
+
+			if got := m.replaceReplica(tt.hash, tt.source, tt.target); got != tt.want {
+				t.Errorf("replaceReplica() = %v, want %v", got, tt.want)
+			}
+			if tt.nodes != nil && !reflect.DeepEqual(m.chunks[tt.hash].nodes, tt.nodes) {
+				t.Errorf("nodes = %v, want %v", m.chunks[tt.hash].nodes, tt.nodes)
+			}
+			if !reflect.DeepEqual(nodes, []string{"n1", "n2"}) {
+				t.Errorf("replaceReplica() modified the previous replica list in place: %v", nodes)
+			}
+		})
+	}
+}
+
+func TestRunRebalance(t *testing.T) {
+	tests := []struct {
+		name      string
+		checksum  string // Checksum reported by n3 for its copies
+		fail      bool   // n3 fails every command
+		wantMoved int
+		wantNodes map[string][]string
+	}{
+		{
+			name:      "moved",
+			checksum:  "sum",
+			wantMoved: 2,
+			wantNodes: map[string][]string{"h1": {"n3", "n2"}, "h2": {"n1", "n3"}, "h3": {"n1", "n2"}},
+		},
+		{
+			name:      "checksum mismatch",
+			checksum:  "corrupt",
+			wantNodes: map[string][]string{"h1": {"n1", "n2"}, "h2": {"n1", "n2"}, "h3": {"n1", "n2"}},
+		},
+		{
+			name:      "target unavailable",
+			fail:      true,
+			wantNodes: map[string][]string{"h1": {"n1", "n2"}, "h2": {"n1", "n2"}, "h3": {"n1", "n2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, unbalancedFiles...)
+			n1 := startDataNode(t, m, "n1")
+			startDataNode(t, m, "n2")
+			n3 := startDataNode(t, m, "n3")
+			n3.checksum, n3.fail = tt.checksum, tt.fail
+
+			moved, err := m.runRebalance(0, 0)
+			if err != nil {
+				t.Fatalf("runRebalance() error = %v", err)
+			}
+			if moved != tt.wantMoved {
+				t.Errorf("runRebalance() moved %d chunks, want %d", moved, tt.wantMoved)
+			}
+			for hash, want := range tt.wantNodes {
+				if got := m.chunks[hash].nodes; !reflect.DeepEqual(got, want) {
+					t.Errorf("nodes of %s = %v, want %v", hash, got, want)
+				}
+			}
+
+			// The source replica goes once the copy is in place, a bad copy is removed from the target
+			var wantDeleted []string
+			if tt.wantMoved > 0 {
+				wantDeleted = []string{"h1"}
+			}
+			if got := n1.deletedChunks(); !reflect.DeepEqual(got, wantDeleted) {
+				t.Errorf("chunks deleted from n1 = %v, want %v", got, wantDeleted)
+			}
+			if tt.checksum == "corrupt" {
+				if got := n3.deletedChunks(); len(got) != 2 {
+					t.Errorf("chunks deleted from n3 = %v, want both bad copies", got)
+				}
+			}
+		})
+	}
+}
+
+func TestRebalanceRequiresAdmin(t *testing.T) {
+	m := newTestManager(t, map[string][]string{"root": nil, "alice": nil})
+	m.acls[rootDirectory] = &accessControl{owner: "root", entries: make(map[string]int)}
+
+	if _, err := m.Rebalance(asUser("alice"), &pb.RebalanceRequest{}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Rebalance() by a user error = %v, want PermissionDenied", err)
+	}
+	if _, err := m.Rebalance(asUser("root"), &pb.RebalanceRequest{}); err != nil {
+		t.Errorf("Rebalance() by the root owner error = %v", err)
+	}
+}
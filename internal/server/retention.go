@@ -0,0 +1,335 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// retentionRule sets when the files in a directory, or those with an attribute, expire and how
+// long they can't be deleted or overwritten. Both count from the file's last modification.
+type retentionRule struct {
+	path        string        // Directory the rule applies to, / for every file
+	attribute   string        // Only files with this attribute, empty for every file
+	value       string        // Value the attribute must have, empty for any
+	expireAfter time.Duration // Files expire this long after they were modified, 0 for never
+	retainFor   time.Duration // Files are retained this long after they were modified
+}
+
+// SetRetention sets when a file expires, extends how long it is retained, and places or releases a
+// legal hold on it. Setting the expiry needs write permission, retention and legal holds need admin.
+func (m *ManagerNode) SetRetention(ctx context.Context, req *pb.SetRetentionRequest) (*pb.FileInfo, error) {
+	if req.RetainUntil < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid retention time %d", req.RetainUntil)
+	}
+	if req.LegalHold && req.ReleaseHold {
+		return nil, status.Error(codes.InvalidArgument, "can't both place and release a legal hold")
+	}
+	if req.ClearExpiry && (req.ExpiresAt != 0 || req.Ttl != 0) {
+		return nil, status.Error(codes.InvalidArgument, "can't both set and clear the expiry")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	perm := permWrite
+	if req.RetainUntil != 0 || req.LegalHold || req.ReleaseHold {
+		perm = permAdmin
+	}
+	if err := m.checkAccess(ctx, req.FileId, perm); err != nil {
+		return nil, err
+	}
+	metadata, exists := m.files[req.FileId]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+
+	now := time.Now()
+	updated := *metadata
+	if req.ClearExpiry {
+		updated.expiresAt = time.Time{}
+	} else if req.ExpiresAt != 0 || req.Ttl != 0 {
+		expiresAt, err := expiryFromRequest(req.ExpiresAt, req.Ttl, now)
+		if err != nil {
+			return nil, err
+		}
+		updated.expiresAt = expiresAt
+	}
+	if req.RetainUntil != 0 {
+		retainUntil := time.Unix(req.RetainUntil, 0)
+		if retainUntil.Before(metadata.retainUntil) {
+			return nil, status.Errorf(codes.FailedPrecondition, "file %s is retained until %s, retention can only be extended",
+				req.FileId, metadata.retainUntil.Format(time.RFC3339))
+		}
+		updated.retainUntil = retainUntil
+	}
+	if req.LegalHold || req.ReleaseHold {
+		updated.legalHold = req.LegalHold
+		log.Printf("User %s set the legal hold on file %s to %t", userFromContext(ctx), req.FileId, req.LegalHold)
+	}
+	m.putFile(req.FileId, &updated)
+
+	return m.fileInfo(req.FileId), nil
+}
+
+// SetRetentionRule adds a retention rule, or replaces the one with the same name. While a rule
+// retains files, it can only be replaced by one retaining the same files for longer.
+// Rules need admin permission on their directory.
+func (m *ManagerNode) SetRetentionRule(ctx context.Context, req *pb.RetentionRule) (*pb.RetentionRule, error) {
+	if !validName(req.Name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid rule name %q, expected letters, digits and any of - _ .", req.Name)
+	}
+	path := req.Path
+	if path == "" {
+		path = rootDirectory
+	}
+	if !strings.HasSuffix(path, "/") {
+		return nil, status.Errorf(codes.InvalidArgument, "rules apply to directories, which end in /, got %q", path)
+	}
+	if req.AttributeKey == "" && req.AttributeValue != "" {
+		return nil, status.Error(codes.InvalidArgument, "an attribute value needs an attribute key")
+	}
+	if req.AttributeKey != "" {
+		if err := validateAttributes(map[string]string{req.AttributeKey: req.AttributeValue}); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if req.ExpireAfter < 0 || req.RetainFor < 0 || req.ExpireAfter == 0 && req.RetainFor == 0 {
+		return nil, status.Error(codes.InvalidArgument, "rules need a positive expiry or retention period")
+	}
+	if req.ExpireAfter != 0 && req.ExpireAfter < req.RetainFor {
+		return nil, status.Error(codes.InvalidArgument, "files can't expire before their retention period ends")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, path, permAdmin); err != nil {
+		return nil, err
+	}
+	rule := &retentionRule{
+		path:        path,
+		attribute:   req.AttributeKey,
+		value:       req.AttributeValue,
+		expireAfter: time.Duration(req.ExpireAfter) * time.Second,
+		retainFor:   time.Duration(req.RetainFor) * time.Second,
+	}
+	if previous, exists := m.retentionRules[req.Name]; exists {
+		if err := m.checkAccess(ctx, previous.path, permAdmin); err != nil {
+			return nil, err
+		}
+		// A replacement must retain every file the rule applies to at least as long
+		extended := rule.path == previous.path && rule.attribute == previous.attribute &&
+			rule.value == previous.value && rule.retainFor >= previous.retainFor
+		if !extended {
+			if err := m.checkRuleRetention(req.Name, previous, time.Now()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	m.retentionRules[req.Name] = rule
+
+	log.Printf("Retention rule %s set on %s", req.Name, path)
+	return rule.toProto(req.Name), nil
+}
+
+// ListRetentionRules returns the retention rules of every directory the user may read
+func (m *ManagerNode) ListRetentionRules(ctx context.Context, req *pb.ListRetentionRulesRequest) (*pb.ListRetentionRulesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.retentionRules))
+	for name := range m.retentionRules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resp := &pb.ListRetentionRulesResponse{}
+	for _, name := range names {
+		rule := m.retentionRules[name]
+		if m.checkAccess(ctx, rule.path, permRead) == nil {
+			resp.Rules = append(resp.Rules, rule.toProto(name))
+		}
+	}
+	return resp, nil
+}
+
+// DeleteRetentionRule deletes a retention rule, unless it still retains files
+func (m *ManagerNode) DeleteRetentionRule(ctx context.Context, req *pb.DeleteRetentionRuleRequest) (*pb.DeleteRetentionRuleResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rule, exists := m.retentionRules[req.Name]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "retention rule %s not found", req.Name)
+	}
+	if err := m.checkAccess(ctx, rule.path, permAdmin); err != nil {
+		return nil, err
+	}
+	if err := m.checkRuleRetention(req.Name, rule, time.Now()); err != nil {
+		return nil, err
+	}
+	delete(m.retentionRules, req.Name)
+
+	log.Printf("Retention rule %s deleted", req.Name)
+	return &pb.DeleteRetentionRuleResponse{Message: "Retention rule deleted"}, nil
+}
+
+// StartExpirer periodically deletes expired files in the background
+func (m *ManagerNode) StartExpirer(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if expired := m.expireFiles(time.Now()); expired > 0 {
+				log.Printf("Deleted %d expired files", expired)
+			}
+		}
+	}()
+}
+
+// expireFiles deletes the files that expired and aren't retained, and returns how many were deleted
+func (m *ManagerNode) expireFiles(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	expired := 0
+	for fileID, metadata := range m.files {
+		expiresAt := m.expiry(fileID, metadata)
+		if expiresAt.IsZero() || now.Before(expiresAt) {
+			continue
+		}
+		// Retained files expire once their retention ends or their hold is released, leased ones once released
+		if m.checkRetention(fileID, now) != nil || m.checkLease(fileID, "", now) != nil {
+			continue
+		}
+		m.deleteFile(fileID)
+		log.Printf("File %s expired at %s", fileID, expiresAt.Format(time.RFC3339))
+		expired++
+	}
+	return expired
+}
+
+// checkRetention fails if a file is under legal hold or retained, so it can't be deleted or
+// overwritten. The caller must hold m.mu.
+func (m *ManagerNode) checkRetention(fileID string, now time.Time) error {
+	metadata, exists := m.files[fileID]
+	if !exists {
+		return nil
+	}
+	if metadata.legalHold {
+		return status.Errorf(codes.FailedPrecondition, "file %s is under legal hold", fileID)
+	}
+	if retainUntil := m.retainedUntil(fileID, metadata); now.Before(retainUntil) {
+		return status.Errorf(codes.FailedPrecondition, "file %s is retained until %s", fileID, retainUntil.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// checkRuleRetention fails if a rule still retains a file, so it can't be deleted or have its
+// retention shortened. The caller must hold m.mu.
+func (m *ManagerNode) checkRuleRetention(name string, rule *retentionRule, now time.Time) error {
+	if rule.retainFor == 0 {
+		return nil
+	}
+	for fileID, metadata := range m.files {
+		if !rule.matches(fileID, metadata) {
+			continue
+		}
+		if retainUntil := metadata.modifiedAt.Add(rule.retainFor); now.Before(retainUntil) {
+			return status.Errorf(codes.FailedPrecondition, "retention rule %s retains file %s until %s, its retention can only be extended",
+				name, fileID, retainUntil.Format(time.RFC3339))
+		}
+	}
+	return nil
+}
+
+// expiry returns when a file expires: the time set on the file, otherwise the earliest set by
+// a rule that applies to it, zero if it never does. The caller must hold m.mu.
+func (m *ManagerNode) expiry(fileID string, metadata *fileMetadata) time.Time {
+	if !metadata.expiresAt.IsZero() {
+		return metadata.expiresAt
+	}
+	var expiresAt time.Time
+	for _, rule := range m.retentionRules {
+		if rule.expireAfter == 0 || !rule.matches(fileID, metadata) {
+			continue
+		}
+		if ruleExpiry := metadata.modifiedAt.Add(rule.expireAfter); expiresAt.IsZero() || ruleExpiry.Before(expiresAt) {
+			expiresAt = ruleExpiry
+		}
+	}
+	return expiresAt
+}
+
+// retainedUntil returns when the retention of a file ends: the latest of the time set on the
+// file and those set by the rules that apply to it. The caller must hold m.mu.
+func (m *ManagerNode) retainedUntil(fileID string, metadata *fileMetadata) time.Time {
+	retainUntil := metadata.retainUntil
+	for _, rule := range m.retentionRules {
+		if rule.retainFor == 0 || !rule.matches(fileID, metadata) {
+			continue
+		}
+		if ruleRetention := metadata.modifiedAt.Add(rule.retainFor); ruleRetention.After(retainUntil) {
+			retainUntil = ruleRetention
+		}
+	}
+	return retainUntil
+}
+
+// matches reports whether the rule applies to a file
+func (r *retentionRule) matches(fileID string, metadata *fileMetadata) bool {
+	if r.path != rootDirectory && !strings.HasPrefix(fileID, r.path) {
+		return false
+	}
+	if r.attribute == "" {
+		return true
+	}
+	value, exists := metadata.attributes[r.attribute]
+	return exists && (r.value == "" || value == r.value)
+}
+
+// toProto converts the rule to its protobuf message
+func (r *retentionRule) toProto(name string) *pb.RetentionRule {
+	return &pb.RetentionRule{
+		Name:           name,
+		Path:           r.path,
+		AttributeKey:   r.attribute,
+		AttributeValue: r.value,
+		ExpireAfter:    int64(r.expireAfter / time.Second),
+		RetainFor:      int64(r.retainFor / time.Second),
+	}
+}
+
+// expiryFromRequest returns the expiry time given as a Unix time or as seconds from now,
+// zero if neither is set
+func expiryFromRequest(expiresAt, ttl int64, now time.Time) (time.Time, error) {
+	switch {
+	case ttl < 0 || expiresAt < 0:
+		return time.Time{}, status.Error(codes.InvalidArgument, "expiry times must be positive")
+	case ttl > 0 && expiresAt > 0:
+		return time.Time{}, status.Error(codes.InvalidArgument, "give either an expiry time or a TTL, not both")
+	case ttl > 0:
+		return now.Add(time.Duration(ttl) * time.Second), nil
+	case expiresAt > 0:
+		if expiry := time.Unix(expiresAt, 0); expiry.After(now) {
+			return expiry, nil
+		}
+		return time.Time{}, status.Error(codes.InvalidArgument, "expiry time is in the past")
+	}
+	return time.Time{}, nil
+}
+
+// unixOrZero returns t in Unix seconds, or 0 for the zero time
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCheckRetention(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name      string
+		fileID    string
+		file      testFile
+		set       func(*fileMetadata)
+		rules     map[string]*retentionRule
+		wantError bool
+	}{
+		{name: "not retained", fileID: "a", file: testFile{}},
+		{name: "missing file", fileID: ""},
+		{name: "legal hold", fileID: "a", set: func(f *fileMetadata) { f.legalHold = true }, wantError: true},
+		{name: "retained until later", fileID: "a", set: func(f *fileMetadata) { f.retainUntil = now.Add(time.Hour) }, wantError: true},
+		{name: "retention ended", fileID: "a", set: func(f *fileMetadata) { f.retainUntil = now.Add(-time.Second) }},
+		{
+			name:      "rule on the directory",
+			fileID:    "logs/a",
+			rules:     map[string]*retentionRule{"keep": {path: "logs/", retainFor: time.Hour}},
+			wantError: true,
+		},
+		{
+			name:   "rule on another directory",
+			fileID: "docs/a",
+			rules:  map[string]*retentionRule{"keep": {path: "logs/", retainFor: time.Hour}},
+		},
+		{
+			name:      "rule on a matching attribute",
+			fileID:    "a",
+			file:      testFile{attributes: map[string]string{"class": "audit"}},
+			rules:     map[string]*retentionRule{"audit": {path: rootDirectory, attribute: "class", value: "audit", retainFor: time.Hour}},
+			wantError: true,
+		},
+		{
+			name:   "rule on another attribute value",
+			fileID: "a",
+			file:   testFile{attributes: map[string]string{"class": "scratch"}},
+			rules:  map[string]*retentionRule{"audit": {path: rootDirectory, attribute: "class", value: "audit", retainFor: time.Hour}},
+		},
+		{
+			name:   "rule retention ended",
+			fileID: "logs/a",
+			file:   testFile{modifiedAt: now.Add(-2 * time.Hour)},
+			rules:  map[string]*retentionRule{"keep": {path: "logs/", retainFor: time.Hour}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil)
+			if tt.fileID != "" {
+				tt.file.id = tt.fileID
+				storeFile(t, m, tt.file)
+				if tt.set != nil {
+					tt.set(m.files[tt.fileID])
+				}
+			}
+			for name, rule := range tt.rules {
+				m.retentionRules[name] = rule
+			}
+
+			err := m.checkRetention(tt.fileID, now)
+			if (err != nil) != tt.wantError {
+				t.Fatalf("checkRetention error = %v, want error %v", err, tt.wantError)
+			}
+			if err != nil && status.Code(err) != codes.FailedPrecondition {
+				t.Errorf("checkRetention code = %v, want FailedPrecondition", status.Code(err))
+			}
+		})
+	}
+}
+
+func TestExpireFiles(t *testing.T) {
+	now := time.Now()
+	m := newTestManager(t, nil)
+	m.retentionRules["tmp"] = &retentionRule{path: "tmp/", expireAfter: time.Hour}
+	m.retentionRules["hold"] = &retentionRule{path: "tmp/held/", retainFor: 3 * time.Hour}
+
+	old := now.Add(-2 * time.Hour)
+	storeFile(t, m, testFile{id: "tmp/old", chunks: []string{"h1"}, chunkSize: 10, modifiedAt: old})
+	storeFile(t, m, testFile{id: "tmp/new", chunks: []string{"h2"}, chunkSize: 10})
+	storeFile(t, m, testFile{id: "tmp/held/old", chunks: []string{"h3"}, chunkSize: 10, modifiedAt: old})
+	storeFile(t, m, testFile{id: "docs/old", chunks: []string{"h4"}, chunkSize: 10, modifiedAt: old})
+	storeFile(t, m, testFile{id: "docs/expiring", chunks: []string{"h5"}, chunkSize: 10})
+	m.files["docs/expiring"].expiresAt = now.Add(-time.Minute)
+
+	if got := m.expireFiles(now); got != 2 {
+		t.Errorf("expireFiles = %d, want 2", got)
+	}
+	for fileID, want := range map[string]bool{
+		"tmp/old":       false,
+		"tmp/new":       true,
+		"tmp/held/old":  true, // Retained longer than it lives
+		"docs/old":      true,
+		"docs/expiring": false,
+	} {
+		if _, exists := m.files[fileID]; exists != want {
+			t.Errorf("file %s exists = %v, want %v", fileID, exists, want)
+		}
+	}
+	if _, exists := m.chunks["h1"]; exists {
+		t.Error("chunk of an expired file is still recorded")
+	}
+}
+
+func TestRetentionRuleOnlyExtends(t *testing.T) {
+	keep := &pb.RetentionRule{Name: "keep", Path: "logs/", RetainFor: 3600}
+	tests := []struct {
+		name     string
+		modified time.Duration // How long ago the retained file was last changed
+		change   func(m *ManagerNode) error
+		wantCode codes.Code
+		want     *pb.RetentionRule // Rule in effect afterwards, nil for none
+	}{
+		{
+			name:   "extended",
+			change: setRule(&pb.RetentionRule{Name: "keep", Path: "logs/", RetainFor: 7200}),
+			want:   &pb.RetentionRule{Name: "keep", Path: "logs/", RetainFor: 7200},
+		},
+		{
+			name:   "expiry added",
+			change: setRule(&pb.RetentionRule{Name: "keep", Path: "logs/", RetainFor: 3600, ExpireAfter: 7200}),
+			want:   &pb.RetentionRule{Name: "keep", Path: "logs/", RetainFor: 3600, ExpireAfter: 7200},
+		},
+		{
+			name:     "shortened",
+			change:   setRule(&pb.RetentionRule{Name: "keep", Path: "logs/", RetainFor: 60}),
+			wantCode: codes.FailedPrecondition,
+			want:     keep,
+		},
+		{
+			name:     "retention removed",
+			change:   setRule(&pb.RetentionRule{Name: "keep", Path: "logs/", ExpireAfter: 7200}),
+			wantCode: codes.FailedPrecondition,
+			want:     keep,
+		},
+		{
+			name:     "moved to another directory",
+			change:   setRule(&pb.RetentionRule{Name: "keep", Path: "other/", RetainFor: 7200}),
+			wantCode: codes.FailedPrecondition,
+			want:     keep,
+		},
+		{
+			name:     "narrowed to an attribute",
+			change:   setRule(&pb.RetentionRule{Name: "keep", Path: "logs/", AttributeKey: "class", RetainFor: 7200}),
+			wantCode: codes.FailedPrecondition,
+			want:     keep,
+		},
+		{
+			name:     "deleted",
+			change:   deleteRule("keep"),
+			wantCode: codes.FailedPrecondition,
+			want:     keep,
+		},
+		{
+			name:     "shortened after the retention ended",
+			modified: 2 * time.Hour,
+			change:   setRule(&pb.RetentionRule{Name: "keep", Path: "logs/", RetainFor: 60}),
+			want:     &pb.RetentionRule{Name: "keep", Path: "logs/", RetainFor: 60},
+		},
+		{
+			name:     "deleted after the retention ended",
+			modified: 2 * time.Hour,
+			change:   deleteRule("keep"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, testFile{id: "logs/a", chunks: []string{"h1"}, chunkSize: 10, modifiedAt: time.Now().Add(-tt.modified)})
+			if _, err := m.SetRetentionRule(context.Background(), keep); err != nil {
+				t.Fatalf("SetRetentionRule() error = %v", err)
+			}
+
+			if err := tt.change(m); status.Code(err) != tt.wantCode {
+				t.Fatalf("error = %v, want code %v", err, tt.wantCode)
+			}
+			resp, err := m.ListRetentionRules(context.Background(), &pb.ListRetentionRulesRequest{})
+			if err != nil {
+				t.Fatalf("ListRetentionRules() error = %v", err)
+			}
+			var rule *pb.RetentionRule
+			if len(resp.Rules) > 0 {
+				rule = resp.Rules[0]
+			}
+			if !proto.Equal(rule, tt.want) {
+				t.Errorf("rule = %v, want %v", rule, tt.want)
+			}
+		})
+	}
+}
+
+// setRule returns a change that sets a retention rule
+func setRule(rule *pb.RetentionRule) func(m *ManagerNode) error {
+	return func(m *ManagerNode) error {
+		_, err := m.SetRetentionRule(context.Background(), rule)
+		return err
+	}
+}
+
+// deleteRule returns a change that deletes a retention rule
+func deleteRule(name string) func(m *ManagerNode) error {
+	return func(m *ManagerNode) error {
+		_, err := m.DeleteRetentionRule(context.Background(), &pb.DeleteRetentionRuleRequest{Name: name})
+		return err
+	}
+}
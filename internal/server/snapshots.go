@@ -0,0 +1,169 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshot is a read-only copy of the namespace, or of a directory, at a point in time.
+// Versions are never changed once created, so a snapshot shares them with the live
+// namespace and only pins their chunks.
+type snapshot struct {
+	path      string                  // Directory captured, / for the whole namespace
+	createdAt time.Time               // When the snapshot was taken
+	files     map[string]*fileVersion // FileID -> version current when the snapshot was taken
+}
+
+// CreateSnapshot captures the current version of every file in a directory, or in the whole
+// namespace. Taking a snapshot needs admin permission on the directory.
+func (m *ManagerNode) CreateSnapshot(ctx context.Context, req *pb.CreateSnapshotRequest) (*pb.Snapshot, error) {
+	if !validName(req.Name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid snapshot name %q, expected letters, digits and any of - _ .", req.Name)
+	}
+	path := req.Path
+	if path == "" {
+		path = rootDirectory
+	}
+	if !strings.HasSuffix(path, "/") {
+		return nil, status.Errorf(codes.InvalidArgument, "snapshots capture directories, which end in /, got %q", path)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, path, permAdmin); err != nil {
+		return nil, err
+	}
+	if _, exists := m.snapshots[req.Name]; exists {
+		return nil, status.Errorf(codes.AlreadyExists, "snapshot %s already exists", req.Name)
+	}
+
+	s := &snapshot{path: path, createdAt: time.Now(), files: make(map[string]*fileVersion)}
+	prefix := path
+	if path == rootDirectory {
+		prefix = ""
+	}
+	fileIDs := m.index.prefixed(prefix)
+	for _, fileID := range fileIDs {
+		if err := m.checkChunks(fileID, m.currentVersion(fileID).chunks); err != nil {
+			return nil, err
+		}
+	}
+	for _, fileID := range fileIDs {
+		version := m.currentVersion(fileID)
+		for _, hash := range version.chunks {
+			m.chunks[hash].refs++
+		}
+		s.files[fileID] = version
+	}
+	m.snapshots[req.Name] = s
+
+	log.Printf("Snapshot %s of %s taken with %d files", req.Name, path, len(s.files))
+	return s.toProto(req.Name), nil
+}
+
+// ListSnapshots returns every snapshot of a directory the user may read
+func (m *ManagerNode) ListSnapshots(ctx context.Context, req *pb.ListSnapshotsRequest) (*pb.ListSnapshotsResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.snapshots))
+	for name := range m.snapshots {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resp := &pb.ListSnapshotsResponse{}
+	for _, name := range names {
+		s := m.snapshots[name]
+		if m.checkAccess(ctx, s.path, permRead) == nil {
+			resp.Snapshots = append(resp.Snapshots, s.toProto(name))
+		}
+	}
+	return resp, nil
+}
+
+// DeleteSnapshot deletes a snapshot, releasing the chunks only it still refers to
+func (m *ManagerNode) DeleteSnapshot(ctx context.Context, req *pb.DeleteSnapshotRequest) (*pb.DeleteSnapshotResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, exists := m.snapshots[req.Name]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "snapshot %s not found", req.Name)
+	}
+	if err := m.checkAccess(ctx, s.path, permAdmin); err != nil {
+		return nil, err
+	}
+
+	for _, version := range s.files {
+		m.releaseVersion(version)
+	}
+	delete(m.snapshots, req.Name)
+
+	log.Printf("Snapshot %s deleted", req.Name)
+	return &pb.DeleteSnapshotResponse{Message: "Snapshot deleted"}, nil
+}
+
+// snapshotVersion returns the version of a file captured by a snapshot. The caller must hold m.mu.
+func (m *ManagerNode) snapshotVersion(name, fileID string) (*fileVersion, error) {
+	s, exists := m.snapshots[name]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "snapshot %s not found", name)
+	}
+	version, exists := s.files[fileID]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "file %s is not in snapshot %s", fileID, name)
+	}
+	return version, nil
+}
+
+// snapshotFiles returns the IDs of the files captured by a snapshot that start with prefix, in order.
+// The caller must hold m.mu.
+func (m *ManagerNode) snapshotFiles(name, prefix string) ([]string, error) {
+	s, exists := m.snapshots[name]
+	if !exists {
+		return nil, status.Errorf(codes.NotFound, "snapshot %s not found", name)
+	}
+	var fileIDs []string
+	for fileID := range s.files {
+		if strings.HasPrefix(fileID, prefix) {
+			fileIDs = append(fileIDs, fileID)
+		}
+	}
+	sort.Strings(fileIDs)
+	return fileIDs, nil
+}
+
+// toProto converts the snapshot to its protobuf message
+func (s *snapshot) toProto(name string) *pb.Snapshot {
+	response := &pb.Snapshot{
+		Name:      name,
+		Path:      s.path,
+		CreatedAt: s.createdAt.Unix(),
+		Files:     int32(len(s.files)),
+	}
+	for _, version := range s.files {
+		response.Size += version.metadata.size
+	}
+	return response
+}
+
+// validName reports whether name is a non-empty identifier
+func validName(name string) bool {
+	if name == "" || len(name) > 128 {
+		return false
+	}
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var snapshottedFiles = []testFile{
+	{id: "docs/a", chunks: []string{"a1"}, chunkSize: 10},
+	{id: "docs/b", chunks: []string{"b1"}, chunkSize: 10},
+	{id: "logs/c", chunks: []string{"c1"}, chunkSize: 10},
+}
+
+func TestCreateSnapshot(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       *pb.CreateSnapshotRequest
+		wantCode  codes.Code
+		wantFiles []string
+	}{
+		{name: "whole namespace", req: &pb.CreateSnapshotRequest{Name: "all"}, wantFiles: []string{"docs/a", "docs/b", "logs/c"}},
+		{name: "directory", req: &pb.CreateSnapshotRequest{Name: "docs", Path: "docs/"}, wantFiles: []string{"docs/a", "docs/b"}},
+		{name: "empty directory", req: &pb.CreateSnapshotRequest{Name: "tmp", Path: "tmp/"}},
+		{name: "existing name", req: &pb.CreateSnapshotRequest{Name: "taken"}, wantCode: codes.AlreadyExists},
+		{name: "invalid name", req: &pb.CreateSnapshotRequest{Name: "a/b"}, wantCode: codes.InvalidArgument},
+		{name: "file path", req: &pb.CreateSnapshotRequest{Name: "file", Path: "docs/a"}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, snapshottedFiles...)
+			ctx := context.Background()
+			if _, err := m.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: "taken"}); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := m.CreateSnapshot(ctx, tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("CreateSnapshot() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err != nil {
+				return
+			}
+
+			resp, err := m.ListFiles(ctx, &pb.ListFilesRequest{Snapshot: tt.req.Name})
+			if err != nil {
+				t.Fatalf("ListFiles() error = %v", err)
+			}
+			var files []string
+			for _, file := range resp.Files {
+				files = append(files, file.FileId)
+			}
+			if !reflect.DeepEqual(files, tt.wantFiles) {
+				t.Errorf("snapshot files = %v, want %v", files, tt.wantFiles)
+			}
+		})
+	}
+}
+
+func TestSnapshotKeepsContents(t *testing.T) {
+	m := newTestManager(t, nil, snapshottedFiles...)
+	ctx := context.Background()
+	if _, err := m.CreateSnapshot(ctx, &pb.CreateSnapshotRequest{Name: "before", Path: "docs/"}); err != nil {
+		t.Fatalf("CreateSnapshot() error = %v", err)
+	}
+
+	// Changing the live namespace leaves the snapshot as it was
+	storeFile(t, m, testFile{id: "docs/a", chunks: []string{"a2"}, chunkSize: 10})
+	m.pruneVersions("docs/a", 0)
+	m.deleteFile("docs/b")
+
+	for fileID, want := range map[string]string{"docs/a": "a1", "docs/b": "b1"} {
+		resp, err := m.GetChunkLocations(ctx, &pb.GetChunkLocationsRequest{FileId: fileID, Snapshot: "before"})
+		if err != nil {
+			t.Fatalf("GetChunkLocations(%s) error = %v", fileID, err)
+		}
+		if len(resp.Chunks) != 1 || resp.Chunks[0].Hash != want {
+			t.Errorf("chunks of %s in the snapshot = %v, want %s", fileID, resp.Chunks, want)
+		}
+	}
+	if _, err := m.GetChunkLocations(ctx, &pb.GetChunkLocationsRequest{FileId: "docs/a", Snapshot: "before", Version: 1}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("GetChunkLocations() of a version in a snapshot error = %v, want InvalidArgument", err)
+	}
+	if _, err := m.GetChunkLocations(ctx, &pb.GetChunkLocationsRequest{FileId: "logs/c", Snapshot: "before"}); status.Code(err) != codes.NotFound {
+		t.Errorf("GetChunkLocations() of a file outside the snapshot error = %v, want NotFound", err)
+	}
+
+	// Deleting the snapshot releases the chunks only it referred to
+	if _, err := m.DeleteSnapshot(ctx, &pb.DeleteSnapshotRequest{Name: "before"}); err != nil {
+		t.Fatalf("DeleteSnapshot() error = %v", err)
+	}
+	for hash, wantStored := range map[string]bool{"a1": false, "b1": false, "a2": true, "c1": true} {
+		if _, stored := m.chunks[hash]; stored != wantStored {
+			t.Errorf("chunk %s recorded = %v, want %v", hash, stored, wantStored)
+		}
+	}
+	if _, err := m.DeleteSnapshot(ctx, &pb.DeleteSnapshotRequest{Name: "before"}); status.Code(err) != codes.NotFound {
+		t.Errorf("DeleteSnapshot() of a deleted snapshot error = %v, want NotFound", err)
+	}
+}
+
+func TestSnapshotPermissions(t *testing.T) {
+	m := newTestManager(t, map[string][]string{"alice": nil, "bob": nil}, snapshottedFiles...)
+	m.acls["docs/"] = &accessControl{owner: "alice", entries: map[string]int{}}
+	alice, bob := asUser("alice"), asUser("bob")
+
+	if _, err := m.CreateSnapshot(bob, &pb.CreateSnapshotRequest{Name: "docs", Path: "docs/"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("CreateSnapshot() by a user without admin permission error = %v, want PermissionDenied", err)
+	}
+	if _, err := m.CreateSnapshot(alice, &pb.CreateSnapshotRequest{Name: "docs", Path: "docs/"}); err != nil {
+		t.Fatalf("CreateSnapshot() by the directory owner error = %v", err)
+	}
+
+	for user, want := range map[string]int{"alice": 1, "bob": 0} {
+		resp, err := m.ListSnapshots(asUser(user), &pb.ListSnapshotsRequest{})
+		if err != nil {
+			t.Fatalf("ListSnapshots() error = %v", err)
+		}
+		if len(resp.Snapshots) != want {
+			t.Errorf("ListSnapshots() by %s = %d snapshots, want %d", user, len(resp.Snapshots), want)
+		}
+	}
+	if _, err := m.DeleteSnapshot(bob, &pb.DeleteSnapshotRequest{Name: "docs"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("DeleteSnapshot() by a user without admin permission error = %v, want PermissionDenied", err)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"io"
+	"time"
+)
+
+// rateLimitedReader wraps a reader and caps its throughput to a fixed number of bytes per second
+type rateLimitedReader struct {
+	r     io.Reader
+	rate  int64 // Bytes per second, 0 means unlimited
+	read  int64
+	start time.Time
+}
+
+// newRateLimitedReader returns r unchanged when rate is zero
+func newRateLimitedReader(r io.Reader, rate int64) io.Reader {
+	if rate <= 0 {
+		return r
+	}
+	return &rateLimitedReader{r: r, rate: rate, start: time.Now()}
+}
+
+func (l *rateLimitedReader) Read(p []byte) (int, error) {
+	// Never read more than one second worth of data at a time
+	if int64(len(p)) > l.rate {
+		p = p[:l.rate]
+	}
+
+	n, err := l.r.Read(p)
+	l.read += int64(n)
+
+	// Sleep until the elapsed time catches up with the amount of data read
+	expected := time.Duration(float64(l.read) / float64(l.rate) * float64(time.Second))
+	if elapsed := time.Since(l.start); expected > elapsed {
+		time.Sleep(expected - elapsed)
+	}
+	return n, err
+}
@@ -0,0 +1,220 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	"time"
+)
+
+// defaultVersionsKept is the number of previous versions kept of every file unless configured otherwise
+const defaultVersionsKept = 5
+
+// fileVersion is the contents of a file at one version. Previous versions keep their
+// chunks referenced until they are pruned.
+type fileVersion struct {
+	chunks     map[int32]string // ChunkID -> chunk hash
+	metadata   *fileMetadata
+	encryption *pb.FileEncryption
+}
+
+// ListVersions returns the current and previous versions of a file, newest first
+func (m *ManagerNode) ListVersions(ctx context.Context, req *pb.ListVersionsRequest) (*pb.ListVersionsResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permRead); err != nil {
+		return nil, err
+	}
+	current := m.currentVersion(req.FileId)
+	if current == nil {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+
+	resp := &pb.ListVersionsResponse{Versions: []*pb.FileInfo{m.versionInfo(req.FileId, current)}}
+	previous := m.versions[req.FileId]
+	for i := len(previous) - 1; i >= 0; i-- {
+		resp.Versions = append(resp.Versions, m.versionInfo(req.FileId, previous[i]))
+	}
+	return resp, nil
+}
+
+// RestoreVersion makes the contents of a previous version the current version of a file.
+// The version being replaced is kept as a previous version, like on upload.
+func (m *ManagerNode) RestoreVersion(ctx context.Context, req *pb.RestoreVersionRequest) (*pb.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+	current := m.currentVersion(req.FileId)
+	if current == nil {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+	now := time.Now()
+	if err := m.checkRetention(req.FileId, now); err != nil {
+		return nil, err
+	}
+	if err := m.checkLease(req.FileId, "", now); err != nil {
+		return nil, err
+	}
+	if req.Version == current.metadata.version {
+		return m.versionInfo(req.FileId, current), nil
+	}
+	restored := m.previousVersion(req.FileId, req.Version)
+	if restored == nil {
+		return nil, status.Errorf(codes.NotFound, "file %s has no version %d", req.FileId, req.Version)
+	}
+
+	// The restored contents share chunks with the version they are copied from
+	if err := m.checkChunks(req.FileId, restored.chunks); err != nil {
+		return nil, err
+	}
+	chunks := make(map[int32]string, len(restored.chunks))
+	for chunkID, hash := range restored.chunks {
+		m.chunks[hash].refs++
+		chunks[chunkID] = hash
+	}
+	metadata := *restored.metadata
+	metadata.attributes = copyAttributes(current.metadata.attributes)
+	metadata.createdAt = current.metadata.createdAt
+	metadata.expiresAt = current.metadata.expiresAt
+	metadata.retainUntil = current.metadata.retainUntil
+	metadata.legalHold = current.metadata.legalHold
+
+	m.replaceVersion(req.FileId, &fileVersion{chunks: chunks, metadata: &metadata, encryption: restored.encryption}, now)
+
+	log.Printf("Restored version %d of file %s as version %d", req.Version, req.FileId, metadata.version)
+	return m.versionInfo(req.FileId, m.currentVersion(req.FileId)), nil
+}
+
+// checkChunks fails with FailedPrecondition unless every chunk of a version of a file is still
+// recorded, so new references are only taken to chunks that exist. The caller must hold m.mu.
+func (m *ManagerNode) checkChunks(fileID string, chunks map[int32]string) error {
+	for chunkID, hash := range chunks {
+		if _, exists := m.chunks[hash]; !exists {
+			return status.Errorf(codes.FailedPrecondition, "chunk %d of file %s is no longer stored", chunkID, fileID)
+		}
+	}
+	return nil
+}
+
+// PruneVersions deletes a previous version of a file, or all but the newest ones
+func (m *ManagerNode) PruneVersions(ctx context.Context, req *pb.PruneVersionsRequest) (*pb.PruneVersionsResponse, error) {
+	if req.Keep < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "versions to keep can't be negative, got %d", req.Keep)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkAccess(ctx, req.FileId, permWrite); err != nil {
+		return nil, err
+	}
+	current := m.currentVersion(req.FileId)
+	if current == nil {
+		return nil, status.Errorf(codes.NotFound, "file %s not found", req.FileId)
+	}
+	if err := m.checkRetention(req.FileId, time.Now()); err != nil {
+		return nil, err
+	}
+
+	if req.Version == 0 {
+		return &pb.PruneVersionsResponse{Pruned: int32(m.pruneVersions(req.FileId, int(req.Keep)))}, nil
+	}
+	if req.Version == current.metadata.version {
+		return nil, status.Errorf(codes.FailedPrecondition, "version %d is the current version of file %s", req.Version, req.FileId)
+	}
+
+	previous := m.versions[req.FileId]
+	for i, version := range previous {
+		if version.metadata.version == req.Version {
+			m.releaseVersion(version)
+			m.versions[req.FileId] = append(previous[:i:i], previous[i+1:]...)
+			m.recharge(req.FileId)
+			return &pb.PruneVersionsResponse{Pruned: 1}, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "file %s has no version %d", req.FileId, req.Version)
+}
+
+// replaceVersion makes version the current version of a file, numbering it after the one it
+// replaces, which is kept as a previous version. The caller must hold m.mu.
+func (m *ManagerNode) replaceVersion(fileID string, version *fileVersion, now time.Time) {
+	version.metadata.version = 1
+	if current := m.currentVersion(fileID); current != nil {
+		version.metadata.version = current.metadata.version + 1
+		m.versions[fileID] = append(m.versions[fileID], current)
+	}
+	version.metadata.modifiedAt = now
+
+	m.chunkMapping[fileID] = version.chunks
+	m.putFile(fileID, version.metadata)
+	if version.encryption != nil {
+		m.encryption[fileID] = version.encryption
+	} else {
+		delete(m.encryption, fileID)
+	}
+
+	m.pruneVersions(fileID, m.VersionsKept)
+}
+
+// pruneVersions deletes all but the newest keep previous versions of a file and returns
+// how many were deleted. The caller must hold m.mu.
+func (m *ManagerNode) pruneVersions(fileID string, keep int) int {
+	previous := m.versions[fileID]
+	if len(previous) <= keep {
+		return 0
+	}
+	pruned := len(previous) - keep
+	for _, version := range previous[:pruned] {
+		m.releaseVersion(version)
+	}
+	if keep == 0 {
+		delete(m.versions, fileID)
+	} else {
+		m.versions[fileID] = append([]*fileVersion(nil), previous[pruned:]...)
+	}
+	m.recharge(fileID)
+	return pruned
+}
+
+// releaseVersion drops a deleted version's references to its chunks. The caller must hold m.mu.
+func (m *ManagerNode) releaseVersion(version *fileVersion) {
+	for _, hash := range version.chunks {
+		m.releaseChunk(hash)
+	}
+}
+
+// currentVersion returns the current version of a file, or nil if it doesn't exist.
+// The caller must hold m.mu.
+func (m *ManagerNode) currentVersion(fileID string) *fileVersion {
+	metadata, exists := m.files[fileID]
+	if !exists {
+		return nil
+	}
+	return &fileVersion{chunks: m.chunkMapping[fileID], metadata: metadata, encryption: m.encryption[fileID]}
+}
+
+// previousVersion returns a previous version of a file, or nil if it was pruned or never existed.
+// The caller must hold m.mu.
+func (m *ManagerNode) previousVersion(fileID string, number int64) *fileVersion {
+	for _, version := range m.versions[fileID] {
+		if version.metadata.version == number {
+			return version
+		}
+	}
+	return nil
+}
+
+// findVersion returns a version of a file, 0 meaning the current one. The caller must hold m.mu.
+func (m *ManagerNode) findVersion(fileID string, number int64) *fileVersion {
+	current := m.currentVersion(fileID)
+	if current == nil || number == 0 || number == current.metadata.version {
+		return current
+	}
+	return m.previousVersion(fileID, number)
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	pb "breezeFS/breezeFS/proto"
+	"context"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// versionedFiles stores file a three times, version n holding chunk vn
+var versionedFiles = []testFile{
+	{id: "a", chunks: []string{"v1"}, chunkSize: 10},
+	{id: "a", chunks: []string{"v2"}, chunkSize: 20},
+	{id: "a", chunks: []string{"v3"}, chunkSize: 30},
+}
+
+// versionNumbers lists the versions of file a, newest first
+func versionNumbers(t *testing.T, m *ManagerNode) []int64 {
+	t.Helper()
+	resp, err := m.ListVersions(context.Background(), &pb.ListVersionsRequest{FileId: "a"})
+	if err != nil {
+		t.Fatalf("ListVersions() error = %v", err)
+	}
+	var numbers []int64
+	for _, version := range resp.Versions {
+		numbers = append(numbers, version.Version)
+	}
+	return numbers
+}
+
+func TestReplaceVersion(t *testing.T) {
+	tests := []struct {
+		name         string
+		versionsKept int
+		want         []int64
+		wantReleased []string
+	}{
+		{name: "all kept", versionsKept: defaultVersionsKept, want: []int64{3, 2, 1}},
+		{name: "one kept", versionsKept: 1, want: []int64{3, 2}, wantReleased: []string{"v1"}},
+		{name: "none kept", versionsKept: 0, want: []int64{3}, wantReleased: []string{"v1", "v2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil)
+			m.VersionsKept = tt.versionsKept
+			for _, f := range versionedFiles {
+				storeFile(t, m, f)
+			}
+
+			if got := versionNumbers(t, m); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("versions = %v, want %v", got, tt.want)
+			}
+			for _, hash := range tt.wantReleased {
+				if _, exists := m.chunks[hash]; exists {
+					t.Errorf("chunk %s of a pruned version is still recorded", hash)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreVersion(t *testing.T) {
+	tests := []struct {
+		name      string
+		version   int64
+		lost      string // Chunk record lost before restoring
+		wantCode  codes.Code
+		wantChunk string // Chunk of the current version afterwards
+	}{
+		{name: "previous version", version: 1, wantChunk: "v1"},
+		{name: "current version", version: 3, wantChunk: "v3"},
+		{name: "unknown version", version: 7, wantCode: codes.NotFound, wantChunk: "v3"},
+		{name: "chunk lost", version: 1, lost: "v1", wantCode: codes.FailedPrecondition, wantChunk: "v3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, versionedFiles...)
+			delete(m.chunks, tt.lost)
+
+			_, err := m.RestoreVersion(context.Background(), &pb.RestoreVersionRequest{FileId: "a", Version: tt.version})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("RestoreVersion() error = %v, want code %v", err, tt.wantCode)
+			}
+			if chunk := m.chunkMapping["a"][0]; chunk != tt.wantChunk {
+				t.Errorf("current chunk = %s, want %s", chunk, tt.wantChunk)
+			}
+			if tt.wantCode == codes.OK && tt.version != 3 {
+				if refs := m.chunks[tt.wantChunk].refs; refs != 2 {
+					t.Errorf("refs of the restored chunk = %d, want 2", refs)
+				}
+				if got, want := versionNumbers(t, m), []int64{4, 3, 2, 1}; !reflect.DeepEqual(got, want) {
+					t.Errorf("versions = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneVersions(t *testing.T) {
+	tests := []struct {
+		name       string
+		req        *pb.PruneVersionsRequest
+		wantCode   codes.Code
+		wantPruned int32
+		want       []int64
+	}{
+		{name: "one version", req: &pb.PruneVersionsRequest{FileId: "a", Version: 1}, wantPruned: 1, want: []int64{3, 2}},
+		{name: "all but the newest", req: &pb.PruneVersionsRequest{FileId: "a", Keep: 1}, wantPruned: 1, want: []int64{3, 2}},
+		{name: "all", req: &pb.PruneVersionsRequest{FileId: "a"}, wantPruned: 2, want: []int64{3}},
+		{name: "current version", req: &pb.PruneVersionsRequest{FileId: "a", Version: 3}, wantCode: codes.FailedPrecondition, want: []int64{3, 2, 1}},
+		{name: "unknown version", req: &pb.PruneVersionsRequest{FileId: "a", Version: 7}, wantCode: codes.NotFound, want: []int64{3, 2, 1}},
+		{name: "negative keep", req: &pb.PruneVersionsRequest{FileId: "a", Keep: -1}, wantCode: codes.InvalidArgument, want: []int64{3, 2, 1}},
+		{name: "unknown file", req: &pb.PruneVersionsRequest{FileId: "b"}, wantCode: codes.NotFound, want: []int64{3, 2, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, versionedFiles...)
+
+			resp, err := m.PruneVersions(context.Background(), tt.req)
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("PruneVersions() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err == nil && resp.Pruned != tt.wantPruned {
+				t.Errorf("PruneVersions() pruned %d, want %d", resp.Pruned, tt.wantPruned)
+			}
+			if got := versionNumbers(t, m); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("versions = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetChunkLocationsOfVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		version  int64
+		lost     string
+		wantCode codes.Code
+		wantHash string
+	}{
+		{name: "current version", wantHash: "v3"},
+		{name: "previous version", version: 2, wantHash: "v2"},
+		{name: "chunk lost", version: 1, lost: "v1", wantCode: codes.FailedPrecondition},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestManager(t, nil, versionedFiles...)
+			delete(m.chunks, tt.lost)
+
+			resp, err := m.GetChunkLocations(context.Background(), &pb.GetChunkLocationsRequest{FileId: "a", Version: tt.version})
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("GetChunkLocations() error = %v, want code %v", err, tt.wantCode)
+			}
+			if err == nil && (len(resp.Chunks) != 1 || resp.Chunks[0].Hash != tt.wantHash) {
+				t.Errorf("GetChunkLocations() chunks = %v, want %s", resp.Chunks, tt.wantHash)
+			}
+		})
+	}
+}